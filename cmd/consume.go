@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"fold/internal/consumer"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// runConsume implements the consume subcommand:
+//
+//	fold consume
+//
+// It applies the sync events of SQS_QUEUE_URL to the Elasticsearch index until interrupted.
+func runConsume(args []string) int {
+	if len(args) > 0 {
+		fmt.Fprintln(os.Stderr, "usage: consume")
+		return 2
+	}
+
+	index, err := consumer.NewElasticsearch()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to configure Elasticsearch:", err)
+		return 1
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	fmt.Println("Consuming sync events")
+	err = consumer.Run(ctx, index)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"fold/internal/consumer"
+	"os"
+	"strconv"
+	"text/tabwriter"
+)
+
+// dlqHold is how long scanned dead letters stay hidden, long enough for a scan and redrive to finish.
+const dlqHold = 60
+
+// runDLQ implements the dlq subcommand:
+//
+//	fold dlq list [-project id]
+//	fold dlq inspect <message-id>
+//	fold dlq redrive [-project id] [-all] [message-id ...]
+//
+// It works on the dead-letter queue in SQS_DLQ_URL; redrive sends messages back to SQS_QUEUE_URL.
+func runDLQ(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: dlq list|inspect|redrive [flags] [message-id ...]")
+		return 2
+	}
+	action := args[0]
+
+	flags := flag.NewFlagSet("dlq "+action, flag.ContinueOnError)
+	projectId := flags.Int("project", 0, "only messages of this project ID")
+	all := flags.Bool("all", false, "redrive every message")
+	if err := flags.Parse(args[1:]); err != nil {
+		return 2
+	}
+	ids := map[string]bool{}
+	for _, id := range flags.Args() {
+		ids[id] = true
+	}
+
+	dlqURL := os.Getenv("SQS_DLQ_URL")
+	if dlqURL == "" {
+		fmt.Fprintln(os.Stderr, "SQS_DLQ_URL is not set")
+		return 1
+	}
+	ctx := context.Background()
+
+	switch action {
+	case "list":
+		if len(ids) > 0 {
+			fmt.Fprintln(os.Stderr, "usage: dlq list [-project id]")
+			return 2
+		}
+		out := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(out, "MESSAGE ID\tEVENT TYPE\tPROJECT\tFAILURE\tRECEIVES\tFAILED AT\tREASON")
+		count := 0
+		err := consumer.ScanDeadLetters(ctx, dlqURL, *projectId, dlqHold, func(letter consumer.DeadLetter) error {
+			count++
+			project := ""
+			if letter.ProjectID != 0 {
+				project = strconv.Itoa(letter.ProjectID)
+			}
+			_, err := fmt.Fprintf(out, "%s\t%s\t%s\t%s\t%d\t%s\t%s\n", letter.MessageID, letter.EventType, project,
+				letter.FailureKind, letter.ReceiveCount, letter.FailedAt, letter.FailureReason)
+			return err
+		})
+		out.Flush()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Failed to list dead letters:", err)
+			return 1
+		}
+		fmt.Printf("%d message(s)\n", count)
+
+	case "inspect":
+		if len(ids) != 1 {
+			fmt.Fprintln(os.Stderr, "usage: dlq inspect <message-id>")
+			return 2
+		}
+		var found *consumer.DeadLetter
+		err := consumer.ScanDeadLetters(ctx, dlqURL, 0, dlqHold, func(letter consumer.DeadLetter) error {
+			if ids[letter.MessageID] {
+				found = &letter
+			}
+			return nil
+		})
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Failed to scan dead letters:", err)
+			return 1
+		}
+		if found == nil {
+			fmt.Fprintln(os.Stderr, "Message not found in the dead-letter queue")
+			return 1
+		}
+		output, err := json.MarshalIndent(found, "", "  ")
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		fmt.Println(string(output))
+
+	case "redrive":
+		if !*all && *projectId == 0 && len(ids) == 0 {
+			fmt.Fprintln(os.Stderr, "usage: dlq redrive [-project id] [-all] [message-id ...]")
+			return 2
+		}
+		queueURL := os.Getenv("SQS_QUEUE_URL")
+		if queueURL == "" {
+			fmt.Fprintln(os.Stderr, "SQS_QUEUE_URL is not set")
+			return 1
+		}
+		count := 0
+		err := consumer.ScanDeadLetters(ctx, dlqURL, *projectId, dlqHold, func(letter consumer.DeadLetter) error {
+			if len(ids) > 0 && !ids[letter.MessageID] {
+				return nil
+			}
+			err := consumer.Redrive(ctx, queueURL, dlqURL, letter)
+			if err != nil {
+				return fmt.Errorf("message %s: %w", letter.MessageID, err)
+			}
+			count++
+			return nil
+		})
+		fmt.Printf("Redrove %d message(s)\n", count)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Failed to redrive dead letters:", err)
+			return 1
+		}
+
+	default:
+		fmt.Fprintln(os.Stderr, "usage: dlq list|inspect|redrive [flags] [message-id ...]")
+		return 2
+	}
+	return 0
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"fold/internal/events"
+	"os"
+)
+
+// runEvents implements the events subcommand:
+//
+//	fold events [-check] [-write dir]
+//
+// It prints the JSON Schema of sync events. With -write it regenerates the published schema and
+// golden events in dir, and with -check it returns a non-zero exit code when the published files
+// no longer match the models. Run the check in CI to protect consumers of the queue.
+func runEvents(args []string) int {
+	flags := flag.NewFlagSet("events", flag.ContinueOnError)
+	check := flags.Bool("check", false, "fail when the published schema or golden events differ from the models")
+	write := flags.String("write", "", "regenerate the published schema and golden events in this directory")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	switch {
+	case *check:
+		if err := events.Check(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		fmt.Println("Published sync event schema matches the models")
+	case *write != "":
+		if err := events.Write(*write); err != nil {
+			fmt.Fprintln(os.Stderr, "Failed to write sync event schema:", err)
+			return 1
+		}
+	default:
+		schema, err := events.Schema()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Failed to build sync event schema:", err)
+			return 1
+		}
+		fmt.Print(string(schema))
+	}
+	return 0
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"fold/internal/database"
+	"fold/internal/importer"
+	"fold/internal/models"
+	"fold/internal/services"
+	"io"
+	"os"
+)
+
+// runImport implements the import subcommand:
+//
+//	fold import -entity projects [-format csv] [-dry-run] [-actor name] <file|->
+//
+// It prints the import report as JSON and returns a non-zero exit code when any line failed.
+func runImport(args []string) int {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	entity := flags.String("entity", "", "entity to import: users, hashtags or projects")
+	format := flags.String("format", "", "file format: ndjson or csv (default: from the file extension)")
+	dryRun := flags.Bool("dry-run", false, "validate and report without saving anything")
+	actor := flags.String("actor", "import", "actor recorded in the audit log")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: import -entity <users|hashtags|projects> [-format ndjson|csv] [-dry-run] <file|->")
+		return 2
+	}
+
+	// Open the import file, reading standard input for -
+	name := flags.Arg(0)
+	var file io.Reader = os.Stdin
+	if name != "-" {
+		f, err := os.Open(name)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Failed to open import file:", err)
+			return 1
+		}
+		defer f.Close()
+		file = f
+
+		if *format == "" {
+			*format = importer.FormatFromName(name)
+		}
+	}
+
+	records, err := importer.Parse(file, *entity, *format)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid import file:", err)
+		return 1
+	}
+
+	database.MakeDatabaseConnection()
+	info := models.AuditInfo{Actor: *actor, RequestID: services.GenerateUniqueID()}
+	report, err := importer.Import(info, *entity, records, *dryRun)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Import failed:", err)
+		return 1
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(report)
+
+	if report.Failed > 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"fold/internal/consumer"
+	"fold/internal/database"
+	"fold/internal/handlers"
+	"fold/internal/models"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// Local queues of the sync events and their dead letters.
+const (
+	localQueueURL = "local://sync-events"
+	localDLQURL   = "local://sync-events-dlq"
+)
+
+// startLocal runs the sync pipeline inside the process: events go to an in-process queue, a
+// consumer applies them to an in-memory index, and a search server answers the search
+// service's routes from it on SEARCH_PORT (default 8081). Nothing leaves the machine, but the
+// data is still stored in the Postgres database in POSTGRES_URL, and it exits without one.
+func startLocal() {
+	if database.DB == nil || database.DB.Ping() != nil {
+		fmt.Println("LOCAL_MODE needs a reachable Postgres database in POSTGRES_URL")
+		os.Exit(1)
+	}
+
+	os.Setenv("SQS_QUEUE_URL", localQueueURL)
+	os.Setenv("SQS_DLQ_URL", localDLQURL)
+	if os.Getenv("SYNC_BLOB_BUCKET") == "" && os.Getenv("SYNC_BLOB_DIR") == "" {
+		// Events too large for a message are offloaded to a directory instead of S3
+		os.Setenv("SYNC_BLOB_DIR", filepath.Join(os.TempDir(), "fold-sync-events"))
+	}
+
+	index := consumer.NewMemory()
+	go func() {
+		err := consumer.Run(context.Background(), index)
+		if err != nil {
+			fmt.Println("Local consumer stopped:", err)
+		}
+	}()
+
+	port := os.Getenv("SEARCH_PORT")
+	if port == "" {
+		port = "8081"
+	}
+	go func() {
+		fmt.Printf("Local search started on port %s\n", port)
+		err := http.ListenAndServe(":"+port, localSearchHandler(index))
+		if err != nil {
+			fmt.Printf("Error starting local search: %v\n", err)
+		}
+	}()
+}
+
+// localSearchHandler serves the search service's routes from the in-memory index.
+func localSearchHandler(index *consumer.Memory) http.Handler {
+	search := func(find func(query string) []models.DenormalizedProject) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodGet {
+				handlers.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed", nil)
+				return
+			}
+			query := r.URL.Query().Get("query")
+			if query == "" {
+				handlers.RespondWithError(w, http.StatusBadRequest, "Missing query parameter", nil)
+				return
+			}
+			handlers.RespondWithJSON(w, http.StatusOK, find(query))
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/users", search(index.SearchUsers))
+	mux.Handle("/hashtags", search(index.SearchHashtags))
+	mux.Handle("/fuzzy", search(index.SearchFuzzy))
+	return mux
+}
@@ -2,13 +2,36 @@ package main
 
 import (
 	"fmt"
+	"fold/internal/config"
 	"fold/internal/database"
+	"fold/internal/jobs"
 	"fold/internal/routes"
 	"net/http"
+	"os"
 )
 
 func main() {
+	// Run a subcommand instead of the server when one is given
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "import":
+			os.Exit(runImport(os.Args[2:]))
+		case "openapi":
+			os.Exit(runOpenAPI(os.Args[2:]))
+		case "events":
+			os.Exit(runEvents(os.Args[2:]))
+		case "consume":
+			os.Exit(runConsume(os.Args[2:]))
+		case "dlq":
+			os.Exit(runDLQ(os.Args[2:]))
+		}
+	}
+
 	database.MakeDatabaseConnection()
+	if config.Bool("LOCAL_MODE", false) {
+		startLocal()
+	}
+	jobs.StartPurgeJob()
 	routes.SetRouter()
 
 	// Start the server at port 8080
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"fold/internal/openapi"
+	"fold/internal/routes"
+	"os"
+)
+
+// runOpenAPI implements the openapi subcommand:
+//
+//	fold openapi [-check]
+//
+// It prints the OpenAPI specification, or with -check compares it with the registered routes
+// and returns a non-zero exit code when they diverge. Run it in CI as the contract check.
+func runOpenAPI(args []string) int {
+	flags := flag.NewFlagSet("openapi", flag.ContinueOnError)
+	check := flags.Bool("check", false, "fail when the routes and the specification diverge instead of printing it")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	if *check {
+		if err := openapi.Verify(routes.NewRouter()); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		fmt.Println("OpenAPI specification matches the routes")
+		return 0
+	}
+
+	spec, err := openapi.JSON()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to build OpenAPI specification:", err)
+		return 1
+	}
+	fmt.Println(string(spec))
+	return 0
+}
@@ -0,0 +1,56 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Duration reads a Go duration such as 720h from the environment variable name, falling back
+// when it is unset or invalid.
+func Duration(name string, fallback time.Duration) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration <= 0 {
+		fmt.Printf("Invalid %s %q, using %s\n", name, value, fallback)
+		return fallback
+	}
+	return duration
+}
+
+// Bool reads a boolean such as true or 1 from the environment variable name, falling back when
+// it is unset or invalid.
+func Bool(name string, fallback bool) bool {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+
+	enabled, err := strconv.ParseBool(value)
+	if err != nil {
+		fmt.Printf("Invalid %s %q, using %t\n", name, value, fallback)
+		return fallback
+	}
+	return enabled
+}
+
+// Int reads a positive integer from the environment variable name, falling back when it is
+// unset or invalid.
+func Int(name string, fallback int) int {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+
+	number, err := strconv.Atoi(value)
+	if err != nil || number <= 0 {
+		fmt.Printf("Invalid %s %q, using %d\n", name, value, fallback)
+		return fallback
+	}
+	return number
+}
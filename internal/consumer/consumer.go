@@ -0,0 +1,74 @@
+package consumer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"fold/internal/models"
+)
+
+// ErrInvalidEvent is returned for messages that can never be applied, however often they are retried.
+var ErrInvalidEvent = errors.New("invalid sync event")
+
+// Index is a search index of project documents that sync events are applied to.
+type Index interface {
+	Upsert(ctx context.Context, doc models.DenormalizedProject) error
+	Delete(ctx context.Context, projectId int) error
+	// RenameUser and RenameHashtag update the user or hashtag in every document containing it.
+	RenameUser(ctx context.Context, user models.RenamedUser) error
+	RenameHashtag(ctx context.Context, hashtag models.RenamedHashtag) error
+}
+
+// message is a sync event of any schema version. Unversioned payloads only have doc and method.
+type message struct {
+	models.SyncEvent
+	Method string `json:"method"`
+}
+
+// Apply decodes a message body and applies its event to the index.
+func Apply(ctx context.Context, index Index, body []byte) error {
+	var msg message
+	err := json.Unmarshal(body, &msg)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidEvent, err)
+	}
+	if msg.SchemaVersion > models.SyncSchemaVersion {
+		return fmt.Errorf("%w: unsupported schema version %d", ErrInvalidEvent, msg.SchemaVersion)
+	}
+
+	eventType := msg.EventType
+	if msg.SchemaVersion == 0 {
+		switch msg.Method {
+		case "POST":
+			eventType = models.EventProjectUpserted
+		case "DELETE":
+			eventType = models.EventProjectDeleted
+		}
+	}
+
+	switch eventType {
+	case models.EventProjectUpserted:
+		if msg.Doc == nil {
+			return fmt.Errorf("%w: %s without doc", ErrInvalidEvent, eventType)
+		}
+		return index.Upsert(ctx, *msg.Doc)
+	case models.EventProjectDeleted:
+		if msg.Doc == nil {
+			return fmt.Errorf("%w: %s without doc", ErrInvalidEvent, eventType)
+		}
+		return index.Delete(ctx, msg.Doc.ID)
+	case models.EventUserRenamed:
+		if msg.User == nil {
+			return fmt.Errorf("%w: %s without user", ErrInvalidEvent, eventType)
+		}
+		return index.RenameUser(ctx, *msg.User)
+	case models.EventHashtagRenamed:
+		if msg.Hashtag == nil {
+			return fmt.Errorf("%w: %s without hashtag", ErrInvalidEvent, eventType)
+		}
+		return index.RenameHashtag(ctx, *msg.Hashtag)
+	default:
+		return fmt.Errorf("%w: unknown event type %q", ErrInvalidEvent, eventType)
+	}
+}
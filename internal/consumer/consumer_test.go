@@ -0,0 +1,158 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"fold/internal/models"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// goldenEvent reads a published example event.
+func goldenEvent(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("..", "events", "schema", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func testDoc(id int, userName string, hashtagName string) models.DenormalizedProject {
+	return models.DenormalizedProject{
+		ID:       id,
+		Name:     "Project",
+		Slug:     "project",
+		Users:    []models.ProjectMember{{User: models.User{ID: 7, Name: userName}, Role: models.RoleOwner}},
+		Hashtags: []models.Hashtag{{ID: 3, Name: hashtagName}},
+	}
+}
+
+func TestApply(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    []byte
+		initial []models.DenormalizedProject
+		want    map[int]string
+	}{
+		{
+			name: "unversioned upsert",
+			body: []byte(`{"method": "POST", "doc": {"id": 42, "name": "Fold"}}`),
+			want: map[int]string{42: "Fold"},
+		},
+		{
+			name:    "unversioned delete",
+			body:    []byte(`{"method": "DELETE", "doc": {"id": 42, "name": "Fold"}}`),
+			initial: []models.DenormalizedProject{{ID: 42, Name: "Fold"}, {ID: 43, Name: "Other"}},
+			want:    map[int]string{43: "Other"},
+		},
+		{
+			name: "version 1 upsert",
+			body: goldenEvent(t, "project.upserted.v1.json"),
+			want: map[int]string{42: "Fold"},
+		},
+		{
+			name:    "version 1 delete",
+			body:    goldenEvent(t, "project.deleted.v1.json"),
+			initial: []models.DenormalizedProject{{ID: 42, Name: "Fold"}},
+			want:    map[int]string{},
+		},
+		{
+			name:    "version 2 upsert replaces the document",
+			body:    goldenEvent(t, "project.upserted.v2.json"),
+			initial: []models.DenormalizedProject{{ID: 42, Name: "Old"}},
+			want:    map[int]string{42: "Fold"},
+		},
+		{
+			name:    "version 2 delete of a missing document",
+			body:    goldenEvent(t, "project.deleted.v2.json"),
+			initial: []models.DenormalizedProject{{ID: 43, Name: "Other"}},
+			want:    map[int]string{43: "Other"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			index := NewMemory()
+			for _, doc := range test.initial {
+				index.Upsert(context.Background(), doc)
+			}
+
+			err := Apply(context.Background(), index, test.body)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(index.docs) != len(test.want) {
+				t.Fatalf("index has %d documents, want %d", len(index.docs), len(test.want))
+			}
+			for id, name := range test.want {
+				if index.docs[id].Name != name {
+					t.Errorf("document %d has name %q, want %q", id, index.docs[id].Name, name)
+				}
+			}
+		})
+	}
+}
+
+func TestApplyRenames(t *testing.T) {
+	ctx := context.Background()
+	index := NewMemory()
+	index.Upsert(ctx, testDoc(1, "Grace", "gopher"))
+	index.Upsert(ctx, testDoc(2, "Grace", "gopher"))
+	other := testDoc(3, "Linus", "c")
+	other.Users[0].ID = 8
+	other.Hashtags[0].ID = 4
+	index.Upsert(ctx, other)
+
+	// The golden events rename user 7 to Ada and hashtag 3 to go with the alias golang
+	for _, name := range []string{"user.renamed.v2.json", "hashtag.renamed.v2.json"} {
+		err := Apply(ctx, index, goldenEvent(t, name))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+	}
+
+	for _, id := range []int{1, 2} {
+		doc := index.docs[id]
+		if doc.Users[0].Name != "Ada" {
+			t.Errorf("document %d has user %q, want Ada", id, doc.Users[0].Name)
+		}
+		if doc.Hashtags[0].Name != "go" || len(doc.Hashtags[0].Aliases) != 1 || doc.Hashtags[0].Aliases[0] != "golang" {
+			t.Errorf("document %d has hashtag %+v, want go with alias golang", id, doc.Hashtags[0])
+		}
+	}
+	if doc := index.docs[3]; doc.Users[0].Name != "Linus" || doc.Hashtags[0].Name != "c" {
+		t.Errorf("document of other user and hashtag changed: %+v", doc)
+	}
+}
+
+func TestApplyRejectsInvalidEvents(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"not JSON", `{`},
+		{"newer schema version", `{"schema_version": 99, "event_type": "project.upserted", "doc": {"id": 1}}`},
+		{"unknown event type", `{"schema_version": 2, "event_type": "project.archived", "doc": {"id": 1}}`},
+		{"unknown method", `{"method": "PATCH", "doc": {"id": 1}}`},
+		{"upsert without doc", `{"schema_version": 2, "event_type": "project.upserted"}`},
+		{"delete without doc", `{"schema_version": 1, "event_type": "project.deleted"}`},
+		{"user rename without user", `{"schema_version": 2, "event_type": "user.renamed"}`},
+		{"hashtag rename without hashtag", `{"schema_version": 2, "event_type": "hashtag.renamed"}`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			index := NewMemory()
+			err := Apply(context.Background(), index, []byte(test.body))
+			if !errors.Is(err, ErrInvalidEvent) {
+				t.Fatalf("got error %v, want ErrInvalidEvent", err)
+			}
+			if len(index.docs) != 0 {
+				t.Fatal("invalid event changed the index")
+			}
+		})
+	}
+}
@@ -0,0 +1,166 @@
+package consumer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fold/internal/services"
+	"strconv"
+	"time"
+)
+
+// Attributes added to messages moved to the dead-letter queue. Together with the event's own
+// attributes they stay within the ten attributes SQS allows per message.
+const (
+	FailureReasonAttribute = "failure_reason"
+	FailureKindAttribute   = "failure_kind"
+	FailedAtAttribute      = "failed_at"
+	ReceiveCountAttribute  = "receive_count"
+)
+
+// Failure kinds of dead letters.
+const (
+	// FailureInvalid marks messages that can never be applied.
+	FailureInvalid = "invalid"
+	// FailureRetriesExhausted marks messages that kept failing until the receive limit.
+	FailureRetriesExhausted = "retries_exhausted"
+)
+
+// maxFailureReasonLength keeps failure reasons short enough for a message attribute.
+const maxFailureReasonLength = 1024
+
+// failureAttributes are the attributes removed again when a dead letter is redriven.
+var failureAttributes = []string{FailureReasonAttribute, FailureKindAttribute, FailedAtAttribute, ReceiveCountAttribute}
+
+// DeadLetter is a message of the dead-letter queue. The failure fields are empty for messages
+// moved there by the queue's own redrive policy instead of by the consumer.
+type DeadLetter struct {
+	MessageID     string          `json:"message_id"`
+	EventID       string          `json:"event_id,omitempty"`
+	EventType     string          `json:"event_type,omitempty"`
+	ProjectID     int             `json:"project_id,omitempty"`
+	FailureKind   string          `json:"failure_kind,omitempty"`
+	FailureReason string          `json:"failure_reason,omitempty"`
+	FailedAt      string          `json:"failed_at,omitempty"`
+	ReceiveCount  int             `json:"receive_count,omitempty"`
+	Event         json.RawMessage `json:"event,omitempty"`
+
+	message services.Message
+}
+
+// deadLetter moves a failed message to the dead-letter queue with the reason it failed, then
+// deletes it from the main queue.
+func deadLetter(ctx context.Context, queueURL string, dlqURL string, message services.Message, kind string, failure error) error {
+	// The body is kept as is, so offloaded payloads still point at their blob
+	err := services.SendRawSQS(ctx, dlqURL, message.Body, deadLetterAttributes(message, kind, failure, time.Now()), message.ID)
+	if err != nil {
+		return err
+	}
+	return services.DeleteSQS(ctx, queueURL, message.ReceiptHandle)
+}
+
+// deadLetterAttributes returns the attributes of message with the failure added.
+func deadLetterAttributes(message services.Message, kind string, failure error, failedAt time.Time) map[string]string {
+	reason := failure.Error()
+	if len(reason) > maxFailureReasonLength {
+		reason = reason[:maxFailureReasonLength]
+	}
+
+	attributes := map[string]string{}
+	for name, value := range message.Attributes {
+		attributes[name] = value
+	}
+	attributes[FailureReasonAttribute] = reason
+	attributes[FailureKindAttribute] = kind
+	attributes[FailedAtAttribute] = failedAt.UTC().Format(time.RFC3339)
+	attributes[ReceiveCountAttribute] = strconv.Itoa(message.ReceiveCount)
+	return attributes
+}
+
+// ScanDeadLetters receives every message of the dead-letter queue and passes those of the
+// project to fn, or all of them when projectId is zero. Messages stay hidden for hold seconds,
+// so the scan ends once the queue has nothing left that it has not seen.
+func ScanDeadLetters(ctx context.Context, dlqURL string, projectId int, hold int32, fn func(DeadLetter) error) error {
+	seen := map[string]bool{}
+	for {
+		messages, err := services.ReceiveSQS(ctx, dlqURL, 1, hold)
+		if err != nil {
+			return err
+		}
+
+		fresh := 0
+		for _, received := range messages {
+			if seen[received.ID] {
+				continue
+			}
+			seen[received.ID] = true
+			fresh++
+
+			letter := newDeadLetter(ctx, received)
+			if projectId != 0 && letter.ProjectID != projectId {
+				continue
+			}
+			err = fn(letter)
+			if err != nil {
+				return err
+			}
+		}
+		if fresh == 0 {
+			return nil
+		}
+	}
+}
+
+// newDeadLetter describes a dead-letter message, decoding as much of its event as it can.
+func newDeadLetter(ctx context.Context, received services.Message) DeadLetter {
+	letter := DeadLetter{
+		MessageID:     received.ID,
+		FailureKind:   received.Attributes[FailureKindAttribute],
+		FailureReason: received.Attributes[FailureReasonAttribute],
+		FailedAt:      received.Attributes[FailedAtAttribute],
+		message:       received,
+	}
+	letter.ReceiveCount, _ = strconv.Atoi(received.Attributes[ReceiveCountAttribute])
+
+	body, err := services.DecodeMessage(ctx, received)
+	if err != nil {
+		return letter
+	}
+	var msg message
+	if json.Unmarshal(body, &msg) != nil {
+		return letter
+	}
+	letter.Event = body
+	letter.EventID = msg.EventID
+	letter.EventType = msg.EventType
+	if msg.Doc != nil {
+		letter.ProjectID = msg.Doc.ID
+	}
+	return letter
+}
+
+// Redrive sends a dead letter back to the main queue without its failure attributes and
+// deletes it from the dead-letter queue.
+func Redrive(ctx context.Context, queueURL string, dlqURL string, letter DeadLetter) error {
+	if letter.message.ReceiptHandle == "" {
+		return errors.New("dead letter was not received from the queue")
+	}
+
+	err := services.SendRawSQS(ctx, queueURL, letter.message.Body, redriveAttributes(letter.message.Attributes), services.SyncMessageGroup)
+	if err != nil {
+		return err
+	}
+	return services.DeleteSQS(ctx, dlqURL, letter.message.ReceiptHandle)
+}
+
+// redriveAttributes returns the attributes of a dead letter without its failure attributes.
+func redriveAttributes(letterAttributes map[string]string) map[string]string {
+	attributes := map[string]string{}
+	for name, value := range letterAttributes {
+		attributes[name] = value
+	}
+	for _, name := range failureAttributes {
+		delete(attributes, name)
+	}
+	return attributes
+}
@@ -0,0 +1,261 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"fold/internal/services"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDeadLetterAttributes(t *testing.T) {
+	failedAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.FixedZone("CET", 3600))
+	eventAttributes := map[string]string{"schema_version": "2", "event_type": "project.upserted"}
+
+	tests := []struct {
+		name       string
+		kind       string
+		failure    error
+		receives   int
+		wantReason string
+	}{
+		{
+			name:       "invalid event",
+			kind:       FailureInvalid,
+			failure:    ErrInvalidEvent,
+			receives:   1,
+			wantReason: ErrInvalidEvent.Error(),
+		},
+		{
+			name:       "retries exhausted",
+			kind:       FailureRetriesExhausted,
+			failure:    errors.New("index unavailable"),
+			receives:   3,
+			wantReason: "index unavailable",
+		},
+		{
+			name:       "long reason is truncated",
+			kind:       FailureRetriesExhausted,
+			failure:    errors.New(strings.Repeat("x", 2*maxFailureReasonLength)),
+			receives:   1,
+			wantReason: strings.Repeat("x", maxFailureReasonLength),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			message := services.Message{ID: "m-1", Body: "{}", Attributes: eventAttributes, ReceiveCount: test.receives}
+			attributes := deadLetterAttributes(message, test.kind, test.failure, failedAt)
+
+			// The dead letter keeps the event attributes and adds the failure
+			want := map[string]string{
+				"schema_version":       "2",
+				"event_type":           "project.upserted",
+				FailureKindAttribute:   test.kind,
+				FailureReasonAttribute: test.wantReason,
+				FailedAtAttribute:      "2024-01-02T02:04:05Z",
+				ReceiveCountAttribute:  strconv.Itoa(test.receives),
+			}
+			if !reflect.DeepEqual(attributes, want) {
+				t.Fatalf("attributes %v, want %v", attributes, want)
+			}
+			if len(message.Attributes) != 2 {
+				t.Fatalf("received message attributes were changed: %v", message.Attributes)
+			}
+
+			// Redrive strips the failure attributes again
+			if redriven := redriveAttributes(attributes); !reflect.DeepEqual(redriven, eventAttributes) {
+				t.Fatalf("redriven attributes %v, want %v", redriven, eventAttributes)
+			}
+		})
+	}
+}
+
+func TestNewDeadLetter(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want DeadLetter
+	}{
+		{
+			name: "project event",
+			body: `{"schema_version": 2, "event_id": "e-1", "event_type": "project.upserted", "doc": {"id": 42}}`,
+			want: DeadLetter{EventID: "e-1", EventType: "project.upserted", ProjectID: 42},
+		},
+		{
+			name: "rename event",
+			body: `{"schema_version": 2, "event_id": "e-2", "event_type": "user.renamed", "user": {"id": 7}}`,
+			want: DeadLetter{EventID: "e-2", EventType: "user.renamed"},
+		},
+		{
+			name: "undecodable body",
+			body: `not json`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			received := services.Message{ID: "m-1", Body: test.body, ReceiptHandle: "r-1", Attributes: map[string]string{
+				FailureKindAttribute:   FailureInvalid,
+				FailureReasonAttribute: "invalid sync event",
+				FailedAtAttribute:      "2024-01-02T03:04:05Z",
+				ReceiveCountAttribute:  "3",
+			}}
+			letter := newDeadLetter(context.Background(), received)
+
+			if letter.MessageID != "m-1" || letter.FailureKind != FailureInvalid || letter.FailureReason != "invalid sync event" ||
+				letter.FailedAt != "2024-01-02T03:04:05Z" || letter.ReceiveCount != 3 {
+				t.Fatalf("dead letter failure %+v", letter)
+			}
+			if letter.EventID != test.want.EventID || letter.EventType != test.want.EventType || letter.ProjectID != test.want.ProjectID {
+				t.Fatalf("dead letter describes event %q of type %q for project %d, want %+v", letter.EventID, letter.EventType, letter.ProjectID, test.want)
+			}
+			if test.want.EventID != "" && string(letter.Event) != test.body {
+				t.Fatalf("dead letter event %s, want the body", letter.Event)
+			}
+		})
+	}
+}
+
+func TestRedriveRequiresReceivedLetter(t *testing.T) {
+	err := Redrive(context.Background(), "queue", "dlq", DeadLetter{MessageID: "m-1"})
+	if err == nil {
+		t.Fatal("redrive of a dead letter that was not received succeeded")
+	}
+}
+
+// testQueues returns in-process queue URLs used by a single test.
+func testQueues(t *testing.T) (string, string) {
+	base := services.LocalQueuePrefix + strings.ReplaceAll(t.Name(), "/", "-")
+	return base, base + "-dlq"
+}
+
+// receiveOne receives the only visible message of a queue.
+func receiveOne(t *testing.T, queueURL string) services.Message {
+	t.Helper()
+	messages, err := services.ReceiveSQS(context.Background(), queueURL, 0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 1 {
+		t.Fatalf("%s has %d visible messages, want 1", queueURL, len(messages))
+	}
+	return messages[0]
+}
+
+func expectEmpty(t *testing.T, queueURL string) {
+	t.Helper()
+	messages, err := services.ReceiveSQS(context.Background(), queueURL, 0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 0 {
+		t.Fatalf("%s has %d messages, want none", queueURL, len(messages))
+	}
+}
+
+func TestDeadLetterAndRedrive(t *testing.T) {
+	ctx := context.Background()
+	queueURL, dlqURL := testQueues(t)
+	body := `{"schema_version": 2, "event_id": "e-1", "event_type": "project.upserted", "doc": {"id": 42}}`
+	attributes := map[string]string{"schema_version": "2", "event_type": "project.upserted"}
+	err := services.SendRawSQS(ctx, queueURL, body, attributes, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The message moves to the dead-letter queue with its body and the failure
+	err = deadLetter(ctx, queueURL, dlqURL, receiveOne(t, queueURL), FailureInvalid, ErrInvalidEvent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectEmpty(t, queueURL)
+	letter := newDeadLetter(ctx, receiveOne(t, dlqURL))
+	if letter.message.Body != body || letter.FailureKind != FailureInvalid || letter.ProjectID != 42 {
+		t.Fatalf("unexpected dead letter %+v", letter)
+	}
+
+	// Redrive returns it to the main queue without the failure attributes
+	err = Redrive(ctx, queueURL, dlqURL, letter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectEmpty(t, dlqURL)
+	redriven := receiveOne(t, queueURL)
+	if redriven.Body != body || !reflect.DeepEqual(redriven.Attributes, attributes) {
+		t.Fatalf("redriven message %q with attributes %v", redriven.Body, redriven.Attributes)
+	}
+}
+
+func TestDeadLetterReceiveCount(t *testing.T) {
+	ctx := context.Background()
+	queueURL, dlqURL := testQueues(t)
+	err := services.SendRawSQS(ctx, queueURL, `{}`, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Let the message become visible again twice, as it does after failed attempts
+	var received services.Message
+	for i := 0; i < 3; i++ {
+		messages, err := services.ReceiveSQS(ctx, queueURL, 2, 1)
+		if err != nil || len(messages) != 1 {
+			t.Fatalf("receive %d: %d messages, error %v", i+1, len(messages), err)
+		}
+		received = messages[0]
+	}
+	if received.ReceiveCount != 3 {
+		t.Fatalf("receive count %d, want 3", received.ReceiveCount)
+	}
+
+	err = deadLetter(ctx, queueURL, dlqURL, received, FailureRetriesExhausted, errors.New("index unavailable"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if letter := newDeadLetter(ctx, receiveOne(t, dlqURL)); letter.ReceiveCount != 3 {
+		t.Fatalf("dead letter receive count %d, want 3", letter.ReceiveCount)
+	}
+}
+
+func TestScanDeadLetters(t *testing.T) {
+	tests := []struct {
+		name      string
+		projectId int
+		want      []int
+	}{
+		{name: "project with two dead letters", projectId: 1, want: []int{1, 1}},
+		{name: "project with one dead letter", projectId: 2, want: []int{2}},
+		{name: "all dead letters", projectId: 0, want: []int{1, 2, 1, 0}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// Scanning hides the dead letters, so every scan gets its own queue
+			ctx := context.Background()
+			_, dlqURL := testQueues(t)
+			for _, body := range []string{
+				`{"schema_version": 2, "event_type": "project.upserted", "doc": {"id": 1}}`,
+				`{"schema_version": 2, "event_type": "project.deleted", "doc": {"id": 2}}`,
+				`{"schema_version": 2, "event_type": "project.upserted", "doc": {"id": 1}}`,
+				`not json`,
+			} {
+				services.SendRawSQS(ctx, dlqURL, body, map[string]string{FailureKindAttribute: FailureInvalid}, "")
+			}
+
+			var got []int
+			err := ScanDeadLetters(ctx, dlqURL, test.projectId, 60, func(letter DeadLetter) error {
+				got = append(got, letter.ProjectID)
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Fatalf("scan got projects %v, want %v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,168 @@
+package consumer
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"fold/internal/config"
+	"fold/internal/models"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Painless scripts updating a renamed user or hashtag in place in a project document.
+const (
+	renameUserScript    = "for (def user : ctx._source.users) { if (user.id == params.id) { user.name = params.name; user.updated_at = params.updated_at; } }"
+	renameHashtagScript = "for (def hashtag : ctx._source.hashtags) { if (hashtag.id == params.id) { hashtag.name = params.name; hashtag.aliases = params.aliases; hashtag.updated_at = params.updated_at; } }"
+)
+
+// Elasticsearch is an Index stored in an Elasticsearch index of project documents.
+type Elasticsearch struct {
+	URL      string
+	Index    string
+	Username string
+	Password string
+	Client   *http.Client
+}
+
+// NewElasticsearch configures the index from ELASTICSEARCH_URL, ELASTICSEARCH_INDEX (projects by
+// default), ELASTICSEARCH_USERNAME and ELASTICSEARCH_PASSWORD. ELASTICSEARCH_INSECURE skips the
+// verification of self-signed certificates.
+func NewElasticsearch() (*Elasticsearch, error) {
+	url := strings.TrimSuffix(os.Getenv("ELASTICSEARCH_URL"), "/")
+	if url == "" {
+		return nil, fmt.Errorf("ELASTICSEARCH_URL is not set")
+	}
+	index := os.Getenv("ELASTICSEARCH_INDEX")
+	if index == "" {
+		index = "projects"
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if config.Bool("ELASTICSEARCH_INSECURE", false) {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+
+	return &Elasticsearch{
+		URL:      url,
+		Index:    index,
+		Username: os.Getenv("ELASTICSEARCH_USERNAME"),
+		Password: os.Getenv("ELASTICSEARCH_PASSWORD"),
+		Client:   &http.Client{Transport: transport, Timeout: time.Minute},
+	}, nil
+}
+
+func (es *Elasticsearch) Upsert(ctx context.Context, doc models.DenormalizedProject) error {
+	_, err := es.do(ctx, http.MethodPut, "/_doc/"+strconv.Itoa(doc.ID), doc, nil)
+	return err
+}
+
+func (es *Elasticsearch) Delete(ctx context.Context, projectId int) error {
+	status, err := es.do(ctx, http.MethodDelete, "/_doc/"+strconv.Itoa(projectId), nil, nil)
+	if status == http.StatusNotFound {
+		// Already gone, nothing to delete
+		return nil
+	}
+	return err
+}
+
+func (es *Elasticsearch) RenameUser(ctx context.Context, user models.RenamedUser) error {
+	return es.updateByQuery(ctx, "users", renameUserScript, map[string]interface{}{
+		"id":         user.ID,
+		"name":       user.Name,
+		"updated_at": user.UpdatedAt,
+	})
+}
+
+func (es *Elasticsearch) RenameHashtag(ctx context.Context, hashtag models.RenamedHashtag) error {
+	return es.updateByQuery(ctx, "hashtags", renameHashtagScript, map[string]interface{}{
+		"id":         hashtag.ID,
+		"name":       hashtag.Name,
+		"aliases":    hashtag.Aliases,
+		"updated_at": hashtag.UpdatedAt,
+	})
+}
+
+// updateByQuery runs the script on every document with params.id in path.id.
+func (es *Elasticsearch) updateByQuery(ctx context.Context, path string, script string, params map[string]interface{}) error {
+	query := map[string]interface{}{
+		"query":  elementQuery(path, params["id"]),
+		"script": map[string]interface{}{"source": script, "lang": "painless", "params": params},
+	}
+
+	var result struct {
+		Updated  int               `json:"updated"`
+		Failures []json.RawMessage `json:"failures"`
+	}
+	_, err := es.do(ctx, http.MethodPost, "/_update_by_query", query, &result)
+	if err != nil {
+		return err
+	}
+	if len(result.Failures) > 0 {
+		return fmt.Errorf("elasticsearch: update by query failed for %d documents, first: %s", len(result.Failures), result.Failures[0])
+	}
+	return nil
+}
+
+// elementQuery matches documents with an element of the path array whose id is id. With the
+// dynamic mapping the array holds plain objects and the term query matches; with a nested
+// mapping only the nested query does. ignore_unmapped keeps the nested query from failing on
+// an index without the nested mapping, so the query works with either.
+func elementQuery(path string, id interface{}) map[string]interface{} {
+	term := map[string]interface{}{"term": map[string]interface{}{path + ".id": id}}
+	return map[string]interface{}{
+		"bool": map[string]interface{}{
+			"should": []interface{}{
+				term,
+				map[string]interface{}{"nested": map[string]interface{}{"path": path, "query": term, "ignore_unmapped": true}},
+			},
+			"minimum_should_match": 1,
+		},
+	}
+}
+
+// do sends a request to the index and decodes the response into out unless it is nil. It returns
+// the status code together with an error for any status other than 2xx.
+func (es *Elasticsearch) do(ctx context.Context, method string, path string, body interface{}, out interface{}) (int, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return 0, err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, es.URL+"/"+es.Index+path, reader)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if es.Username != "" {
+		req.SetBasicAuth(es.Username, es.Password)
+	}
+
+	resp, err := es.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return resp.StatusCode, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("elasticsearch: %s %s: %s: %s", method, path, resp.Status, data)
+	}
+	if out != nil {
+		err = json.Unmarshal(data, out)
+	}
+	return resp.StatusCode, err
+}
@@ -0,0 +1,51 @@
+package consumer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"fold/internal/models"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestRenameMatchesPlainAndNestedElements(t *testing.T) {
+	var requests []map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/projects/_update_by_query" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		var body map[string]interface{}
+		err := json.NewDecoder(r.Body).Decode(&body)
+		if err != nil {
+			t.Error(err)
+		}
+		requests = append(requests, body)
+		w.Write([]byte(`{"updated": 2, "failures": []}`))
+	}))
+	defer server.Close()
+
+	es := &Elasticsearch{URL: server.URL, Index: "projects", Client: server.Client()}
+	err := es.RenameUser(context.Background(), models.RenamedUser{ID: 7, Name: "Ada"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = es.RenameHashtag(context.Background(), models.RenamedHashtag{ID: 3, Name: "go", Aliases: []string{"golang"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The query matches the id as a plain term for the dynamic mapping and as a nested term otherwise
+	for i, path := range []string{"users", "hashtags"} {
+		var want map[string]interface{}
+		json.Unmarshal([]byte(fmt.Sprintf(`{"bool": {"should": [
+			{"term": {"%[1]s.id": %[2]d}},
+			{"nested": {"path": "%[1]s", "query": {"term": {"%[1]s.id": %[2]d}}, "ignore_unmapped": true}}
+		], "minimum_should_match": 1}}`, path, []int{7, 3}[i])), &want)
+		if !reflect.DeepEqual(requests[i]["query"], want) {
+			t.Errorf("%s query = %v, want %v", path, requests[i]["query"], want)
+		}
+	}
+}
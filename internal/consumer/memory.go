@@ -0,0 +1,202 @@
+package consumer
+
+import (
+	"context"
+	"fold/internal/models"
+	"fold/internal/normalize"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Memory is an in-memory index of project documents for running without Elasticsearch. It
+// applies events the way the Elasticsearch index does and answers the search service's queries.
+type Memory struct {
+	mu   sync.RWMutex
+	docs map[int]models.DenormalizedProject
+}
+
+func NewMemory() *Memory {
+	return &Memory{docs: map[int]models.DenormalizedProject{}}
+}
+
+// Upsert replaces the document of the project.
+func (m *Memory) Upsert(ctx context.Context, doc models.DenormalizedProject) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.docs[doc.ID] = doc
+	return nil
+}
+
+// Delete removes the document of the project, if there is one.
+func (m *Memory) Delete(ctx context.Context, projectId int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.docs, projectId)
+	return nil
+}
+
+// RenameUser updates the user in every document containing it.
+func (m *Memory) RenameUser(ctx context.Context, user models.RenamedUser) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, doc := range m.docs {
+		// Documents are replaced rather than changed in place, so returned search results stay as they were
+		users := append([]models.ProjectMember(nil), doc.Users...)
+		changed := false
+		for i := range users {
+			if users[i].ID == user.ID {
+				users[i].Name = user.Name
+				users[i].UpdatedAt = user.UpdatedAt
+				changed = true
+			}
+		}
+		if changed {
+			doc.Users = users
+			m.docs[id] = doc
+		}
+	}
+	return nil
+}
+
+// RenameHashtag updates the hashtag in every document containing it.
+func (m *Memory) RenameHashtag(ctx context.Context, hashtag models.RenamedHashtag) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, doc := range m.docs {
+		hashtags := append([]models.Hashtag(nil), doc.Hashtags...)
+		changed := false
+		for i := range hashtags {
+			if hashtags[i].ID == hashtag.ID {
+				hashtags[i].Name = hashtag.Name
+				hashtags[i].Aliases = hashtag.Aliases
+				hashtags[i].UpdatedAt = hashtag.UpdatedAt
+				changed = true
+			}
+		}
+		if changed {
+			doc.Hashtags = hashtags
+			m.docs[id] = doc
+		}
+	}
+	return nil
+}
+
+// SearchUsers returns the projects with a user of that name, ignoring case.
+func (m *Memory) SearchUsers(name string) []models.DenormalizedProject {
+	return m.search(func(doc models.DenormalizedProject) bool {
+		for _, user := range doc.Users {
+			if strings.EqualFold(user.Name, strings.TrimSpace(name)) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// SearchHashtags returns the projects with every one of the space or comma separated hashtags,
+// matched by name or alias after normalization.
+func (m *Memory) SearchHashtags(query string) []models.DenormalizedProject {
+	var wanted []string
+	for _, tag := range strings.FieldsFunc(query, func(r rune) bool { return r == ',' || r == ' ' }) {
+		wanted = append(wanted, normalize.Hashtag(tag))
+	}
+	if len(wanted) == 0 {
+		return []models.DenormalizedProject{}
+	}
+
+	return m.search(func(doc models.DenormalizedProject) bool {
+		tags := map[string]bool{}
+		for _, hashtag := range doc.Hashtags {
+			tags[hashtag.Name] = true
+			for _, alias := range hashtag.Aliases {
+				tags[alias] = true
+			}
+		}
+		for _, tag := range wanted {
+			if !tags[tag] {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// SearchFuzzy returns the projects whose slug or description has a word close to every query
+// word, allowing as many edits as a fuzzy match query with AUTO fuzziness does.
+func (m *Memory) SearchFuzzy(query string) []models.DenormalizedProject {
+	terms := strings.Fields(strings.ToLower(query))
+	if len(terms) == 0 {
+		return []models.DenormalizedProject{}
+	}
+
+	return m.search(func(doc models.DenormalizedProject) bool {
+		words := strings.FieldsFunc(strings.ToLower(doc.Slug+" "+doc.Description), func(r rune) bool {
+			return r == '-' || r == ' ' || strings.ContainsRune(".,;:!?()\"'\n\t", r)
+		})
+		for _, term := range terms {
+			found := false
+			for _, word := range words {
+				if editDistance(term, word) <= fuzziness(term) {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// search returns the matching documents ordered by project ID.
+func (m *Memory) search(match func(models.DenormalizedProject) bool) []models.DenormalizedProject {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	results := []models.DenormalizedProject{}
+	for _, doc := range m.docs {
+		if match(doc) {
+			results = append(results, doc)
+		}
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].ID < results[j].ID })
+	return results
+}
+
+// fuzziness is the number of edits allowed for a term, following Elasticsearch's AUTO setting.
+func fuzziness(term string) int {
+	switch length := len([]rune(term)); {
+	case length <= 2:
+		return 0
+	case length <= 5:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// editDistance is the Levenshtein distance between two strings.
+func editDistance(a string, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	previous := make([]int, len(rb)+1)
+	current := make([]int, len(rb)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		current[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(rb)]
+}
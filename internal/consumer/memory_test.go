@@ -0,0 +1,116 @@
+package consumer
+
+import (
+	"context"
+	"fold/internal/models"
+	"reflect"
+	"testing"
+)
+
+func searchIndex() *Memory {
+	index := NewMemory()
+	for _, doc := range []models.DenormalizedProject{
+		{
+			ID: 1, Slug: "fold-search", Description: "Search for projects by user and hashtag",
+			Users:    []models.ProjectMember{{User: models.User{ID: 1, Name: "Ada Lovelace"}}},
+			Hashtags: []models.Hashtag{{ID: 1, Name: "go", Aliases: []string{"golang"}}, {ID: 2, Name: "search"}},
+		},
+		{
+			ID: 2, Slug: "compiler", Description: "A tiny compiler, written in Go",
+			Users:    []models.ProjectMember{{User: models.User{ID: 1, Name: "Ada Lovelace"}}, {User: models.User{ID: 2, Name: "Grace"}}},
+			Hashtags: []models.Hashtag{{ID: 1, Name: "go", Aliases: []string{"golang"}}},
+		},
+		{
+			ID: 3, Slug: "ml", Description: "Machine learning notebooks",
+			Users:    []models.ProjectMember{{User: models.User{ID: 2, Name: "Grace"}}},
+			Hashtags: []models.Hashtag{{ID: 3, Name: "python"}},
+		},
+	} {
+		index.Upsert(context.Background(), doc)
+	}
+	return index
+}
+
+func projectIds(docs []models.DenormalizedProject) []int {
+	ids := []int{}
+	for _, doc := range docs {
+		ids = append(ids, doc.ID)
+	}
+	return ids
+}
+
+func TestMemorySearch(t *testing.T) {
+	index := searchIndex()
+
+	tests := []struct {
+		name   string
+		search func(string) []models.DenormalizedProject
+		query  string
+		want   []int
+	}{
+		{"user by exact name", index.SearchUsers, "Grace", []int{2, 3}},
+		{"user ignoring case and spaces", index.SearchUsers, " ada lovelace ", []int{1, 2}},
+		{"user by part of the name", index.SearchUsers, "Ada", []int{}},
+		{"hashtag by name", index.SearchHashtags, "python", []int{3}},
+		{"hashtag by alias", index.SearchHashtags, "golang", []int{1, 2}},
+		{"hashtag normalized", index.SearchHashtags, "#GoLang", []int{1, 2}},
+		{"every hashtag is required", index.SearchHashtags, "go, search", []int{1}},
+		{"unknown hashtag", index.SearchHashtags, "go rust", []int{}},
+		{"empty hashtag query", index.SearchHashtags, " , ", []int{}},
+		{"fuzzy exact word", index.SearchFuzzy, "compiler", []int{2}},
+		{"fuzzy slug part", index.SearchFuzzy, "fold", []int{1}},
+		{"fuzzy one edit in a short word", index.SearchFuzzy, "serch", []int{1}},
+		{"fuzzy two edits in a long word", index.SearchFuzzy, "machne lerning", []int{3}},
+		{"fuzzy too many edits", index.SearchFuzzy, "srch", []int{}},
+		{"fuzzy short words must match exactly", index.SearchFuzzy, "gp", []int{}},
+		{"fuzzy every word is required", index.SearchFuzzy, "tiny notebooks", []int{}},
+		{"empty fuzzy query", index.SearchFuzzy, "  ", []int{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := projectIds(test.search(test.query)); !reflect.DeepEqual(got, test.want) {
+				t.Fatalf("search %q = %v, want %v", test.query, got, test.want)
+			}
+		})
+	}
+}
+
+func TestMemorySearchResultsAreSnapshots(t *testing.T) {
+	ctx := context.Background()
+	index := searchIndex()
+	before := index.SearchUsers("Grace")
+
+	index.RenameUser(ctx, models.RenamedUser{ID: 2, Name: "Grace Hopper"})
+	index.RenameHashtag(ctx, models.RenamedHashtag{ID: 3, Name: "py", Aliases: []string{"python"}})
+	index.Delete(ctx, 3)
+
+	if before[0].Users[1].Name != "Grace" || before[1].Hashtags[0].Name != "python" {
+		t.Fatal("renames changed earlier search results")
+	}
+	if got := projectIds(index.SearchUsers("Grace Hopper")); !reflect.DeepEqual(got, []int{2}) {
+		t.Fatalf("renamed user found in %v, want [2]", got)
+	}
+	if got := projectIds(index.SearchUsers("Grace")); len(got) != 0 {
+		t.Fatalf("old name still found in %v", got)
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"go", "", 2},
+		{"search", "search", 0},
+		{"serch", "search", 1},
+		{"kitten", "sitting", 3},
+		{"über", "uber", 1},
+	}
+	for _, test := range tests {
+		if got := editDistance(test.a, test.b); got != test.want {
+			t.Errorf("editDistance(%q, %q) = %d, want %d", test.a, test.b, got, test.want)
+		}
+	}
+}
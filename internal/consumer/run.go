@@ -0,0 +1,74 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"fold/internal/config"
+	"fold/internal/services"
+	"os"
+	"time"
+)
+
+const (
+	// receiveWaitSeconds is the long polling time of a receive, the longest SQS allows.
+	receiveWaitSeconds = 20
+	// receiveRetryDelay is the pause after a failed receive.
+	receiveRetryDelay = 5 * time.Second
+)
+
+// Run receives sync events from the queue in SQS_QUEUE_URL and applies them to the index until
+// ctx is done. Applied messages are deleted; failed ones become visible again after the queue's
+// visibility timeout and are retried. With SQS_DLQ_URL set, invalid messages and messages received
+// SYNC_MAX_RECEIVES times are moved to that dead-letter queue instead.
+func Run(ctx context.Context, index Index) error {
+	queueURL := os.Getenv("SQS_QUEUE_URL")
+	if queueURL == "" {
+		return fmt.Errorf("SQS_QUEUE_URL is not set")
+	}
+	dlqURL := os.Getenv("SQS_DLQ_URL")
+	maxReceives := config.Int("SYNC_MAX_RECEIVES", 5)
+
+	for ctx.Err() == nil {
+		messages, err := services.ReceiveSQS(ctx, queueURL, receiveWaitSeconds, 0)
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			fmt.Println("Error receiving messages:", err)
+			time.Sleep(receiveRetryDelay)
+			continue
+		}
+
+		for _, message := range messages {
+			body, err := services.DecodeMessage(ctx, message)
+			if err == nil {
+				err = Apply(ctx, index, body)
+			}
+			if err != nil {
+				fmt.Printf("Failed to apply message %s: %v\n", message.ID, err)
+
+				kind := ""
+				switch {
+				case errors.Is(err, ErrInvalidEvent):
+					kind = FailureInvalid
+				case message.ReceiveCount >= maxReceives:
+					kind = FailureRetriesExhausted
+				}
+				if dlqURL != "" && kind != "" {
+					err = deadLetter(ctx, queueURL, dlqURL, message, kind, err)
+					if err != nil {
+						fmt.Printf("Failed to move message %s to the dead-letter queue: %v\n", message.ID, err)
+					}
+				}
+				continue
+			}
+
+			err = services.DeleteSQS(ctx, queueURL, message.ReceiptHandle)
+			if err != nil {
+				fmt.Printf("Failed to delete message %s: %v\n", message.ID, err)
+			}
+		}
+	}
+	return nil
+}
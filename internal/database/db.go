@@ -3,6 +3,7 @@ package database
 import (
 	"database/sql"
 	"fmt"
+	"fold/internal/normalize"
 	"os"
 
 	_ "github.com/lib/pq"
@@ -19,6 +20,17 @@ func MakeDatabaseConnection() {
 
 	DB = db
 
+	err := Migrate(db)
+	if err != nil {
+		fmt.Println("Error updating table schema.", err)
+		return
+	}
+
+	fmt.Println("Connection to Database Successfull")
+}
+
+// Migrate creates the tables that do not exist yet and brings existing ones up to date.
+func Migrate(db *sql.DB) error {
 	// Create tables if not exist
 	queries := []string{
 		`CREATE TABLE IF NOT EXISTS users (
@@ -46,15 +58,209 @@ func MakeDatabaseConnection() {
 			project_id INT REFERENCES projects(id),
 			user_id INT REFERENCES users(id)
 		)`,
+		// Remove duplicate links so every link can be added and removed individually.
+		`DELETE FROM user_projects a USING user_projects b
+			WHERE a.ctid < b.ctid AND a.project_id = b.project_id AND a.user_id = b.user_id`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS user_projects_key ON user_projects (project_id, user_id)`,
+		`DELETE FROM project_hashtags a USING project_hashtags b
+			WHERE a.ctid < b.ctid AND a.project_id = b.project_id AND a.hashtag_id = b.hashtag_id`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS project_hashtags_key ON project_hashtags (project_id, hashtag_id)`,
+		`ALTER TABLE user_projects ADD COLUMN IF NOT EXISTS role VARCHAR NOT NULL DEFAULT 'contributor'`,
+		// Make the user with the lowest ID the owner of every project that has users but no owner.
+		`UPDATE user_projects up SET role = 'owner'
+			WHERE up.user_id = (SELECT min(o.user_id) FROM user_projects o WHERE o.project_id = up.project_id)
+			AND NOT EXISTS (SELECT 1 FROM user_projects o WHERE o.project_id = up.project_id AND o.role = 'owner')`,
+		`ALTER TABLE users ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP`,
+		`ALTER TABLE hashtags ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP`,
+		`ALTER TABLE projects ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP`,
+		`ALTER TABLE projects ADD COLUMN IF NOT EXISTS revision BIGINT NOT NULL DEFAULT 0`,
+		`ALTER TABLE users ADD COLUMN IF NOT EXISTS revision BIGINT NOT NULL DEFAULT 0`,
+		`ALTER TABLE hashtags ADD COLUMN IF NOT EXISTS revision BIGINT NOT NULL DEFAULT 0`,
+		// Rename duplicate slugs so the unique index below can be created on existing data.
+		`UPDATE projects p SET slug = p.slug || '-' || p.id
+			FROM projects o WHERE o.slug = p.slug AND o.id < p.id`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS projects_slug_key ON projects (slug)`,
+		`CREATE TABLE IF NOT EXISTS hashtag_aliases (
+			alias VARCHAR PRIMARY KEY,
+			hashtag_id INT REFERENCES hashtags(id),
+			created_at TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS audit_events (
+			id BIGSERIAL PRIMARY KEY,
+			entity_type VARCHAR NOT NULL,
+			entity_id INT NOT NULL,
+			action VARCHAR NOT NULL,
+			actor VARCHAR,
+			request_id VARCHAR,
+			before JSONB,
+			after JSONB,
+			created_at TIMESTAMP
+		)`,
+		`CREATE INDEX IF NOT EXISTS audit_events_entity_idx ON audit_events (entity_type, entity_id, id)`,
+		`CREATE TABLE IF NOT EXISTS project_slugs (
+			slug VARCHAR PRIMARY KEY,
+			project_id INT REFERENCES projects(id),
+			created_at TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS idempotency_keys (
+			key VARCHAR PRIMARY KEY,
+			request_hash VARCHAR NOT NULL,
+			status_code INT,
+			response_headers JSONB,
+			response_body BYTEA,
+			created_at TIMESTAMPTZ DEFAULT now(),
+			expires_at TIMESTAMPTZ NOT NULL
+		)`,
+		`ALTER TABLE idempotency_keys ADD COLUMN IF NOT EXISTS claimed_at TIMESTAMPTZ NOT NULL DEFAULT now()`,
+		// Scope idempotency keys by the actor sending them.
+		`ALTER TABLE idempotency_keys ADD COLUMN IF NOT EXISTS actor VARCHAR NOT NULL DEFAULT ''`,
+		`ALTER TABLE idempotency_keys DROP CONSTRAINT IF EXISTS idempotency_keys_pkey`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS idempotency_keys_key ON idempotency_keys (actor, key)`,
+	}
+
+	// Store timestamps with time zone and let the database fill them in.
+	for _, table := range []string{"users", "hashtags", "projects", "hashtag_aliases", "audit_events", "project_slugs"} {
+		queries = append(queries,
+			timestamptzMigration(table, "created_at"),
+			fmt.Sprintf(`ALTER TABLE %s ALTER COLUMN created_at SET DEFAULT now()`, table),
+		)
+	}
+
+	// Track the last change of every entity.
+	for _, table := range []string{"users", "hashtags", "projects"} {
+		queries = append(queries,
+			timestamptzMigration(table, "deleted_at"),
+			fmt.Sprintf(`ALTER TABLE %s ADD COLUMN IF NOT EXISTS updated_at TIMESTAMPTZ`, table),
+			fmt.Sprintf(`UPDATE %s SET updated_at = COALESCE(created_at, now()) WHERE updated_at IS NULL`, table),
+			fmt.Sprintf(`ALTER TABLE %s ALTER COLUMN updated_at SET DEFAULT now()`, table),
+			fmt.Sprintf(`ALTER TABLE %s ALTER COLUMN updated_at SET NOT NULL`, table),
+		)
 	}
 
 	for _, query := range queries {
 		_, err := db.Exec(query)
 		if err != nil {
-			fmt.Println("Error updating table schema.", err)
-			return
+			return err
 		}
 	}
 
-	fmt.Println("Connection to Database Successfull")
+	// Normalize hashtag names written before normalization and make them unique.
+	err := migrateHashtagNames(db)
+	if err != nil {
+		return err
+	}
+
+	for _, query := range hashtagNameReuse {
+		_, err = db.Exec(query)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// timestamptzMigration converts a TIMESTAMP column to TIMESTAMPTZ, reading existing values as UTC.
+// It does nothing when the column already has a time zone.
+func timestamptzMigration(table string, column string) string {
+	return fmt.Sprintf(`DO $$ BEGIN
+		IF EXISTS (SELECT 1 FROM information_schema.columns
+			WHERE table_name = '%[1]s' AND column_name = '%[2]s' AND data_type = 'timestamp without time zone') THEN
+			ALTER TABLE %[1]s ALTER COLUMN %[2]s TYPE TIMESTAMPTZ USING %[2]s AT TIME ZONE 'UTC';
+		END IF;
+	END $$`, table, column)
+}
+
+// hashtagDedupe merges hashtags whose names became equal through normalization into the one
+// to keep, a live one before a soft-deleted one and then the lowest ID, moving their project
+// links and aliases over.
+var hashtagDedupe = []string{
+	`CREATE TEMP TABLE hashtag_duplicates ON COMMIT DROP AS
+		SELECT id, keep FROM (
+			SELECT id, first_value(id) OVER (PARTITION BY name ORDER BY deleted_at IS NOT NULL, id) AS keep FROM hashtags
+		) d WHERE id <> keep`,
+	`INSERT INTO project_hashtags (project_id, hashtag_id)
+		SELECT ph.project_id, d.keep FROM project_hashtags ph JOIN hashtag_duplicates d ON d.id = ph.hashtag_id
+		ON CONFLICT DO NOTHING`,
+	`DELETE FROM project_hashtags ph USING hashtag_duplicates d WHERE ph.hashtag_id = d.id`,
+	`UPDATE hashtag_aliases a SET hashtag_id = d.keep FROM hashtag_duplicates d WHERE a.hashtag_id = d.id`,
+	`DELETE FROM hashtags h USING hashtag_duplicates d WHERE h.id = d.id`,
+	// An alias may now equal the name of its own hashtag.
+	`DELETE FROM hashtag_aliases a USING hashtags h WHERE a.hashtag_id = h.id AND a.alias = h.name`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS hashtags_name_key ON hashtags (name) WHERE deleted_at IS NULL`,
+}
+
+// hashtagNameReuse lets live hashtags take the names and aliases of soft-deleted ones. The name
+// index only covers live hashtags, and aliases are no longer unique on their own; names and
+// aliases of live hashtags are kept apart by the checks of the hashtag write transactions.
+var hashtagNameReuse = []string{
+	`DO $$ BEGIN
+		IF EXISTS (SELECT 1 FROM pg_indexes WHERE indexname = 'hashtags_name_key' AND indexdef NOT LIKE '% WHERE %') THEN
+			DROP INDEX hashtags_name_key;
+			CREATE UNIQUE INDEX hashtags_name_key ON hashtags (name) WHERE deleted_at IS NULL;
+		END IF;
+	END $$`,
+	`ALTER TABLE hashtag_aliases DROP CONSTRAINT IF EXISTS hashtag_aliases_pkey`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS hashtag_aliases_key ON hashtag_aliases (alias, hashtag_id)`,
+}
+
+// migrateHashtagNames normalizes the stored hashtag names, merges the duplicates this creates
+// and adds the unique index on names. It does nothing once the index exists. Search documents
+// of affected projects pick up the merged hashtags on their next sync.
+func migrateHashtagNames(db *sql.DB) error {
+	var migrated bool
+	err := db.QueryRow("SELECT to_regclass('hashtags_name_key') IS NOT NULL").Scan(&migrated)
+	if err != nil || migrated {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	// Normalize the names in Go, the same way new hashtags are normalized
+	rows, err := tx.Query("SELECT id, name FROM hashtags WHERE name IS NOT NULL")
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	renamed := map[int]string{}
+	for rows.Next() {
+		var id int
+		var name string
+		err = rows.Scan(&id, &name)
+		if err != nil {
+			rows.Close()
+			tx.Rollback()
+			return err
+		}
+		if normalized := normalize.Hashtag(name); normalized != name {
+			renamed[id] = normalized
+		}
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	for id, name := range renamed {
+		_, err = tx.Exec("UPDATE hashtags SET name = $1, updated_at = now() WHERE id = $2", name, id)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	// Merge the duplicates and add the index
+	for _, query := range hashtagDedupe {
+		_, err = tx.Exec(query)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
 }
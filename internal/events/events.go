@@ -0,0 +1,45 @@
+package events
+
+import (
+	"fold/internal/models"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// New wraps the document of a project in a sync event of the current schema version.
+func New(eventType string, doc models.DenormalizedProject, revision int64, cause *models.EventCause) *models.SyncEvent {
+	event := newEvent(eventType, revision, cause)
+	event.Doc = &doc
+	return event
+}
+
+// UserRenamed describes the new name of a user once for all of its projects.
+func UserRenamed(user models.User, revision int64, cause *models.EventCause) *models.SyncEvent {
+	event := newEvent(models.EventUserRenamed, revision, cause)
+	event.User = &models.RenamedUser{ID: user.ID, Name: user.Name, UpdatedAt: user.UpdatedAt}
+	return event
+}
+
+// HashtagRenamed describes the new name and aliases of a hashtag once for all of its projects.
+func HashtagRenamed(hashtag models.Hashtag, revision int64, cause *models.EventCause) *models.SyncEvent {
+	aliases := hashtag.Aliases
+	if aliases == nil {
+		aliases = []string{}
+	}
+
+	event := newEvent(models.EventHashtagRenamed, revision, cause)
+	event.Hashtag = &models.RenamedHashtag{ID: hashtag.ID, Name: hashtag.Name, Aliases: aliases, UpdatedAt: hashtag.UpdatedAt}
+	return event
+}
+
+func newEvent(eventType string, revision int64, cause *models.EventCause) *models.SyncEvent {
+	return &models.SyncEvent{
+		SchemaVersion:  models.SyncSchemaVersion,
+		EventID:        uuid.New().String(),
+		EventType:      eventType,
+		OccurredAt:     time.Now().UTC(),
+		EntityRevision: revision,
+		Cause:          cause,
+	}
+}
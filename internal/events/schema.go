@@ -0,0 +1,218 @@
+package events
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"fold/internal/models"
+	"fold/internal/openapi"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SchemaDir is where the published schema and the golden example events live, relative to the repository root.
+const SchemaDir = "internal/events/schema"
+
+//go:embed schema/*.json
+var published embed.FS
+
+// SchemaFile is the name of the published JSON Schema of the current schema version.
+func SchemaFile() string {
+	return fmt.Sprintf("sync-event.v%d.json", models.SyncSchemaVersion)
+}
+
+// Schema generates the JSON Schema of SyncEvent.
+func Schema() ([]byte, error) {
+	id := fmt.Sprintf("urn:fold:sync-event:v%d", models.SyncSchemaVersion)
+	return marshal(openapi.JSONSchema(models.SyncEvent{}, id, "Project sync event"))
+}
+
+// examples are the golden events of the current schema version, one per event type.
+func examples() map[string]*models.SyncEvent {
+	at := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	user := models.User{ID: 7, Name: "Ada", CreatedAt: at, UpdatedAt: at}
+	hashtag := models.Hashtag{ID: 3, Name: "go", Aliases: []string{"golang"}, CreatedAt: at, UpdatedAt: at}
+	doc := models.DenormalizedProject{
+		ID:          42,
+		Name:        "Fold",
+		Slug:        "fold",
+		Description: "Search for projects",
+		CreatedAt:   at,
+		UpdatedAt:   at,
+		Users:       []models.ProjectMember{{User: user, Role: models.RoleOwner}},
+		Hashtags:    []models.Hashtag{hashtag},
+	}
+
+	upserted := New(models.EventProjectUpserted, doc, 5, &models.EventCause{EntityType: "user", EntityID: 7, Action: "update", RequestID: "req-1", CorrelationID: "00000000-0000-0000-0000-0000000000c1", FanOut: 2})
+	deleted := New(models.EventProjectDeleted, doc, 6, &models.EventCause{EntityType: "project", EntityID: 42, Action: "delete", RequestID: "req-2"})
+	userRenamed := UserRenamed(user, 3, &models.EventCause{EntityType: "user", EntityID: 7, Action: "update", RequestID: "req-3"})
+	hashtagRenamed := HashtagRenamed(hashtag, 4, &models.EventCause{EntityType: "hashtag", EntityID: 3, Action: "update", RequestID: "req-4"})
+
+	all := []*models.SyncEvent{upserted, deleted, userRenamed, hashtagRenamed}
+	examples := make(map[string]*models.SyncEvent, len(all))
+	for i, event := range all {
+		event.EventID = fmt.Sprintf("00000000-0000-0000-0000-%012d", i+1)
+		event.OccurredAt = at
+		examples[fmt.Sprintf("%s.v%d.json", event.EventType, models.SyncSchemaVersion)] = event
+	}
+	return examples
+}
+
+// Files generates the schema and the golden example events, keyed by file name.
+func Files() (map[string][]byte, error) {
+	schema, err := Schema()
+	if err != nil {
+		return nil, err
+	}
+
+	files := map[string][]byte{SchemaFile(): schema}
+	for name, event := range examples() {
+		files[name], err = marshal(event)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// Write regenerates the schema and the golden example events in dir.
+func Write(dir string) error {
+	files, err := Files()
+	if err != nil {
+		return err
+	}
+	for name, data := range files {
+		err = os.WriteFile(filepath.Join(dir, name), data, 0644)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Check compares the published schema and golden events with the ones generated from the
+// models. Changes that break consumers of the current schema version are reported as
+// incompatible; any other difference means the published files need to be regenerated.
+func Check() error {
+	files, err := Files()
+	if err != nil {
+		return err
+	}
+
+	var problems []string
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		old, err := published.ReadFile("schema/" + name)
+		if err != nil {
+			problems = append(problems, name+" is not published")
+			continue
+		}
+		if name == SchemaFile() {
+			incompatible, err := incompatibleChanges(old, files[name])
+			if err != nil {
+				return err
+			}
+			for _, change := range incompatible {
+				problems = append(problems, "incompatible change, raise models.SyncSchemaVersion: "+change)
+			}
+		}
+		if !bytes.Equal(old, files[name]) {
+			problems = append(problems, name+" differs from the models")
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("events: published schema is out of date, regenerate it with `foldbackend events -write %s`:\n  %s", SchemaDir, strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+// incompatibleChanges lists the differences between two schemas of the same version that break
+// consumers: removed or retyped properties and newly required ones.
+func incompatibleChanges(oldSchema []byte, newSchema []byte) ([]string, error) {
+	var before, after map[string]interface{}
+	if err := json.Unmarshal(oldSchema, &before); err != nil {
+		return nil, fmt.Errorf("events: published schema: %w", err)
+	}
+	if err := json.Unmarshal(newSchema, &after); err != nil {
+		return nil, err
+	}
+
+	changes := compareObjects("SyncEvent", before, after)
+	oldDefs, _ := before["$defs"].(map[string]interface{})
+	newDefs, _ := after["$defs"].(map[string]interface{})
+	for name, oldDef := range oldDefs {
+		newDef, ok := newDefs[name].(map[string]interface{})
+		if !ok {
+			changes = append(changes, "removed "+name)
+			continue
+		}
+		changes = append(changes, compareObjects(name, oldDef.(map[string]interface{}), newDef)...)
+	}
+	sort.Strings(changes)
+	return changes, nil
+}
+
+func compareObjects(name string, before map[string]interface{}, after map[string]interface{}) []string {
+	var changes []string
+	oldProperties, _ := before["properties"].(map[string]interface{})
+	newProperties, _ := after["properties"].(map[string]interface{})
+	for property, oldProperty := range oldProperties {
+		newProperty, ok := newProperties[property]
+		if !ok {
+			changes = append(changes, fmt.Sprintf("removed %s.%s", name, property))
+			continue
+		}
+		if !reflect.DeepEqual(typeOf(oldProperty), typeOf(newProperty)) {
+			changes = append(changes, fmt.Sprintf("changed type of %s.%s", name, property))
+		}
+	}
+
+	oldRequired, _ := before["required"].([]interface{})
+	newRequired, _ := after["required"].([]interface{})
+	for _, property := range newRequired {
+		if !containsValue(oldRequired, property) {
+			changes = append(changes, fmt.Sprintf("%s.%v became required", name, property))
+		}
+	}
+	return changes
+}
+
+// typeOf keeps the parts of a property schema that decide how it decodes.
+func typeOf(schema interface{}) map[string]interface{} {
+	property, _ := schema.(map[string]interface{})
+	kept := map[string]interface{}{}
+	for _, key := range []string{"type", "$ref", "anyOf", "items", "additionalProperties"} {
+		if value, ok := property[key]; ok {
+			kept[key] = value
+		}
+	}
+	return kept
+}
+
+func containsValue(values []interface{}, value interface{}) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func marshal(v interface{}) ([]byte, error) {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
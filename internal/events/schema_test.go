@@ -0,0 +1,112 @@
+package events
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestPublishedFilesMatchModels(t *testing.T) {
+	files, err := Files()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, data := range files {
+		old, err := published.ReadFile("schema/" + name)
+		if err != nil {
+			t.Errorf("%s is not published", name)
+			continue
+		}
+		if !bytes.Equal(old, data) {
+			t.Errorf("%s differs from the models, regenerate it with `foldbackend events -write %s`", name, SchemaDir)
+		}
+	}
+}
+
+func TestSchemaIsCompatibleWithPublished(t *testing.T) {
+	old, err := published.ReadFile("schema/" + SchemaFile())
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema, err := Schema()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changes, err := incompatibleChanges(old, schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) > 0 {
+		t.Fatalf("incompatible changes, raise models.SyncSchemaVersion: %v", changes)
+	}
+}
+
+func TestIncompatibleChanges(t *testing.T) {
+	published := `{
+		"properties": {"id": {"type": "integer"}, "doc": {"$ref": "#/$defs/Doc"}},
+		"required": ["id"],
+		"$defs": {"Doc": {"properties": {"name": {"type": "string", "maxLength": 200}}}}
+	}`
+
+	tests := []struct {
+		name   string
+		schema string
+		want   []string
+	}{
+		{
+			name:   "unchanged",
+			schema: published,
+		},
+		{
+			name: "added optional property and changed description",
+			schema: `{
+				"properties": {"id": {"type": "integer"}, "doc": {"$ref": "#/$defs/Doc"}, "cause": {"type": "string"}},
+				"required": ["id"],
+				"$defs": {"Doc": {"properties": {"name": {"type": "string", "description": "Name"}}}}
+			}`,
+		},
+		{
+			name: "removed, retyped and newly required properties",
+			schema: `{
+				"properties": {"id": {"type": "string"}, "cause": {"type": "string"}},
+				"required": ["id", "cause"],
+				"$defs": {}
+			}`,
+			want: []string{"SyncEvent.cause became required", "changed type of SyncEvent.id", "removed Doc", "removed SyncEvent.doc"},
+		},
+		{
+			name: "retyped nested property",
+			schema: `{
+				"properties": {"id": {"type": "integer"}, "doc": {"$ref": "#/$defs/Doc"}},
+				"required": ["id"],
+				"$defs": {"Doc": {"properties": {"name": {"type": "array", "items": {"type": "string"}}}}}
+			}`,
+			want: []string{"changed type of Doc.name"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			changes, err := incompatibleChanges([]byte(published), []byte(test.schema))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(changes) == 0 && len(test.want) == 0 {
+				return
+			}
+			if !reflect.DeepEqual(changes, test.want) {
+				t.Fatalf("changes = %q, want %q", changes, test.want)
+			}
+		})
+	}
+
+	_, err := incompatibleChanges([]byte("{"), []byte(published))
+	var syntaxErr *json.SyntaxError
+	if err == nil || !errors.As(err, &syntaxErr) {
+		t.Fatalf("got error %v for an invalid published schema", err)
+	}
+}
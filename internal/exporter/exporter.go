@@ -0,0 +1,191 @@
+package exporter
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"fold/internal/models"
+	"fold/internal/repository"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Supported export formats. Documents are the denormalized projects sent to the search index.
+const (
+	FormatNDJSON    = "ndjson"
+	FormatCSV       = "csv"
+	FormatDocuments = "documents"
+)
+
+// flushEvery is the number of records written between two flushes of the response.
+const flushEvery = 500
+
+// csvListSeparator separates the items of list columns, as in import files.
+const csvListSeparator = "|"
+
+var csvHeaders = map[string][]string{
+	repository.EntityUsers:    {"id", "name", "created_at", "updated_at", "deleted_at"},
+	repository.EntityHashtags: {"id", "name", "aliases", "created_at", "updated_at", "deleted_at"},
+	repository.EntityProjects: {"id", "name", "slug", "description", "users", "user_roles", "hashtags", "created_at", "updated_at", "deleted_at"},
+}
+
+// Check reports whether entity can be exported in format, so errors can be returned before streaming starts.
+func Check(entity string, format string) error {
+	if _, ok := csvHeaders[entity]; !ok {
+		return fmt.Errorf("unknown entity %q, expected users, hashtags or projects", entity)
+	}
+	switch format {
+	case FormatNDJSON, FormatCSV:
+		return nil
+	case FormatDocuments:
+		if entity != repository.EntityProjects {
+			return fmt.Errorf("format documents is only available for projects")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown format %q, expected ndjson, csv or documents", format)
+	}
+}
+
+// ContentType returns the Content-Type of an export in format.
+func ContentType(format string) string {
+	if format == FormatCSV {
+		return "text/csv; charset=utf-8"
+	}
+	return "application/x-ndjson"
+}
+
+// Write streams every row of entity to w in format, calling flush every flushEvery records so
+// clients receive the export while it is read. Documents never include deleted projects.
+func Write(ctx context.Context, w io.Writer, flush func(), entity string, format string, includeDeleted bool) error {
+	err := Check(entity, format)
+	if err != nil {
+		return err
+	}
+
+	// Write CSV rows with a header, everything else as one JSON object per line.
+	rw, err := newRecordWriter(w, flush, entity, format)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case format == FormatDocuments:
+		err = repository.StreamProjectDocs(ctx, func(doc *models.DenormalizedProject) error {
+			return rw.write(doc, nil)
+		})
+	case entity == repository.EntityUsers:
+		err = repository.StreamUsers(ctx, includeDeleted, func(user *models.User) error {
+			return rw.write(user, func() []string {
+				return []string{strconv.Itoa(user.ID), user.Name, formatTime(&user.CreatedAt), formatTime(&user.UpdatedAt), formatTime(user.DeletedAt)}
+			})
+		})
+	case entity == repository.EntityHashtags:
+		err = repository.StreamHashtags(ctx, includeDeleted, func(hashtag *models.Hashtag) error {
+			return rw.write(hashtag, func() []string {
+				return []string{strconv.Itoa(hashtag.ID), hashtag.Name, strings.Join(hashtag.Aliases, csvListSeparator),
+					formatTime(&hashtag.CreatedAt), formatTime(&hashtag.UpdatedAt), formatTime(hashtag.DeletedAt)}
+			})
+		})
+	case entity == repository.EntityProjects:
+		err = repository.StreamProjects(ctx, includeDeleted, func(project *models.Project) error {
+			return rw.write(project, func() []string {
+				return []string{strconv.Itoa(project.ID), project.Name, project.Slug, project.Description,
+					joinIds(project.UserIds), joinRoles(project.UserRoles), joinIds(project.HashtagIds),
+					formatTime(&project.CreatedAt), formatTime(&project.UpdatedAt), formatTime(project.DeletedAt)}
+			})
+		})
+	}
+	if err != nil {
+		return err
+	}
+
+	return rw.flush()
+}
+
+// recordWriter encodes export records as CSV rows after a header, or else as one JSON object per
+// line, and flushes them every flushEvery records.
+type recordWriter struct {
+	csv      *csv.Writer
+	json     *json.Encoder
+	flushOut func()
+	written  int
+}
+
+func newRecordWriter(w io.Writer, flush func(), entity string, format string) (*recordWriter, error) {
+	rw := &recordWriter{json: json.NewEncoder(w), flushOut: flush}
+	if format == FormatCSV {
+		rw.csv = csv.NewWriter(w)
+		err := rw.csv.Write(csvHeaders[entity])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return rw, nil
+}
+
+// write encodes record, or the CSV fields returned by fields for CSV exports.
+func (rw *recordWriter) write(record interface{}, fields func() []string) error {
+	var err error
+	if rw.csv != nil {
+		err = rw.csv.Write(fields())
+	} else {
+		err = rw.json.Encode(record)
+	}
+	if err != nil {
+		return err
+	}
+
+	rw.written++
+	if rw.written%flushEvery == 0 {
+		return rw.flush()
+	}
+	return nil
+}
+
+func (rw *recordWriter) flush() error {
+	if rw.csv != nil {
+		rw.csv.Flush()
+		if err := rw.csv.Error(); err != nil {
+			return err
+		}
+	}
+	if rw.flushOut != nil {
+		rw.flushOut()
+	}
+	return nil
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+func joinIds(ids []int) string {
+	items := make([]string, len(ids))
+	for i, id := range ids {
+		items[i] = strconv.Itoa(id)
+	}
+	return strings.Join(items, csvListSeparator)
+}
+
+// joinRoles writes roles as userId:role items ordered by user ID.
+func joinRoles(roles map[int]string) string {
+	userIds := make([]int, 0, len(roles))
+	for userId := range roles {
+		userIds = append(userIds, userId)
+	}
+	sort.Ints(userIds)
+
+	items := make([]string, len(userIds))
+	for i, userId := range userIds {
+		items[i] = strconv.Itoa(userId) + ":" + roles[userId]
+	}
+	return strings.Join(items, csvListSeparator)
+}
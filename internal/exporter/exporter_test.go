@@ -0,0 +1,120 @@
+package exporter
+
+import (
+	"bytes"
+	"fold/internal/models"
+	"fold/internal/repository"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		entity  string
+		format  string
+		wantErr bool
+	}{
+		{repository.EntityUsers, FormatNDJSON, false},
+		{repository.EntityHashtags, FormatCSV, false},
+		{repository.EntityProjects, FormatDocuments, false},
+		{repository.EntityUsers, FormatDocuments, true},
+		{repository.EntityProjects, "xml", true},
+		{repository.EntityProjects, "", true},
+		{"audit", FormatNDJSON, true},
+	}
+
+	for _, test := range tests {
+		err := Check(test.entity, test.format)
+		if (err != nil) != test.wantErr {
+			t.Errorf("Check(%q, %q) = %v, want error %t", test.entity, test.format, err, test.wantErr)
+		}
+	}
+}
+
+func TestContentType(t *testing.T) {
+	if got := ContentType(FormatCSV); got != "text/csv; charset=utf-8" {
+		t.Errorf("csv content type %q", got)
+	}
+	for _, format := range []string{FormatNDJSON, FormatDocuments} {
+		if got := ContentType(format); got != "application/x-ndjson" {
+			t.Errorf("%s content type %q", format, got)
+		}
+	}
+}
+
+func TestRecordWriterNDJSON(t *testing.T) {
+	var out bytes.Buffer
+	flushes := 0
+	rw, err := newRecordWriter(&out, func() { flushes++ }, repository.EntityUsers, FormatNDJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	created := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	for _, user := range []models.User{{ID: 1, Name: "Ada", CreatedAt: created, UpdatedAt: created}, {ID: 2, Name: "Grace", CreatedAt: created, UpdatedAt: created}} {
+		err = rw.write(user, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = rw.flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `{"id":1,"name":"Ada","created_at":"2024-01-02T03:04:05Z","updated_at":"2024-01-02T03:04:05Z"}
+{"id":2,"name":"Grace","created_at":"2024-01-02T03:04:05Z","updated_at":"2024-01-02T03:04:05Z"}
+`
+	if out.String() != want {
+		t.Fatalf("got\n%s\nwant\n%s", out.String(), want)
+	}
+	if flushes != 1 {
+		t.Fatalf("flushed %d times, want 1", flushes)
+	}
+}
+
+func TestRecordWriterCSV(t *testing.T) {
+	var out bytes.Buffer
+	rw, err := newRecordWriter(&out, nil, repository.EntityProjects, FormatCSV)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	created := time.Date(2024, 1, 2, 3, 4, 5, 0, time.FixedZone("CET", 3600))
+	err = rw.write(nil, func() []string {
+		return []string{"1", "Fold, Search", "fold-search", `Say "hi"`, joinIds([]int{1, 2}),
+			joinRoles(map[int]string{2: models.RoleViewer, 1: models.RoleOwner}), joinIds(nil),
+			formatTime(&created), formatTime(&created), formatTime(nil)}
+	})
+	if err == nil {
+		err = rw.flush()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := strings.Join(csvHeaders[repository.EntityProjects], ",") + "\n" +
+		`1,"Fold, Search",fold-search,"Say ""hi""",1|2,1:owner|2:viewer,,2024-01-02T02:04:05Z,2024-01-02T02:04:05Z,` + "\n"
+	if out.String() != want {
+		t.Fatalf("got\n%s\nwant\n%s", out.String(), want)
+	}
+}
+
+func TestRecordWriterFlushesInBatches(t *testing.T) {
+	var out bytes.Buffer
+	flushes := 0
+	rw, err := newRecordWriter(&out, func() { flushes++ }, repository.EntityUsers, FormatNDJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2*flushEvery+1; i++ {
+		err = rw.write(models.User{ID: i}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if flushes != 2 {
+		t.Fatalf("flushed %d times after %d records, want 2", flushes, 2*flushEvery+1)
+	}
+}
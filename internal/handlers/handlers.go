@@ -1,35 +1,76 @@
 package handlers
 
 import (
+	"bytes"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"fold/internal/config"
+	"fold/internal/exporter"
+	"fold/internal/importer"
+	"fold/internal/mergepatch"
 	"fold/internal/models"
+	"fold/internal/openapi"
 	"fold/internal/repository"
+	"fold/internal/validation"
+	"io"
 	"net/http"
+	"net/url"
+	"path"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/google/uuid"
 	"github.com/gorilla/mux"
 )
 
+// maxRequestBodyBytes caps the size of JSON request bodies accepted by the handlers.
+const maxRequestBodyBytes = 1 << 20
+
+// maxImportBytes caps the size of files uploaded to the import endpoint.
+const maxImportBytes = 32 << 20
+
+// Limits of the Idempotency-Key header, how long responses are replayed and how long a request
+// holds its key before a retry may take it over by default.
+const (
+	maxIdempotencyKeyLength    = 255
+	defaultIdempotencyKeyTTL   = 24 * time.Hour
+	defaultIdempotencyKeyLease = 2 * time.Minute
+)
+
+// Page sizes of the audit history endpoint.
+const (
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+)
+
 func CreateUser(w http.ResponseWriter, r *http.Request) {
-	// Parse request data
+	// Parse and validate request data
 	var newUser models.User
-	err := json.NewDecoder(r.Body).Decode(&newUser)
-	if err != nil {
-		RespondWithError(w, http.StatusBadRequest, "Invalid request payload", err)
+	if !DecodeRequest(w, r, &newUser) {
 		return
 	}
 
 	// Insert user into the database
-	err = repository.CreateUser(&newUser)
+	err := repository.CreateUserTransaction(AuditInfo(r), &newUser)
 	if err != nil {
 		RespondWithError(w, http.StatusInternalServerError, "Failed to create new user", err)
 		return
 	}
 
-	// Respond with success message
-	RespondWithJSON(w, http.StatusCreated, map[string]string{"message": "User created successfully"})
+	// Respond with the created user
+	var user models.User
+	err = repository.GetUserById(newUser.ID, &user)
+	if err != nil {
+		RespondWithError(w, http.StatusInternalServerError, "User created but failed to fetch it", err)
+		return
+	}
+	w.Header().Set("Location", ResourceURL(r, "users", user.ID))
+	RespondWithJSON(w, http.StatusCreated, user)
 }
 
 func GetUser(w http.ResponseWriter, r *http.Request) {
@@ -59,8 +100,8 @@ func GetUser(w http.ResponseWriter, r *http.Request) {
 }
 
 func GetAllUsers(w http.ResponseWriter, r *http.Request) {
-	// Query the database to retrieve all users
-	users, err := repository.GetAllUsers()
+	// Query the database to retrieve all users, optionally including deleted ones
+	users, err := repository.GetAllUsers(r.URL.Query().Get("include_deleted") == "true")
 	if err != nil {
 		RespondWithError(w, http.StatusInternalServerError, "Failed to fetch users", err)
 		return
@@ -80,24 +121,68 @@ func UpdateUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Parse request data
+	// Parse and validate request data
 	var updatedUser models.User
-	err = json.NewDecoder(r.Body).Decode(&updatedUser)
+	if !DecodeRequest(w, r, &updatedUser) {
+		return
+	}
+
+	saveUserUpdate(w, r, userID, &updatedUser)
+}
+
+func PatchUser(w http.ResponseWriter, r *http.Request) {
+	// Get user ID from URL parameters
+	vars := mux.Vars(r)
+	userIDStr := vars["id"]
+	userID, err := strconv.Atoi(userIDStr)
+	if err != nil {
+		RespondWithError(w, http.StatusBadRequest, "Invalid user ID", err)
+		return
+	}
+
+	// Load the current user to apply the patch to
+	var currentUser models.User
+	err = repository.GetUserById(userID, &currentUser)
 	if err != nil {
-		RespondWithError(w, http.StatusBadRequest, "Invalid request payload", err)
+		if err == sql.ErrNoRows {
+			RespondWithError(w, http.StatusNotFound, "User not found", err)
+		} else {
+			RespondWithError(w, http.StatusInternalServerError, "Failed to fetch user", err)
+		}
+		return
+	}
+
+	// Apply and validate the merge patch
+	var updatedUser models.User
+	if !DecodePatch(w, r, currentUser, &updatedUser) {
 		return
 	}
 
+	saveUserUpdate(w, r, userID, &updatedUser)
+}
+
+// saveUserUpdate runs the user update transaction and responds with the updated user.
+func saveUserUpdate(w http.ResponseWriter, r *http.Request, userID int, updatedUser *models.User) {
 	// Perform transaction to update user in the database
 	updatedUser.ID = userID // Set the ID for the user to be updated
-	err = repository.UpdateUserTransaction(&updatedUser)
+	err := repository.UpdateUserTransaction(AuditInfo(r), updatedUser)
 	if err != nil {
-		RespondWithError(w, http.StatusInternalServerError, "Failed to update user", err)
+		if err == sql.ErrNoRows {
+			RespondWithError(w, http.StatusNotFound, "User not found", err)
+		} else {
+			RespondWithError(w, http.StatusInternalServerError, "Failed to update user", err)
+		}
 		return
 	}
 
-	// Respond with success message
-	RespondWithJSON(w, http.StatusOK, map[string]string{"message": "User updated successfully"})
+	// Respond with the updated user
+	var user models.User
+	err = repository.GetUserById(userID, &user)
+	if err != nil {
+		RespondWithError(w, http.StatusInternalServerError, "User updated but failed to fetch it", err)
+		return
+	}
+	RespondWithJSON(w, http.StatusOK, user)
 }
 
 func DeleteUser(w http.ResponseWriter, r *http.Request) {
@@ -111,9 +196,13 @@ func DeleteUser(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Perform transaction to delete user in the database
-	err = repository.DeleteUserTransaction(userID)
+	err = repository.DeleteUserTransaction(AuditInfo(r), userID)
 	if err != nil {
-		RespondWithError(w, http.StatusInternalServerError, "Failed to delete user", err)
+		if err == sql.ErrNoRows {
+			RespondWithError(w, http.StatusNotFound, "User not found", err)
+		} else {
+			RespondWithError(w, http.StatusInternalServerError, "Failed to delete user", err)
+		}
 		return
 	}
 
@@ -121,24 +210,54 @@ func DeleteUser(w http.ResponseWriter, r *http.Request) {
 	RespondWithJSON(w, http.StatusOK, map[string]string{"message": "User deleted successfully"})
 }
 
+func RestoreUser(w http.ResponseWriter, r *http.Request) {
+	// Get user ID from URL parameters
+	vars := mux.Vars(r)
+	userIDStr := vars["id"]
+	userID, err := strconv.Atoi(userIDStr)
+	if err != nil {
+		RespondWithError(w, http.StatusBadRequest, "Invalid user ID", err)
+		return
+	}
+
+	// Perform transaction to restore user in the database
+	err = repository.RestoreUserTransaction(AuditInfo(r), userID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			RespondWithError(w, http.StatusNotFound, "Deleted user not found", err)
+		} else {
+			RespondWithError(w, http.StatusInternalServerError, "Failed to restore user", err)
+		}
+		return
+	}
+
+	// Respond with success message
+	RespondWithJSON(w, http.StatusOK, map[string]string{"message": "User restored successfully"})
+}
+
 func CreateHashtag(w http.ResponseWriter, r *http.Request) {
-	// Parse request data
+	// Parse and validate request data
 	var newHashtag models.Hashtag
-	err := json.NewDecoder(r.Body).Decode(&newHashtag)
-	if err != nil {
-		RespondWithError(w, http.StatusBadRequest, "Invalid request payload", err)
+	if !DecodeRequest(w, r, &newHashtag) {
 		return
 	}
 
 	// Insert hashtag into the database
-	err = repository.CreateHashtag(&newHashtag)
+	err := repository.CreateHashtagTransaction(AuditInfo(r), &newHashtag)
 	if err != nil {
-		RespondWithError(w, http.StatusInternalServerError, "Failed to create new hashtag", err)
+		RespondWithHashtagWriteError(w, "Failed to create new hashtag", err)
 		return
 	}
 
-	// Respond with success message
-	RespondWithJSON(w, http.StatusCreated, map[string]string{"message": "Hashtag created successfully"})
+	// Respond with the created hashtag
+	var hashtag models.Hashtag
+	err = repository.GetHashtagById(newHashtag.ID, &hashtag)
+	if err != nil {
+		RespondWithError(w, http.StatusInternalServerError, "Hashtag created but failed to fetch it", err)
+		return
+	}
+	w.Header().Set("Location", ResourceURL(r, "hashtags", hashtag.ID))
+	RespondWithJSON(w, http.StatusCreated, hashtag)
 }
 
 func GetHashtag(w http.ResponseWriter, r *http.Request) {
@@ -168,8 +287,8 @@ func GetHashtag(w http.ResponseWriter, r *http.Request) {
 }
 
 func GetAllHashtags(w http.ResponseWriter, r *http.Request) {
-	// Query the database to retrieve all hashtags
-	hashtags, err := repository.GetAllHashtags()
+	// Query the database to retrieve all hashtags, optionally including deleted ones
+	hashtags, err := repository.GetAllHashtags(r.URL.Query().Get("include_deleted") == "true")
 	if err != nil {
 		RespondWithError(w, http.StatusInternalServerError, "Failed to fetch hashtags", err)
 		return
@@ -189,24 +308,93 @@ func UpdateHashtag(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Parse request data
+	// Parse and validate request data
+	var updatedHashtag models.Hashtag
+	if !DecodeRequest(w, r, &updatedHashtag) {
+		return
+	}
+
+	saveHashtagUpdate(w, r, hashtagID, &updatedHashtag)
+}
+
+func ReplaceHashtag(w http.ResponseWriter, r *http.Request) {
+	// Get hashtag ID from URL parameters
+	vars := mux.Vars(r)
+	hashtagIDStr := vars["id"]
+	hashtagID, err := strconv.Atoi(hashtagIDStr)
+	if err != nil {
+		RespondWithError(w, http.StatusBadRequest, "Invalid hashtag ID", err)
+		return
+	}
+
+	// Parse and validate request data
 	var updatedHashtag models.Hashtag
-	err = json.NewDecoder(r.Body).Decode(&updatedHashtag)
+	if !DecodeRequest(w, r, &updatedHashtag) {
+		return
+	}
+
+	// A replacement without aliases has none, unlike the legacy update that keeps them
+	if updatedHashtag.Aliases == nil {
+		updatedHashtag.Aliases = []string{}
+	}
+
+	saveHashtagUpdate(w, r, hashtagID, &updatedHashtag)
+}
+
+func PatchHashtag(w http.ResponseWriter, r *http.Request) {
+	// Get hashtag ID from URL parameters
+	vars := mux.Vars(r)
+	hashtagIDStr := vars["id"]
+	hashtagID, err := strconv.Atoi(hashtagIDStr)
+	if err != nil {
+		RespondWithError(w, http.StatusBadRequest, "Invalid hashtag ID", err)
+		return
+	}
+
+	// Load the current hashtag to apply the patch to
+	var currentHashtag models.Hashtag
+	err = repository.GetHashtagById(hashtagID, &currentHashtag)
 	if err != nil {
-		RespondWithError(w, http.StatusBadRequest, "Invalid request payload", err)
+		if err == sql.ErrNoRows {
+			RespondWithError(w, http.StatusNotFound, "Hashtag not found", err)
+		} else {
+			RespondWithError(w, http.StatusInternalServerError, "Failed to fetch hashtag", err)
+		}
+		return
+	}
+
+	// Apply and validate the merge patch
+	var updatedHashtag models.Hashtag
+	if !DecodePatch(w, r, currentHashtag, &updatedHashtag) {
 		return
 	}
 
+	// The current aliases are always part of the document, so they are only missing when the patch removed them
+	if updatedHashtag.Aliases == nil {
+		updatedHashtag.Aliases = []string{}
+	}
+
+	saveHashtagUpdate(w, r, hashtagID, &updatedHashtag)
+}
+
+// saveHashtagUpdate runs the hashtag update transaction and responds with the updated hashtag.
+func saveHashtagUpdate(w http.ResponseWriter, r *http.Request, hashtagID int, updatedHashtag *models.Hashtag) {
 	// Perform transaction to update hashtags in the database
 	updatedHashtag.ID = hashtagID // Set the ID for the hashtag to be updated
-	err = repository.UpdateHashtagTransaction(&updatedHashtag)
+	err := repository.UpdateHashtagTransaction(AuditInfo(r), updatedHashtag)
 	if err != nil {
-		RespondWithError(w, http.StatusInternalServerError, "Failed to update hashtag", err)
+		RespondWithHashtagWriteError(w, "Failed to update hashtag", err)
 		return
 	}
 
-	// Respond with success message
-	RespondWithJSON(w, http.StatusOK, map[string]string{"message": "Hashtag updated successfully"})
+	// Respond with the updated hashtag
+	var hashtag models.Hashtag
+	err = repository.GetHashtagById(hashtagID, &hashtag)
+	if err != nil {
+		RespondWithError(w, http.StatusInternalServerError, "Hashtag updated but failed to fetch it", err)
+		return
+	}
+	RespondWithJSON(w, http.StatusOK, hashtag)
 }
 
 func DeleteHashtag(w http.ResponseWriter, r *http.Request) {
@@ -220,9 +408,13 @@ func DeleteHashtag(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Perform transaction to delete hashtag in the database
-	err = repository.DeleteHashtagTransaction(hashtagID)
+	err = repository.DeleteHashtagTransaction(AuditInfo(r), hashtagID)
 	if err != nil {
-		RespondWithError(w, http.StatusInternalServerError, "Failed to delte hashtag", err)
+		if err == sql.ErrNoRows {
+			RespondWithError(w, http.StatusNotFound, "Hashtag not found", err)
+		} else {
+			RespondWithError(w, http.StatusInternalServerError, "Failed to delete hashtag", err)
+		}
 		return
 	}
 
@@ -230,42 +422,94 @@ func DeleteHashtag(w http.ResponseWriter, r *http.Request) {
 	RespondWithJSON(w, http.StatusOK, map[string]string{"message": "Hashtag deleted successfully"})
 }
 
-func CreateProject(w http.ResponseWriter, r *http.Request) {
-	// Parse request data
-	var newProject models.Project
-	err := json.NewDecoder(r.Body).Decode(&newProject)
+func RestoreHashtag(w http.ResponseWriter, r *http.Request) {
+	// Get hashtag ID from URL parameters
+	vars := mux.Vars(r)
+	hashtagIDStr := vars["id"]
+	hashtagID, err := strconv.Atoi(hashtagIDStr)
 	if err != nil {
-		RespondWithError(w, http.StatusBadRequest, "Invalid request payload", err)
+		RespondWithError(w, http.StatusBadRequest, "Invalid hashtag ID", err)
 		return
 	}
 
-	// Check if all users are valid.
-	users := newProject.UserIds
-	for _, userId := range users {
-		if !repository.UserExists(userId) {
-			RespondWithError(w, http.StatusInternalServerError, "Users do not Exist.", err)
-			return
+	// Perform transaction to restore hashtag in the database
+	err = repository.RestoreHashtagTransaction(AuditInfo(r), hashtagID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			RespondWithError(w, http.StatusNotFound, "Deleted hashtag not found", err)
+		} else {
+			RespondWithHashtagWriteError(w, "Failed to restore hashtag", err)
 		}
+		return
 	}
 
-	// Check if all hashtags are valid.
-	hashtags := newProject.HashtagIds
-	for _, hashtagId := range hashtags {
-		if !repository.HashtagExists(hashtagId) {
-			RespondWithError(w, http.StatusInternalServerError, "Hashtags do not Exist.", err)
-			return
+	// Respond with success message
+	RespondWithJSON(w, http.StatusOK, map[string]string{"message": "Hashtag restored successfully"})
+}
+
+func MergeHashtag(w http.ResponseWriter, r *http.Request) {
+	// Get source hashtag ID from URL parameters
+	vars := mux.Vars(r)
+	hashtagIDStr := vars["id"]
+	hashtagID, err := strconv.Atoi(hashtagIDStr)
+	if err != nil {
+		RespondWithError(w, http.StatusBadRequest, "Invalid hashtag ID", err)
+		return
+	}
+
+	// Parse and validate request data
+	var merge models.HashtagMerge
+	if !DecodeRequest(w, r, &merge) {
+		return
+	}
+
+	// Perform transaction to merge the hashtags and resync their projects
+	projectIds, err := repository.MergeHashtagsTransaction(AuditInfo(r), hashtagID, &merge)
+	if err != nil {
+		switch {
+		case errors.Is(err, repository.ErrMergeIntoSelf):
+			RespondWithError(w, http.StatusBadRequest, "Cannot merge a hashtag into itself", err)
+		case errors.Is(err, sql.ErrNoRows):
+			RespondWithError(w, http.StatusNotFound, "Hashtag not found", err)
+		case repository.IsDeadlock(err):
+			w.Header().Set("Retry-After", "1")
+			RespondWithError(w, http.StatusServiceUnavailable, "Merge conflicted with a concurrent change, retry it", err)
+		default:
+			RespondWithError(w, http.StatusInternalServerError, "Failed to merge hashtags", err)
 		}
+		return
+	}
+
+	// Respond with success message
+	RespondWithJSON(w, http.StatusOK, map[string]interface{}{"message": "Hashtags merged successfully", "affected_project_ids": projectIds})
+}
+
+func CreateProject(w http.ResponseWriter, r *http.Request) {
+	// Parse and validate request data
+	var newProject models.Project
+	if !DecodeRequest(w, r, &newProject) {
+		return
+	}
+	if errs := validateUserRoles(&newProject); errs != nil {
+		RespondWithValidationErrors(w, errs)
+		return
 	}
 
 	//Start project creation transaction to insert project into database.
-	err = repository.ProjectCreationAndSyncTransaction(&newProject)
+	err := repository.ProjectCreationAndSyncTransaction(AuditInfo(r), &newProject)
 	if err != nil {
-		RespondWithError(w, http.StatusInternalServerError, "Failed to create new project. Transaction failed.", err)
+		RespondWithProjectWriteError(w, "Failed to create new project. Transaction failed.", err)
 		return
 	}
 
-	// Respond with success message
-	RespondWithJSON(w, http.StatusCreated, map[string]string{"message": "Project created successfully"})
+	// Respond with the created project and its users and hashtags
+	project, err := repository.GetProjectDetails(newProject.ID)
+	if err != nil {
+		RespondWithError(w, http.StatusInternalServerError, "Project created but failed to fetch it", err)
+		return
+	}
+	w.Header().Set("Location", ResourceURL(r, "projects", project.ID))
+	RespondWithJSON(w, http.StatusCreated, project)
 }
 
 func GetProject(w http.ResponseWriter, r *http.Request) {
@@ -294,9 +538,41 @@ func GetProject(w http.ResponseWriter, r *http.Request) {
 	RespondWithJSON(w, http.StatusOK, project)
 }
 
+func GetProjectBySlug(w http.ResponseWriter, r *http.Request) {
+	// Get project slug from URL parameters
+	vars := mux.Vars(r)
+	projectSlug := vars["slug"]
+
+	// Query the database for the project
+	var project models.Project
+	err := repository.GetProjectBySlug(projectSlug, &project)
+	if err == sql.ErrNoRows {
+		// Redirect old slugs to the project's current slug
+		currentSlug, historyErr := repository.GetCurrentSlugForHistoricSlug(projectSlug)
+		if historyErr == nil {
+			http.Redirect(w, r, path.Dir(r.URL.Path)+"/"+url.PathEscape(currentSlug), http.StatusMovedPermanently)
+			return
+		}
+		if historyErr != sql.ErrNoRows {
+			err = historyErr
+		}
+	}
+	if err != nil {
+		if err == sql.ErrNoRows {
+			RespondWithError(w, http.StatusNotFound, "Project not found", err)
+		} else {
+			RespondWithError(w, http.StatusInternalServerError, "Failed to fetch project", err)
+		}
+		return
+	}
+
+	// Respond with the project's information
+	RespondWithJSON(w, http.StatusOK, project)
+}
+
 func GetAllProjects(w http.ResponseWriter, r *http.Request) {
-	// Query the database to retrieve all projects
-	projects, err := repository.GetAllProjects()
+	// Query the database to retrieve all projects, optionally including deleted ones
+	projects, err := repository.GetAllProjects(r.URL.Query().Get("include_deleted") == "true")
 	if err != nil {
 		RespondWithError(w, http.StatusInternalServerError, "Failed to fetch projects", err)
 		return
@@ -316,43 +592,106 @@ func UpdateProject(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Parse request data
+	// Parse and validate request data
 	var newProject models.Project
-	err = json.NewDecoder(r.Body).Decode(&newProject)
+	if !DecodeRequest(w, r, &newProject) {
+		return
+	}
+
+	saveProjectUpdate(w, r, projectID, &newProject)
+}
+
+func PatchProject(w http.ResponseWriter, r *http.Request) {
+	// Get project ID from URL parameters
+	vars := mux.Vars(r)
+	projectIDStr := vars["id"]
+	projectID, err := strconv.Atoi(projectIDStr)
 	if err != nil {
-		RespondWithError(w, http.StatusBadRequest, "Invalid request payload", err)
+		RespondWithError(w, http.StatusBadRequest, "Invalid project ID", err)
 		return
 	}
 
-	// Check if all users are valid.
-	users := newProject.UserIds
-	for _, userId := range users {
-		if !repository.UserExists(userId) {
-			RespondWithError(w, http.StatusInternalServerError, "Users do not Exist.", err)
-			return
-		}
+	patch, ok := ReadRequestBody(w, r)
+	if !ok {
+		return
 	}
 
-	// Check if all hashtags are valid.
-	hashtags := newProject.HashtagIds
-	for _, hashtagId := range hashtags {
-		if !repository.HashtagExists(hashtagId) {
-			RespondWithError(w, http.StatusInternalServerError, "Hashtags do not Exist.", err)
-			return
+	// Apply and validate the merge patch inside the update transaction, so concurrent changes of
+	// the project are not lost. Links are only replaced when the patch changes them.
+	links := patchedProjectLinks(patch)
+	err = repository.ProjectPatchAndSyncTransaction(AuditInfo(r), projectID, links, func(currentProject *models.Project) (*models.Project, error) {
+		var newProject models.Project
+		err := mergePatch(currentProject, patch, &newProject)
+		if err != nil {
+			return nil, err
+		}
+
+		// Drop the current roles of users the patch removed from user_ids
+		for userID := range newProject.UserRoles {
+			if _, current := currentProject.UserRoles[userID]; current && !containsID(newProject.UserIds, userID) {
+				delete(newProject.UserRoles, userID)
+			}
+		}
+
+		if errs := validateUserRoles(&newProject); errs != nil {
+			return nil, errs
+		}
+		return &newProject, nil
+	})
+	if err != nil {
+		if isRequestError(err) {
+			respondWithRequestError(w, err)
+		} else {
+			RespondWithProjectWriteError(w, "Failed to update project. Update Transaction failed.", err)
 		}
+		return
+	}
+
+	respondWithUpdatedProject(w, projectID)
+}
+
+// patchedProjectLinks selects the links of a project that a merge patch replaces: the users when
+// it has user_ids or user_roles, the hashtags when it has hashtag_ids. A patch that is not a JSON
+// object replaces the whole project.
+func patchedProjectLinks(patch []byte) repository.ProjectLinks {
+	var fields map[string]json.RawMessage
+	if json.Unmarshal(patch, &fields) != nil {
+		return repository.AllProjectLinks
 	}
 
+	_, userIds := fields["user_ids"]
+	_, userRoles := fields["user_roles"]
+	_, hashtagIds := fields["hashtag_ids"]
+	return repository.ProjectLinks{Users: userIds || userRoles, Hashtags: hashtagIds}
+}
+
+// saveProjectUpdate runs the project update transaction and responds with the updated project.
+func saveProjectUpdate(w http.ResponseWriter, r *http.Request, projectID int, newProject *models.Project) {
 	newProject.ID = projectID // set project id
 
+	if errs := validateUserRoles(newProject); errs != nil {
+		RespondWithValidationErrors(w, errs)
+		return
+	}
+
 	//Start project update transaction to update project into database.
-	err = repository.ProjectUpdateAndSyncTransaction(&newProject)
+	err := repository.ProjectUpdateAndSyncTransaction(AuditInfo(r), newProject)
 	if err != nil {
-		RespondWithError(w, http.StatusInternalServerError, "Failed to update project. Update Transaction failed.", err)
+		RespondWithProjectWriteError(w, "Failed to update project. Update Transaction failed.", err)
 		return
 	}
 
-	// Respond with success message
-	RespondWithJSON(w, http.StatusCreated, map[string]string{"message": "Project updated successfully"})
+	respondWithUpdatedProject(w, projectID)
+}
+
+// respondWithUpdatedProject responds with the updated project and its users and hashtags.
+func respondWithUpdatedProject(w http.ResponseWriter, projectID int) {
+	project, err := repository.GetProjectDetails(projectID)
+	if err != nil {
+		RespondWithError(w, http.StatusInternalServerError, "Project updated but failed to fetch it", err)
+		return
+	}
+	RespondWithJSON(w, http.StatusOK, project)
 }
 
 func DeleteProject(w http.ResponseWriter, r *http.Request) {
@@ -366,9 +705,13 @@ func DeleteProject(w http.ResponseWriter, r *http.Request) {
 	}
 
 	//Start project Delete transaction to delete project into database.
-	err = repository.ProjectDeleteAndSyncTransaction(projectID)
+	err = repository.ProjectDeleteAndSyncTransaction(AuditInfo(r), projectID)
 	if err != nil {
-		RespondWithError(w, http.StatusInternalServerError, "Failed to delete project. Delete Transaction failed.", err)
+		if err == sql.ErrNoRows {
+			RespondWithError(w, http.StatusNotFound, "Project not found", err)
+		} else {
+			RespondWithError(w, http.StatusInternalServerError, "Failed to delete project. Delete Transaction failed.", err)
+		}
 		return
 	}
 
@@ -376,15 +719,784 @@ func DeleteProject(w http.ResponseWriter, r *http.Request) {
 	RespondWithJSON(w, http.StatusCreated, map[string]string{"message": "Project deleted successfully"})
 }
 
-func RespondWithError(w http.ResponseWriter, code int, message string, err error) {
-	fmt.Println(err)
-	w.WriteHeader(code)
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"error": message})
-}
+func RestoreProject(w http.ResponseWriter, r *http.Request) {
+	// Get project ID from URL parameters
+	vars := mux.Vars(r)
+	projectIDStr := vars["id"]
+	projectID, err := strconv.Atoi(projectIDStr)
+	if err != nil {
+		RespondWithError(w, http.StatusBadRequest, "Invalid project ID", err)
+		return
+	}
 
-func RespondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
-	w.WriteHeader(code)
-	w.Header().Set("Content-Type", "application/json")
+	// Perform transaction to restore project in the database
+	err = repository.ProjectRestoreAndSyncTransaction(AuditInfo(r), projectID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			RespondWithError(w, http.StatusNotFound, "Deleted project not found", err)
+		} else {
+			RespondWithError(w, http.StatusInternalServerError, "Failed to restore project", err)
+		}
+		return
+	}
+
+	// Respond with success message
+	RespondWithJSON(w, http.StatusOK, map[string]string{"message": "Project restored successfully"})
+}
+
+func BulkProjects(w http.ResponseWriter, r *http.Request) {
+	// Parse and validate request data
+	var request models.BulkProjectRequest
+	if !DecodeRequest(w, r, &request) {
+		return
+	}
+
+	// Validate every operation on its own, passing only the valid ones on
+	results := make([]models.BulkProjectResult, len(request.Operations))
+	var ops []models.BulkProjectOperation
+	var indexes []int
+	for i := range request.Operations {
+		op := &request.Operations[i]
+		results[i] = models.BulkProjectResult{Index: i, Op: op.Op, ID: op.ID}
+
+		if errs := validateBulkOperation(op); errs != nil {
+			results[i].Status = http.StatusBadRequest
+			results[i].Error = "Invalid operation"
+			results[i].Details = errs
+			if request.Atomic {
+				RespondWithJSON(w, http.StatusBadRequest, map[string]interface{}{
+					"error":  fmt.Sprintf("Operation %d is invalid, no changes were applied", i),
+					"result": results[i],
+				})
+				return
+			}
+			continue
+		}
+
+		ops = append(ops, *op)
+		indexes = append(indexes, i)
+	}
+
+	// Perform transaction to apply the operations and sync the affected projects
+	opErrs, err := repository.ProjectBulkTransaction(AuditInfo(r), ops, request.Atomic)
+	var bulkErr *repository.BulkOperationError
+	if errors.As(err, &bulkErr) {
+		i := indexes[bulkErr.Index]
+		setBulkResultError(&results[i], bulkErr.Err)
+		RespondWithJSON(w, results[i].Status, map[string]interface{}{
+			"error":  fmt.Sprintf("Operation %d failed, no changes were applied", i),
+			"result": results[i],
+		})
+		return
+	}
+	if err != nil {
+		RespondWithError(w, http.StatusInternalServerError, "Failed to apply bulk operations. Transaction failed.", err)
+		return
+	}
+
+	// Respond with the result of every operation
+	for k, i := range indexes {
+		if opErrs[k] != nil {
+			setBulkResultError(&results[i], opErrs[k])
+			continue
+		}
+		if ops[k].Op == "create" {
+			results[i].ID = ops[k].Project.ID
+			results[i].Status = http.StatusCreated
+		} else {
+			results[i].Status = http.StatusOK
+		}
+	}
+	RespondWithJSON(w, http.StatusOK, map[string]interface{}{"results": results})
+}
+
+// validateBulkOperation checks that an operation has the ID and project it needs and validates the project.
+func validateBulkOperation(op *models.BulkProjectOperation) validation.Errors {
+	err := validation.Validate(op)
+	if errs, ok := err.(validation.Errors); ok {
+		return errs
+	}
+
+	var errs validation.Errors
+	if op.Op != "create" && op.ID <= 0 {
+		errs = append(errs, validation.FieldError{Field: "id", Message: "is required"})
+	}
+	if op.Op == "delete" {
+		return errs
+	}
+	if op.Project == nil {
+		return append(errs, validation.FieldError{Field: "project", Message: "is required"})
+	}
+
+	err = validation.Validate(op.Project)
+	if projectErrs, ok := err.(validation.Errors); ok {
+		for _, fieldErr := range projectErrs {
+			errs = append(errs, validation.FieldError{Field: "project." + fieldErr.Field, Message: fieldErr.Message})
+		}
+	}
+	for _, fieldErr := range validateUserRoles(op.Project) {
+		errs = append(errs, validation.FieldError{Field: "project." + fieldErr.Field, Message: fieldErr.Message})
+	}
+	return errs
+}
+
+func setBulkResultError(result *models.BulkProjectResult, err error) {
+	result.Status, result.Error = projectWriteErrorStatus(err, "Operation failed")
+	fmt.Printf("bulk operation %d: %v\n", result.Index, err)
+
+	var missingErr *repository.MissingReferencesError
+	if errors.As(err, &missingErr) {
+		result.MissingUserIds = missingErr.UserIds
+		result.MissingHashtagIds = missingErr.HashtagIds
+	}
+}
+
+// validateUserRoles checks that roles are only given for users listed in user_ids.
+func validateUserRoles(project *models.Project) validation.Errors {
+	var errs validation.Errors
+	for userID := range project.UserRoles {
+		if !containsID(project.UserIds, userID) {
+			errs = append(errs, validation.FieldError{Field: "user_roles", Message: fmt.Sprintf("user %d is not in user_ids", userID)})
+		}
+	}
+	return errs
+}
+
+func containsID(ids []int, id int) bool {
+	for _, v := range ids {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
+
+func GetUserProjects(w http.ResponseWriter, r *http.Request) {
+	// Get user ID from URL parameters
+	vars := mux.Vars(r)
+	userIDStr := vars["id"]
+	userID, err := strconv.Atoi(userIDStr)
+	if err != nil {
+		RespondWithError(w, http.StatusBadRequest, "Invalid user ID", err)
+		return
+	}
+
+	// Check if the user exists
+	if !repository.UserExists(userID) {
+		RespondWithError(w, http.StatusNotFound, "User not found", nil)
+		return
+	}
+
+	// Get the optional role filter from query parameters
+	role := r.URL.Query().Get("role")
+	if role != "" && !models.IsProjectRole(role) {
+		RespondWithError(w, http.StatusBadRequest, "Invalid role, expected owner, maintainer, contributor or viewer", nil)
+		return
+	}
+
+	// Query the database for the projects of the user
+	projects, err := repository.GetUserProjects(userID, role)
+	if err != nil {
+		RespondWithError(w, http.StatusInternalServerError, "Failed to fetch projects", err)
+		return
+	}
+
+	// Respond with the list of projects
+	RespondWithJSON(w, http.StatusOK, projects)
+}
+
+func GetHashtagProjects(w http.ResponseWriter, r *http.Request) {
+	// Get hashtag ID from URL parameters
+	vars := mux.Vars(r)
+	hashtagIDStr := vars["id"]
+	hashtagID, err := strconv.Atoi(hashtagIDStr)
+	if err != nil {
+		RespondWithError(w, http.StatusBadRequest, "Invalid hashtag ID", err)
+		return
+	}
+
+	// Check if the hashtag exists
+	if !repository.HashtagExists(hashtagID) {
+		RespondWithError(w, http.StatusNotFound, "Hashtag not found", nil)
+		return
+	}
+
+	// Query the database for the projects tagged with the hashtag
+	projects, err := repository.GetHashtagProjects(hashtagID)
+	if err != nil {
+		RespondWithError(w, http.StatusInternalServerError, "Failed to fetch projects", err)
+		return
+	}
+
+	// Respond with the list of projects
+	RespondWithJSON(w, http.StatusOK, projects)
+}
+
+func AddProjectUser(w http.ResponseWriter, r *http.Request) {
+	// Parse the optional role of the user
+	body, ok := ReadRequestBody(w, r)
+	if !ok {
+		return
+	}
+	var membership models.ProjectMembership
+	if len(bytes.TrimSpace(body)) > 0 && !DecodeAndValidate(w, body, &membership) {
+		return
+	}
+
+	changeProjectLink(w, r, "userId", "Invalid user ID", func(info models.AuditInfo, projectId int, userId int) (bool, error) {
+		return repository.AddProjectUserTransaction(info, projectId, userId, membership.Role)
+	})
+}
+
+func RemoveProjectUser(w http.ResponseWriter, r *http.Request) {
+	changeProjectLink(w, r, "userId", "Invalid user ID", repository.RemoveProjectUserTransaction)
+}
+
+func AddProjectHashtag(w http.ResponseWriter, r *http.Request) {
+	changeProjectLink(w, r, "hashtagId", "Invalid hashtag ID", repository.AddProjectHashtagTransaction)
+}
+
+func RemoveProjectHashtag(w http.ResponseWriter, r *http.Request) {
+	changeProjectLink(w, r, "hashtagId", "Invalid hashtag ID", repository.RemoveProjectHashtagTransaction)
+}
+
+// changeProjectLink handles the membership and tagging sub-resources of a project: it parses the
+// project ID and the linked ID named by linkVar, applies change and responds with the project.
+func changeProjectLink(w http.ResponseWriter, r *http.Request, linkVar string, invalidLinkMessage string,
+	change func(info models.AuditInfo, projectId int, linkId int) (bool, error)) {
+	// Get project and linked IDs from URL parameters
+	vars := mux.Vars(r)
+	projectID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		RespondWithError(w, http.StatusBadRequest, "Invalid project ID", err)
+		return
+	}
+	linkID, err := strconv.Atoi(vars[linkVar])
+	if err != nil {
+		RespondWithError(w, http.StatusBadRequest, invalidLinkMessage, err)
+		return
+	}
+
+	// Perform transaction to change the link and sync the project
+	_, err = change(AuditInfo(r), projectID, linkID)
+	if err != nil {
+		RespondWithProjectWriteError(w, "Failed to update project", err)
+		return
+	}
+
+	// Respond with the project as it is now
+	project, err := repository.GetProjectDetails(projectID)
+	if err != nil {
+		RespondWithError(w, http.StatusInternalServerError, "Failed to fetch project", err)
+		return
+	}
+	RespondWithJSON(w, http.StatusOK, project)
+}
+
+func ImportData(w http.ResponseWriter, r *http.Request) {
+	// Get entity, format and dry-run mode from query parameters
+	query := r.URL.Query()
+	entity := query.Get("entity")
+	format := query.Get("format")
+	if format == "" {
+		format = importer.FormatFromContentType(r.Header.Get("Content-Type"))
+	}
+	dryRun := query.Get("dry_run") == "true"
+
+	// Parse the import file
+	records, err := importer.Parse(http.MaxBytesReader(w, r.Body, maxImportBytes), entity, format)
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			RespondWithError(w, http.StatusRequestEntityTooLarge, "Import file too large", err)
+		} else {
+			RespondWithError(w, http.StatusBadRequest, "Invalid import file", err)
+		}
+		return
+	}
+
+	// Perform transaction to import the records and sync the imported projects
+	report, err := importer.Import(AuditInfo(r), entity, records, dryRun)
+	if err != nil {
+		RespondWithError(w, http.StatusInternalServerError, "Failed to import. Transaction failed.", err)
+		return
+	}
+
+	// Respond with the import report
+	RespondWithJSON(w, http.StatusOK, report)
+}
+
+func ExportData(w http.ResponseWriter, r *http.Request) {
+	// Get entity from URL parameters and format from query parameters
+	entity := mux.Vars(r)["entity"]
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = exporter.FormatNDJSON
+	}
+	err := exporter.Check(entity, format)
+	if err != nil {
+		RespondWithError(w, http.StatusBadRequest, "Invalid export request", err)
+		return
+	}
+
+	extension := "ndjson"
+	if format == exporter.FormatCSV {
+		extension = "csv"
+	}
+	w.Header().Set("Content-Type", exporter.ContentType(format))
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.%s"`, entity, extension))
+
+	// Stream the rows, flushing them to the client batch by batch
+	out := &startedWriter{ResponseWriter: w}
+	flush := func() {
+		if flusher, ok := w.(http.Flusher); ok {
+			flusher.Flush()
+		}
+	}
+	err = exporter.Write(r.Context(), out, flush, entity, format, r.URL.Query().Get("include_deleted") == "true")
+	if err != nil {
+		if !out.started {
+			w.Header().Del("Content-Disposition")
+			RespondWithError(w, http.StatusInternalServerError, "Failed to export", err)
+			return
+		}
+		// The status was sent with the first rows, so the export can only be cut short.
+		fmt.Println("Export failed:", err)
+	}
+}
+
+// startedWriter remembers whether anything was written to the response yet.
+type startedWriter struct {
+	http.ResponseWriter
+	started bool
+}
+
+func (sw *startedWriter) Write(data []byte) (int, error) {
+	sw.started = true
+	return sw.ResponseWriter.Write(data)
+}
+
+func GetAuditEvents(w http.ResponseWriter, r *http.Request) {
+	// Get entity type, optional entity ID and limit from query parameters
+	query := r.URL.Query()
+	entityType := query.Get("entity")
+	if entityType != repository.AuditEntityUser && entityType != repository.AuditEntityHashtag && entityType != repository.AuditEntityProject {
+		RespondWithError(w, http.StatusBadRequest, "Invalid entity, expected user, hashtag or project", nil)
+		return
+	}
+
+	entityID := 0
+	if idStr := query.Get("id"); idStr != "" {
+		id, err := strconv.Atoi(idStr)
+		if err != nil || id <= 0 {
+			RespondWithError(w, http.StatusBadRequest, "Invalid entity ID", err)
+			return
+		}
+		entityID = id
+	}
+
+	limit := defaultAuditLimit
+	if limitStr := query.Get("limit"); limitStr != "" {
+		parsed, err := strconv.Atoi(limitStr)
+		if err != nil || parsed <= 0 || parsed > maxAuditLimit {
+			RespondWithError(w, http.StatusBadRequest, fmt.Sprintf("Invalid limit, expected 1 to %d", maxAuditLimit), err)
+			return
+		}
+		limit = parsed
+	}
+
+	// Query the database for the audit history, newest first
+	events, err := repository.GetAuditEvents(entityType, entityID, limit)
+	if err != nil {
+		RespondWithError(w, http.StatusInternalServerError, "Failed to fetch audit events", err)
+		return
+	}
+
+	// Respond with the audit history
+	RespondWithJSON(w, http.StatusOK, events)
+}
+
+func GetOpenAPI(w http.ResponseWriter, r *http.Request) {
+	// Build the specification once and serve it as is
+	spec, err := openapi.JSON()
+	if err != nil {
+		RespondWithError(w, http.StatusInternalServerError, "Failed to build OpenAPI specification", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(spec)
+}
+
+// RequestIDMiddleware makes sure every request carries an X-Request-ID header and echoes it
+// in the response so audit events can be traced back to requests.
+func RequestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get("X-Request-ID")
+		if requestID == "" {
+			requestID = uuid.New().String()
+			r.Header.Set("X-Request-ID", requestID)
+		}
+		w.Header().Set("X-Request-ID", requestID)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// IdempotencyMiddleware replays the stored response of a write request retried with the same
+// Idempotency-Key header by the same actor instead of applying it again. Reusing a key with a
+// different method, URL or body is rejected with 422. Successful responses are kept for
+// IDEMPOTENCY_KEY_TTL; other responses are not stored so the request can be retried. A request
+// that has not responded within IDEMPOTENCY_KEY_LEASE loses its key to the next retry.
+func IdempotencyMiddleware(next http.Handler) http.Handler {
+	ttl := config.Duration("IDEMPOTENCY_KEY_TTL", defaultIdempotencyKeyTTL)
+	lease := config.Duration("IDEMPOTENCY_KEY_LEASE", defaultIdempotencyKeyLease)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" || r.Method == http.MethodGet || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			RespondWithError(w, http.StatusBadRequest, fmt.Sprintf("Idempotency-Key must be at most %d characters", maxIdempotencyKeyLength), nil)
+			return
+		}
+
+		// Read the body to fingerprint the request, then hand it on to the handler
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, requestBodyLimit(r)))
+		if err != nil {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				RespondWithError(w, http.StatusRequestEntityTooLarge, "Request payload too large", err)
+			} else {
+				RespondWithError(w, http.StatusBadRequest, "Failed to read request payload", err)
+			}
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		hash := sha256.New()
+		fmt.Fprintf(hash, "%s\n%s\n", r.Method, r.URL.RequestURI())
+		hash.Write(body)
+		requestHash := hex.EncodeToString(hash.Sum(nil))
+
+		// Claim the key of the actor or find the response of an earlier attempt
+		actor := AuditInfo(r).Actor
+		claimedAt, stored, err := repository.ClaimIdempotencyKey(actor, key, requestHash, ttl, lease)
+		switch {
+		case errors.Is(err, repository.ErrIdempotencyKeyReused):
+			RespondWithError(w, http.StatusUnprocessableEntity, "Idempotency-Key was already used with a different request", err)
+			return
+		case errors.Is(err, repository.ErrIdempotencyKeyInProgress):
+			RespondWithError(w, http.StatusConflict, "A request with this Idempotency-Key is still in progress", err)
+			return
+		case err != nil:
+			RespondWithError(w, http.StatusInternalServerError, "Failed to check Idempotency-Key", err)
+			return
+		}
+
+		if stored != nil {
+			for name, value := range stored.Headers {
+				w.Header().Set(name, value)
+			}
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(stored.StatusCode)
+			w.Write(stored.Body)
+			return
+		}
+
+		// Process the request and store its response
+		recorder := &responseRecorder{ResponseWriter: w}
+		next.ServeHTTP(recorder, r)
+
+		if recorder.statusCode < http.StatusOK || recorder.statusCode >= http.StatusMultipleChoices {
+			err = repository.ReleaseIdempotencyKey(actor, key, claimedAt)
+		} else {
+			err = repository.SaveIdempotentResponse(actor, key, claimedAt, &models.IdempotentResponse{
+				StatusCode: recorder.statusCode,
+				Headers:    replayedHeaders(w.Header()),
+				Body:       recorder.body.Bytes(),
+			})
+		}
+		if err != nil {
+			fmt.Println("Failed to store idempotent response:", err)
+		}
+	})
+}
+
+// requestBodyLimit returns the largest request body the handler of r accepts.
+func requestBodyLimit(r *http.Request) int64 {
+	if strings.TrimPrefix(r.URL.Path, "/v1") == "/import" {
+		return maxImportBytes
+	}
+	return maxRequestBodyBytes
+}
+
+// replayedHeaders picks the response headers worth replaying with a stored response.
+func replayedHeaders(header http.Header) map[string]string {
+	headers := make(map[string]string)
+	for _, name := range []string{"Content-Type", "Location", "Deprecation", "Link"} {
+		if value := header.Get(name); value != "" {
+			headers[name] = value
+		}
+	}
+	return headers
+}
+
+// responseRecorder passes a response through while keeping a copy of its status and body.
+type responseRecorder struct {
+	http.ResponseWriter
+	statusCode int
+	body       bytes.Buffer
+}
+
+func (rec *responseRecorder) WriteHeader(statusCode int) {
+	if rec.statusCode == 0 {
+		rec.statusCode = statusCode
+	}
+	rec.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (rec *responseRecorder) Write(data []byte) (int, error) {
+	if rec.statusCode == 0 {
+		rec.statusCode = http.StatusOK
+	}
+	rec.body.Write(data)
+	return rec.ResponseWriter.Write(data)
+}
+
+// AuditInfo reads the acting user from the X-Actor header and the request ID set by RequestIDMiddleware.
+func AuditInfo(r *http.Request) models.AuditInfo {
+	actor := r.Header.Get("X-Actor")
+	if actor == "" {
+		actor = "anonymous"
+	}
+	return models.AuditInfo{Actor: actor, RequestID: r.Header.Get("X-Request-ID")}
+}
+
+// DecodeRequest parses a JSON request body into dst and runs its validation rules.
+// It responds with the appropriate error and returns false when the request is rejected.
+func DecodeRequest(w http.ResponseWriter, r *http.Request, dst interface{}) bool {
+	body, ok := ReadRequestBody(w, r)
+	if !ok {
+		return false
+	}
+
+	return DecodeAndValidate(w, body, dst)
+}
+
+// DecodePatch applies a JSON Merge Patch request body to the current resource, then decodes
+// and validates the result into dst like DecodeRequest does for a full body.
+func DecodePatch(w http.ResponseWriter, r *http.Request, current interface{}, dst interface{}) bool {
+	patch, ok := ReadRequestBody(w, r)
+	if !ok {
+		return false
+	}
+
+	err := mergePatch(current, patch, dst)
+	if err != nil {
+		respondWithRequestError(w, err)
+		return false
+	}
+	return true
+}
+
+// ReadRequestBody reads the request body up to maxRequestBodyBytes.
+func ReadRequestBody(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestBodyBytes))
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			RespondWithError(w, http.StatusRequestEntityTooLarge, "Request payload too large", err)
+		} else {
+			RespondWithError(w, http.StatusBadRequest, "Failed to read request payload", err)
+		}
+		return nil, false
+	}
+	return body, true
+}
+
+// DecodeAndValidate decodes a single JSON object into dst, rejecting unknown fields, and runs its validation rules.
+func DecodeAndValidate(w http.ResponseWriter, body []byte, dst interface{}) bool {
+	err := decodeAndValidate(body, dst)
+	if err != nil {
+		respondWithRequestError(w, err)
+		return false
+	}
+	return true
+}
+
+// requestError rejects a request payload with a status code and message.
+type requestError struct {
+	code    int
+	message string
+	err     error
+}
+
+func (e *requestError) Error() string {
+	return e.message + ": " + e.err.Error()
+}
+
+func (e *requestError) Unwrap() error {
+	return e.err
+}
+
+// mergePatch applies a JSON Merge Patch to the current resource, then decodes and validates
+// the result into dst. It fails with a *requestError or validation.Errors.
+func mergePatch(current interface{}, patch []byte, dst interface{}) error {
+	original, err := json.Marshal(current)
+	if err != nil {
+		return &requestError{http.StatusInternalServerError, "Failed to encode current resource", err}
+	}
+
+	merged, err := mergepatch.Apply(original, patch)
+	if err != nil {
+		return &requestError{http.StatusBadRequest, "Invalid merge patch payload", err}
+	}
+
+	return decodeAndValidate(merged, dst)
+}
+
+// decodeAndValidate decodes a single JSON object into dst, rejecting unknown fields, and runs its
+// validation rules. It fails with a *requestError or validation.Errors.
+func decodeAndValidate(body []byte, dst interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.DisallowUnknownFields()
+
+	err := decoder.Decode(dst)
+	if err == nil && decoder.More() {
+		err = errors.New("request body must contain a single JSON object")
+	}
+	if err != nil {
+		return &requestError{http.StatusBadRequest, "Invalid request payload", err}
+	}
+
+	err = validation.Validate(dst)
+	if err != nil {
+		var validationErrs validation.Errors
+		if errors.As(err, &validationErrs) {
+			return validationErrs
+		}
+		return &requestError{http.StatusInternalServerError, "Failed to validate request payload", err}
+	}
+	return nil
+}
+
+// isRequestError reports whether err rejects the request payload.
+func isRequestError(err error) bool {
+	var reqErr *requestError
+	var validationErrs validation.Errors
+	return errors.As(err, &reqErr) || errors.As(err, &validationErrs)
+}
+
+// respondWithRequestError responds to an error of mergePatch or decodeAndValidate.
+func respondWithRequestError(w http.ResponseWriter, err error) {
+	var reqErr *requestError
+	var validationErrs validation.Errors
+	switch {
+	case errors.As(err, &validationErrs):
+		RespondWithValidationErrors(w, validationErrs)
+	case errors.As(err, &reqErr):
+		RespondWithError(w, reqErr.code, reqErr.message, reqErr.err)
+	default:
+		RespondWithError(w, http.StatusInternalServerError, "Failed to process request payload", err)
+	}
+}
+
+// ResourceURL builds the URL of a resource, keeping the /v1 prefix when the request used it.
+func ResourceURL(r *http.Request, collection string, id int) string {
+	prefix := ""
+	if strings.HasPrefix(r.URL.Path, "/v1/") {
+		prefix = "/v1"
+	}
+	return prefix + "/" + collection + "/" + strconv.Itoa(id)
+}
+
+// DeprecatedRouteMiddleware marks the legacy unversioned routes as deprecated and points to the /v1 successor.
+func DeprecatedRouteMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		successor := strings.Replace(strings.Replace(r.URL.Path, "/update/", "/", 1), "/delete/", "/", 1)
+		w.Header().Set("Deprecation", "true")
+		w.Header().Set("Link", "</v1"+successor+`>; rel="successor-version"`)
+		next.ServeHTTP(w, r)
+	})
+}
+
+func RespondWithError(w http.ResponseWriter, code int, message string, err error) {
+	fmt.Println(err)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
+
+func RespondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
 	json.NewEncoder(w).Encode(payload)
 }
+
+func RespondWithValidationErrors(w http.ResponseWriter, errs validation.Errors) {
+	fmt.Println(errs)
+	RespondWithJSON(w, http.StatusBadRequest, map[string]interface{}{"error": "Invalid request payload", "details": errs})
+}
+
+// RespondWithHashtagWriteError maps errors of the hashtag write transactions to responses.
+func RespondWithHashtagWriteError(w http.ResponseWriter, message string, err error) {
+	if errors.Is(err, sql.ErrNoRows) {
+		RespondWithError(w, http.StatusNotFound, "Hashtag not found", err)
+		return
+	}
+
+	var conflictErr *repository.HashtagConflictError
+	if errors.As(err, &conflictErr) {
+		fmt.Println(conflictErr)
+		RespondWithJSON(w, http.StatusConflict, map[string]interface{}{
+			"error":             "Hashtag name or alias already in use",
+			"conflicting_names": conflictErr.Names,
+		})
+		return
+	}
+	RespondWithError(w, http.StatusInternalServerError, message, err)
+}
+
+// RespondWithProjectWriteError maps errors of the project write transactions to responses.
+func RespondWithProjectWriteError(w http.ResponseWriter, message string, err error) {
+	var missingErr *repository.MissingReferencesError
+	if errors.As(err, &missingErr) {
+		RespondWithMissingReferences(w, missingErr)
+		return
+	}
+
+	code, message := projectWriteErrorStatus(err, message)
+	RespondWithError(w, code, message, err)
+}
+
+// projectWriteErrorStatus maps an error of a project write to a response status and message,
+// falling back to 500 with the given message.
+func projectWriteErrorStatus(err error, message string) (int, string) {
+	var missingErr *repository.MissingReferencesError
+	switch {
+	case errors.As(err, &missingErr):
+		return http.StatusUnprocessableEntity, "Referenced users or hashtags do not exist"
+	case errors.Is(err, repository.ErrSlugTaken):
+		return http.StatusConflict, "Slug is already in use"
+	case errors.Is(err, repository.ErrNoProjectOwner):
+		return http.StatusUnprocessableEntity, "Project must have at least one owner"
+	case errors.Is(err, repository.ErrLinkNotFound):
+		return http.StatusNotFound, "Link not found"
+	case errors.Is(err, sql.ErrNoRows):
+		return http.StatusNotFound, "Project not found"
+	default:
+		return http.StatusInternalServerError, message
+	}
+}
+
+func RespondWithMissingReferences(w http.ResponseWriter, missingErr *repository.MissingReferencesError) {
+	fmt.Println(missingErr)
+	RespondWithJSON(w, http.StatusUnprocessableEntity, map[string]interface{}{
+		"error":               "Referenced users or hashtags do not exist",
+		"missing_user_ids":    missingErr.UserIds,
+		"missing_hashtag_ids": missingErr.HashtagIds,
+	})
+}
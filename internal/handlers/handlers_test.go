@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fold/internal/models"
+	"fold/internal/validation"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDecodeRequest(t *testing.T) {
+	tests := []struct {
+		name        string
+		body        string
+		wantStatus  int
+		wantError   string
+		wantDetails []map[string]string
+	}{
+		{
+			name:       "valid project",
+			body:       `{"name": "Fold", "slug": "fold", "user_ids": [1, 1, 2]}`,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "malformed JSON",
+			body:       `{"name": `,
+			wantStatus: http.StatusBadRequest,
+			wantError:  "Invalid request payload",
+		},
+		{
+			name:       "unknown field",
+			body:       `{"name": "Fold", "slug": "fold", "owner": 1}`,
+			wantStatus: http.StatusBadRequest,
+			wantError:  "Invalid request payload",
+		},
+		{
+			name:       "trailing data",
+			body:       `{"name": "Fold", "slug": "fold"} {}`,
+			wantStatus: http.StatusBadRequest,
+			wantError:  "Invalid request payload",
+		},
+		{
+			name:       "oversized body",
+			body:       `{"name": "` + strings.Repeat("x", maxRequestBodyBytes) + `"}`,
+			wantStatus: http.StatusRequestEntityTooLarge,
+			wantError:  "Request payload too large",
+		},
+		{
+			name:       "rule violations",
+			body:       `{"name": "", "slug": "Fold Search"}`,
+			wantStatus: http.StatusBadRequest,
+			wantError:  "Invalid request payload",
+			wantDetails: []map[string]string{
+				{"field": "name", "message": "is required"},
+				{"field": "slug", "message": "must contain only lowercase letters, digits and single hyphens"},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/projects", strings.NewReader(test.body))
+			w := httptest.NewRecorder()
+			var project models.Project
+			if DecodeRequest(w, r, &project) {
+				w.WriteHeader(http.StatusOK)
+			}
+
+			if w.Code != test.wantStatus {
+				t.Fatalf("status %d, want %d", w.Code, test.wantStatus)
+			}
+			if test.wantStatus == http.StatusOK {
+				if !reflect.DeepEqual(project.UserIds, []int{1, 2}) {
+					t.Fatalf("user_ids %v, want duplicates removed", project.UserIds)
+				}
+				return
+			}
+
+			var response struct {
+				Error   string              `json:"error"`
+				Details []map[string]string `json:"details"`
+			}
+			err := json.Unmarshal(w.Body.Bytes(), &response)
+			if err != nil {
+				t.Fatalf("response %q is not JSON: %v", w.Body.String(), err)
+			}
+			if response.Error != test.wantError || !reflect.DeepEqual(response.Details, test.wantDetails) {
+				t.Fatalf("response %s, want error %q with details %v", w.Body.String(), test.wantError, test.wantDetails)
+			}
+		})
+	}
+}
+
+func TestValidateBulkOperation(t *testing.T) {
+	tests := []struct {
+		name string
+		op   models.BulkProjectOperation
+		want validation.Errors
+	}{
+		{
+			name: "valid create",
+			op:   models.BulkProjectOperation{Op: "create", Project: &models.Project{Name: "Fold", UserIds: []int{1}}},
+		},
+		{
+			name: "valid delete",
+			op:   models.BulkProjectOperation{Op: "delete", ID: 1},
+		},
+		{
+			name: "unknown operation",
+			op:   models.BulkProjectOperation{Op: "upsert"},
+			want: validation.Errors{{Field: "op", Message: "must be one of create, update, delete"}},
+		},
+		{
+			name: "missing ID and project",
+			op:   models.BulkProjectOperation{Op: "update"},
+			want: validation.Errors{{Field: "id", Message: "is required"}, {Field: "project", Message: "is required"}},
+		},
+		{
+			name: "project errors are prefixed",
+			op: models.BulkProjectOperation{Op: "create", Project: &models.Project{
+				Slug: "Fold Search", UserIds: []int{1}, UserRoles: map[int]string{2: models.RoleOwner},
+			}},
+			want: validation.Errors{
+				{Field: "project.name", Message: "is required"},
+				{Field: "project.slug", Message: "must contain only lowercase letters, digits and single hyphens"},
+				{Field: "project.user_roles", Message: "user 2 is not in user_ids"},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			errs := validateBulkOperation(&test.op)
+			if !reflect.DeepEqual(errs, test.want) {
+				t.Fatalf("got %v, want %v", errs, test.want)
+			}
+		})
+	}
+}
+
+func TestIdempotencyMiddlewareRejectsOversizedBody(t *testing.T) {
+	handler := IdempotencyMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("oversized request reached the handler")
+	}))
+
+	body := bytes.Repeat([]byte("x"), maxRequestBodyBytes+1)
+	r := httptest.NewRequest(http.MethodPost, "/v1/users", bytes.NewReader(body))
+	r.Header.Set("Idempotency-Key", "key-1")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("status %d, want %d", w.Code, http.StatusRequestEntityTooLarge)
+	}
+}
+
+func TestRequestBodyLimit(t *testing.T) {
+	tests := []struct {
+		target string
+		want   int64
+	}{
+		{"/users", maxRequestBodyBytes},
+		{"/v1/projects/bulk", maxRequestBodyBytes},
+		{"/import?entity=users", maxImportBytes},
+		{"/v1/import?entity=projects&dry_run=true", maxImportBytes},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest(http.MethodPost, test.target, nil)
+		if got := requestBodyLimit(r); got != test.want {
+			t.Errorf("requestBodyLimit(%s) = %d, want %d", test.target, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,328 @@
+package importer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"fold/internal/models"
+	"fold/internal/repository"
+	"fold/internal/validation"
+	"io"
+	"mime"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// Supported import file formats.
+const (
+	FormatNDJSON = "ndjson"
+	FormatCSV    = "csv"
+)
+
+// csvListSeparator separates the items of list columns such as aliases, users and hashtags in CSV files.
+const csvListSeparator = "|"
+
+// maxLineBytes caps the length of a single NDJSON line.
+const maxLineBytes = 1 << 20
+
+// Lines of NDJSON files. Users and hashtags of projects are IDs (numbers) or names (strings).
+type userLine struct {
+	Name string `json:"name"`
+}
+
+type hashtagLine struct {
+	Name    string   `json:"name"`
+	Aliases []string `json:"aliases"`
+}
+
+type projectLine struct {
+	Name        string            `json:"name"`
+	Slug        string            `json:"slug"`
+	Description string            `json:"description"`
+	Users       []json.RawMessage `json:"users"`
+	Hashtags    []json.RawMessage `json:"hashtags"`
+}
+
+// csvColumns lists the columns each entity accepts in CSV files; the first one is required.
+var csvColumns = map[string][]string{
+	repository.EntityUsers:    {"name"},
+	repository.EntityHashtags: {"name", "aliases"},
+	repository.EntityProjects: {"name", "slug", "description", "users", "hashtags"},
+}
+
+// FormatFromName guesses the format of an import file from its extension.
+func FormatFromName(name string) string {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".csv":
+		return FormatCSV
+	case ".ndjson", ".jsonl":
+		return FormatNDJSON
+	}
+	return ""
+}
+
+// FormatFromContentType guesses the format of an import file from the Content-Type of its request.
+func FormatFromContentType(contentType string) string {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch mediaType {
+	case "text/csv":
+		return FormatCSV
+	case "application/x-ndjson", "application/jsonl", "application/json":
+		return FormatNDJSON
+	}
+	return ""
+}
+
+// Import imports parsed records and reports the result of every line. It only returns an
+// error when the import as a whole fails.
+func Import(info models.AuditInfo, entity string, records []models.ImportRecord, dryRun bool) (models.ImportReport, error) {
+	report := models.ImportReport{Entity: entity, DryRun: dryRun, Errors: []models.ImportError{}}
+
+	ids, errs, err := repository.ImportTransaction(info, entity, records, dryRun)
+	if err != nil {
+		return report, err
+	}
+
+	report.Total = len(records)
+	for i, record := range records {
+		if errs[i] != nil {
+			report.Failed++
+			report.Errors = append(report.Errors, lineError(record.Line, errs[i]))
+			continue
+		}
+		report.Imported++
+		if !dryRun {
+			report.IDs = append(report.IDs, ids[i])
+		}
+	}
+	return report, nil
+}
+
+func lineError(line int, err error) models.ImportError {
+	var validationErrs validation.Errors
+	var missingErr *repository.MissingReferencesError
+	switch {
+	case errors.As(err, &validationErrs):
+		return models.ImportError{Line: line, Error: "Invalid record", Details: validationErrs}
+	case errors.As(err, &missingErr):
+		return models.ImportError{Line: line, Error: "Referenced users or hashtags do not exist", Details: map[string][]int{
+			"missing_user_ids":    missingErr.UserIds,
+			"missing_hashtag_ids": missingErr.HashtagIds,
+		}}
+	default:
+		return models.ImportError{Line: line, Error: err.Error()}
+	}
+}
+
+// Parse reads the records of an import file of the given entity and format. Lines that cannot be
+// parsed or fail validation are returned with their error set.
+func Parse(r io.Reader, entity string, format string) ([]models.ImportRecord, error) {
+	if _, ok := csvColumns[entity]; !ok {
+		return nil, fmt.Errorf("unknown entity %q, expected users, hashtags or projects", entity)
+	}
+
+	switch format {
+	case FormatNDJSON:
+		return parseNDJSON(r, entity)
+	case FormatCSV:
+		return parseCSV(r, entity)
+	default:
+		return nil, fmt.Errorf("unknown format %q, expected ndjson or csv", format)
+	}
+}
+
+func parseNDJSON(r io.Reader, entity string) ([]models.ImportRecord, error) {
+	var records []models.ImportRecord
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineBytes)
+	for line := 1; scanner.Scan(); line++ {
+		data := bytes.TrimSpace(scanner.Bytes())
+		if len(data) == 0 {
+			continue
+		}
+
+		record := models.ImportRecord{Line: line}
+		record.Err = decodeNDJSONLine(data, entity, &record)
+		if record.Err == nil {
+			record.Err = validateRecord(&record)
+		}
+		records = append(records, record)
+	}
+
+	return records, scanner.Err()
+}
+
+func decodeNDJSONLine(data []byte, entity string, record *models.ImportRecord) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+
+	switch entity {
+	case repository.EntityUsers:
+		var line userLine
+		if err := decoder.Decode(&line); err != nil {
+			return err
+		}
+		record.User = &models.User{Name: line.Name}
+	case repository.EntityHashtags:
+		var line hashtagLine
+		if err := decoder.Decode(&line); err != nil {
+			return err
+		}
+		record.Hashtag = &models.Hashtag{Name: line.Name, Aliases: line.Aliases}
+	case repository.EntityProjects:
+		var line projectLine
+		if err := decoder.Decode(&line); err != nil {
+			return err
+		}
+		record.Project = &models.Project{Name: line.Name, Slug: line.Slug, Description: line.Description}
+
+		var err error
+		record.UserRefs, err = jsonReferences(line.Users, "users")
+		if err != nil {
+			return err
+		}
+		record.HashtagRefs, err = jsonReferences(line.Hashtags, "hashtags")
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// jsonReferences reads a list of IDs (numbers) and names (strings).
+func jsonReferences(items []json.RawMessage, field string) ([]models.ImportReference, error) {
+	var refs []models.ImportReference
+	for i, item := range items {
+		var id int
+		if err := json.Unmarshal(item, &id); err == nil && id > 0 {
+			refs = append(refs, models.ImportReference{ID: id})
+			continue
+		}
+
+		var name string
+		if err := json.Unmarshal(item, &name); err != nil || strings.TrimSpace(name) == "" {
+			return nil, fmt.Errorf("%s item %d must be a positive ID or a name", field, i)
+		}
+		refs = append(refs, models.ImportReference{Name: strings.TrimSpace(name)})
+	}
+	return refs, nil
+}
+
+func parseCSV(r io.Reader, entity string) ([]models.ImportRecord, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	// Map the header to the known columns.
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	columns := make(map[string]int)
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !contains(csvColumns[entity], name) {
+			return nil, fmt.Errorf("unknown column %q for %s, expected %s", name, entity, strings.Join(csvColumns[entity], ", "))
+		}
+		columns[name] = i
+	}
+	if _, ok := columns[csvColumns[entity][0]]; !ok {
+		return nil, fmt.Errorf("missing column %q", csvColumns[entity][0])
+	}
+
+	var records []models.ImportRecord
+	for {
+		fields, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if !errors.As(err, &parseErr) {
+				return nil, err
+			}
+			records = append(records, models.ImportRecord{Line: parseErr.StartLine, Err: parseErr.Err})
+			continue
+		}
+		line, _ := reader.FieldPos(0)
+		record := models.ImportRecord{Line: line}
+
+		value := func(column string) string {
+			i, ok := columns[column]
+			if !ok || i >= len(fields) {
+				return ""
+			}
+			return strings.TrimSpace(fields[i])
+		}
+
+		switch entity {
+		case repository.EntityUsers:
+			record.User = &models.User{Name: value("name")}
+		case repository.EntityHashtags:
+			record.Hashtag = &models.Hashtag{Name: value("name"), Aliases: csvList(value("aliases"))}
+		case repository.EntityProjects:
+			record.Project = &models.Project{Name: value("name"), Slug: value("slug"), Description: value("description")}
+			record.UserRefs = csvReferences(value("users"))
+			record.HashtagRefs = csvReferences(value("hashtags"))
+		}
+
+		record.Err = validateRecord(&record)
+		records = append(records, record)
+	}
+
+	return records, nil
+}
+
+func csvList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, csvListSeparator) {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// csvReferences reads a list of IDs and names, where items made of digits only are IDs.
+func csvReferences(value string) []models.ImportReference {
+	var refs []models.ImportReference
+	for _, item := range csvList(value) {
+		if id, err := strconv.Atoi(item); err == nil && id > 0 {
+			refs = append(refs, models.ImportReference{ID: id})
+		} else {
+			refs = append(refs, models.ImportReference{Name: item})
+		}
+	}
+	return refs
+}
+
+// validateRecord runs the validation rules of the record's entity. The users and hashtags of a
+// project are checked once they are resolved during the import.
+func validateRecord(record *models.ImportRecord) error {
+	switch {
+	case record.User != nil:
+		return validation.Validate(record.User)
+	case record.Hashtag != nil:
+		return validation.Validate(record.Hashtag)
+	case record.Project != nil:
+		return validation.Validate(record.Project)
+	}
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,43 @@
+package jobs
+
+import (
+	"fmt"
+	"fold/internal/config"
+	"fold/internal/repository"
+	"time"
+)
+
+const (
+	defaultRetention     = 30 * 24 * time.Hour
+	defaultPurgeInterval = time.Hour
+)
+
+// StartPurgeJob periodically hard-deletes rows that were soft-deleted longer ago than the
+// retention window, and expired idempotency keys. SOFT_DELETE_RETENTION and PURGE_INTERVAL
+// accept Go durations such as 720h.
+func StartPurgeJob() {
+	retention := config.Duration("SOFT_DELETE_RETENTION", defaultRetention)
+	interval := config.Duration("PURGE_INTERVAL", defaultPurgeInterval)
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			result, err := repository.PurgeDeletedTransaction(time.Now().Add(-retention))
+			if err != nil {
+				fmt.Println("Purge of deleted rows failed:", err)
+			} else if result.Users+result.Hashtags+result.Projects > 0 {
+				fmt.Printf("Purged %d users, %d hashtags and %d projects\n", result.Users, result.Hashtags, result.Projects)
+			}
+
+			expired, err := repository.DeleteExpiredIdempotencyKeys()
+			if err != nil {
+				fmt.Println("Purge of expired idempotency keys failed:", err)
+			} else if expired > 0 {
+				fmt.Printf("Purged %d expired idempotency keys\n", expired)
+			}
+			<-ticker.C
+		}
+	}()
+}
@@ -0,0 +1,46 @@
+package mergepatch
+
+import (
+	"encoding/json"
+)
+
+// Apply applies a JSON Merge Patch (RFC 7386) to an original JSON document: object members of
+// the patch replace or, when null, remove the members of the original, recursively.
+func Apply(original []byte, patch []byte) ([]byte, error) {
+	var originalValue interface{}
+	if len(original) > 0 {
+		err := json.Unmarshal(original, &originalValue)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var patchValue interface{}
+	err := json.Unmarshal(patch, &patchValue)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(merge(originalValue, patchValue))
+}
+
+func merge(target interface{}, patch interface{}) interface{} {
+	patchObject, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	targetObject, ok := target.(map[string]interface{})
+	if !ok {
+		targetObject = map[string]interface{}{}
+	}
+
+	for key, value := range patchObject {
+		if value == nil {
+			delete(targetObject, key)
+		} else {
+			targetObject[key] = merge(targetObject[key], value)
+		}
+	}
+	return targetObject
+}
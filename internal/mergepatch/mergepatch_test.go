@@ -0,0 +1,51 @@
+package mergepatch
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestApply(t *testing.T) {
+	tests := []struct {
+		name     string
+		original string
+		patch    string
+		want     string
+	}{
+		{"replaces a member", `{"name":"a","slug":"b"}`, `{"name":"c"}`, `{"name":"c","slug":"b"}`},
+		{"adds a member", `{"name":"a"}`, `{"slug":"b"}`, `{"name":"a","slug":"b"}`},
+		{"removes a member with null", `{"name":"a","slug":"b"}`, `{"slug":null}`, `{"name":"a"}`},
+		{"replaces arrays whole", `{"user_ids":[1,2]}`, `{"user_ids":[3]}`, `{"user_ids":[3]}`},
+		{"merges nested objects", `{"a":{"b":1,"c":2}}`, `{"a":{"c":null,"d":3}}`, `{"a":{"b":1,"d":3}}`},
+		{"replaces a non-object with an object", `{"a":1}`, `{"a":{"b":2}}`, `{"a":{"b":2}}`},
+		{"replaces the document with a non-object patch", `{"a":1}`, `[1]`, `[1]`},
+		{"patches an empty original", ``, `{"a":1,"b":null}`, `{"a":1}`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := Apply([]byte(test.original), []byte(test.patch))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var gotValue, wantValue interface{}
+			json.Unmarshal(got, &gotValue)
+			json.Unmarshal([]byte(test.want), &wantValue)
+			if !reflect.DeepEqual(gotValue, wantValue) {
+				t.Fatalf("got %s, want %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestApplyRejectsInvalidJSON(t *testing.T) {
+	_, err := Apply([]byte(`{"a":1}`), []byte(`{`))
+	if err == nil {
+		t.Fatal("invalid patch was accepted")
+	}
+	_, err = Apply([]byte(`{`), []byte(`{"a":1}`))
+	if err == nil {
+		t.Fatal("invalid original was accepted")
+	}
+}
@@ -1,43 +1,223 @@
 package models
 
 import (
+	"encoding/json"
 	"time"
 )
 
 // Define struct for entities
 type User struct {
-	ID        int       `json:"id"`
-	Name      string    `json:"name"`
-	CreatedAt time.Time `json:"created_at"`
+	ID        int        `json:"id"`
+	Name      string     `json:"name" validate:"required,max=100"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
 }
 
 type Hashtag struct {
-	ID        int       `json:"id"`
-	Name      string    `json:"name"`
-	CreatedAt time.Time `json:"created_at"`
+	ID        int        `json:"id"`
+	Name      string     `json:"name" validate:"required,max=50,hashtag"`
+	Aliases   []string   `json:"aliases" validate:"dedupe,max=20,hashtag"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+}
+
+type HashtagMerge struct {
+	TargetID    int  `json:"target_id" validate:"required"`
+	KeepAsAlias bool `json:"keep_as_alias"`
+}
+
+// Roles a user can have on a project. Every project keeps at least one owner.
+const (
+	RoleOwner       = "owner"
+	RoleMaintainer  = "maintainer"
+	RoleContributor = "contributor"
+	RoleViewer      = "viewer"
+
+	DefaultRole = RoleContributor
+)
+
+func IsProjectRole(role string) bool {
+	switch role {
+	case RoleOwner, RoleMaintainer, RoleContributor, RoleViewer:
+		return true
+	}
+	return false
 }
 
 type Project struct {
-	ID          int       `json:"id"`
-	Name        string    `json:"name"`
-	Slug        string    `json:"slug"`
-	Description string    `json:"description"`
-	CreatedAt   time.Time `json:"created_at"`
-	UserIds     []int     `json:"user_ids"`
-	HashtagIds  []int     `json:"hashtag_ids"`
+	ID          int            `json:"id"`
+	Name        string         `json:"name" validate:"required,max=200"`
+	Slug        string         `json:"slug" validate:"max=200,slug"`
+	Description string         `json:"description" validate:"max=10000"`
+	CreatedAt   time.Time      `json:"created_at"`
+	UpdatedAt   time.Time      `json:"updated_at"`
+	UserIds     []int          `json:"user_ids" validate:"dedupe,max=100"`
+	UserRoles   map[int]string `json:"user_roles,omitempty" validate:"oneof=owner maintainer contributor viewer"`
+	HashtagIds  []int          `json:"hashtag_ids" validate:"dedupe,max=50"`
+	DeletedAt   *time.Time     `json:"deleted_at,omitempty"`
 }
 
 type DenormalizedProject struct {
-	ID          int       `json:"id"`
-	Name        string    `json:"name"`
-	Slug        string    `json:"slug"`
-	Description string    `json:"description"`
-	CreatedAt   time.Time `json:"created_at"`
-	Users       []User    `json:"users"`
-	Hashtags    []Hashtag `json:"hashtags"`
-}
-
-type Payload struct {
-	Doc    DenormalizedProject `json:"doc"`
-	Method string              `json:"method"`
+	ID          int             `json:"id"`
+	Name        string          `json:"name"`
+	Slug        string          `json:"slug"`
+	Description string          `json:"description"`
+	CreatedAt   time.Time       `json:"created_at"`
+	UpdatedAt   time.Time       `json:"updated_at"`
+	Users       []ProjectMember `json:"users"`
+	Hashtags    []Hashtag       `json:"hashtags"`
+}
+
+// ProjectMember is a user of a denormalized project together with their role on it.
+type ProjectMember struct {
+	User
+	Role string `json:"role"`
+}
+
+// ProjectMembership is the optional body of a request adding a user to a project.
+type ProjectMembership struct {
+	Role string `json:"role" validate:"oneof=owner maintainer contributor viewer"`
+}
+
+// BulkProjectOperation is one create, update or delete of a bulk project request. Updates
+// replace the project with ID like PUT does.
+type BulkProjectOperation struct {
+	Op      string   `json:"op" validate:"required,oneof=create update delete"`
+	ID      int      `json:"id"`
+	Project *Project `json:"project"`
+}
+
+type BulkProjectRequest struct {
+	Atomic     bool                   `json:"atomic"`
+	Operations []BulkProjectOperation `json:"operations" validate:"required,max=1000"`
+}
+
+// BulkProjectResult reports the outcome of the operation at Index of a bulk project request.
+type BulkProjectResult struct {
+	Index             int         `json:"index"`
+	Op                string      `json:"op"`
+	ID                int         `json:"id,omitempty"`
+	Status            int         `json:"status"`
+	Error             string      `json:"error,omitempty"`
+	Details           interface{} `json:"details,omitempty"`
+	MissingUserIds    []int       `json:"missing_user_ids,omitempty"`
+	MissingHashtagIds []int       `json:"missing_hashtag_ids,omitempty"`
+}
+
+// ImportReference points to a user or hashtag of an imported project by ID or, when ID is zero, by name.
+type ImportReference struct {
+	ID   int
+	Name string
+}
+
+// ImportRecord is one parsed line of an import file. Only the field matching the imported entity is set.
+type ImportRecord struct {
+	Line        int
+	User        *User
+	Hashtag     *Hashtag
+	Project     *Project
+	UserRefs    []ImportReference
+	HashtagRefs []ImportReference
+	// Err is set when the line could not be parsed or is invalid.
+	Err error
+}
+
+type ImportError struct {
+	Line    int         `json:"line"`
+	Error   string      `json:"error"`
+	Details interface{} `json:"details,omitempty"`
+}
+
+// ImportReport summarizes an import: the IDs of the imported rows and the error of every failed line.
+type ImportReport struct {
+	Entity   string        `json:"entity"`
+	DryRun   bool          `json:"dry_run"`
+	Total    int           `json:"total"`
+	Imported int           `json:"imported"`
+	Failed   int           `json:"failed"`
+	IDs      []int         `json:"ids,omitempty"`
+	Errors   []ImportError `json:"errors"`
+}
+
+// Sync events keep the search index in line with the projects. SyncSchemaVersion is raised on
+// every incompatible change of SyncEvent.
+const (
+	SyncSchemaVersion = 2
+
+	EventProjectUpserted = "project.upserted"
+	EventProjectDeleted  = "project.deleted"
+	EventUserRenamed     = "user.renamed"
+	EventHashtagRenamed  = "hashtag.renamed"
+)
+
+// EventCause is the change that triggered a sync event, such as the update of a project or the
+// rename of one of its users. The events a user or hashtag change fans out to its projects share
+// a CorrelationID and carry their number in FanOut.
+type EventCause struct {
+	EntityType    string `json:"entity_type" validate:"required,oneof=user hashtag project"`
+	EntityID      int    `json:"entity_id" validate:"required"`
+	Action        string `json:"action" validate:"required"`
+	RequestID     string `json:"request_id,omitempty"`
+	CorrelationID string `json:"correlation_id,omitempty"`
+	FanOut        int    `json:"fan_out,omitempty"`
+}
+
+// SyncEvent is the versioned envelope of a message on the sync queue. EntityRevision grows with
+// every event of an entity, so consumers can drop events older than the indexed document.
+// Project events carry the whole Doc, rename events only the changed User or Hashtag, which
+// consumers apply to every project document containing it. The EntityRevision of a rename
+// event counts the events of its user or hashtag, so it only orders the renames of that user
+// or hashtag and must not be compared with the revisions of project documents.
+type SyncEvent struct {
+	SchemaVersion  int                  `json:"schema_version" validate:"required"`
+	EventID        string               `json:"event_id" validate:"required"`
+	EventType      string               `json:"event_type" validate:"required,oneof=project.upserted project.deleted user.renamed hashtag.renamed"`
+	OccurredAt     time.Time            `json:"occurred_at" validate:"required"`
+	EntityRevision int64                `json:"entity_revision" validate:"required"`
+	Cause          *EventCause          `json:"cause,omitempty"`
+	Doc            *DenormalizedProject `json:"doc,omitempty"`
+	User           *RenamedUser         `json:"user,omitempty"`
+	Hashtag        *RenamedHashtag      `json:"hashtag,omitempty"`
+}
+
+// RenamedUser is the part of a user that is denormalized into project documents.
+type RenamedUser struct {
+	ID        int       `json:"id" validate:"required"`
+	Name      string    `json:"name" validate:"required"`
+	UpdatedAt time.Time `json:"updated_at" validate:"required"`
+}
+
+// RenamedHashtag is the part of a hashtag that is denormalized into project documents.
+type RenamedHashtag struct {
+	ID        int       `json:"id" validate:"required"`
+	Name      string    `json:"name" validate:"required"`
+	Aliases   []string  `json:"aliases" validate:"required"`
+	UpdatedAt time.Time `json:"updated_at" validate:"required"`
+}
+
+// AuditInfo identifies who made a change and in which request.
+type AuditInfo struct {
+	Actor     string
+	RequestID string
+}
+
+// IdempotentResponse is a stored response replayed for retries with the same Idempotency-Key.
+type IdempotentResponse struct {
+	StatusCode int
+	Headers    map[string]string
+	Body       []byte
+}
+
+type AuditEvent struct {
+	ID         int64           `json:"id"`
+	EntityType string          `json:"entity_type"`
+	EntityID   int             `json:"entity_id"`
+	Action     string          `json:"action"`
+	Actor      string          `json:"actor"`
+	RequestID  string          `json:"request_id"`
+	Before     json.RawMessage `json:"before"`
+	After      json.RawMessage `json:"after"`
+	CreatedAt  time.Time       `json:"created_at"`
 }
@@ -0,0 +1,32 @@
+package normalize
+
+import (
+	"strings"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+var folder = cases.Fold()
+
+// Hashtag returns the canonical form of a hashtag name: surrounding whitespace and leading #
+// are removed, compatibility characters are unified with NFKC and the result is case-folded.
+func Hashtag(name string) string {
+	name = strings.TrimLeft(strings.TrimSpace(name), "#")
+	return norm.NFKC.String(folder.String(norm.NFKC.String(name)))
+}
+
+// Hashtags normalizes every name and drops empty and repeated entries.
+func Hashtags(names []string) []string {
+	seen := make(map[string]bool, len(names))
+	normalized := make([]string, 0, len(names))
+	for _, name := range names {
+		name = Hashtag(name)
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		normalized = append(normalized, name)
+	}
+	return normalized
+}
@@ -0,0 +1,210 @@
+package openapi
+
+import (
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Patterns of the slug and hashtag validation rules, see the validation package.
+const (
+	slugPattern    = `^[a-z0-9]+(?:-[a-z0-9]+)*$`
+	hashtagPattern = `^#?[\p{L}\p{N}_]+$`
+)
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	rawMessageType = reflect.TypeOf(json.RawMessage{})
+)
+
+// schemaBuilder derives JSON schemas from Go types, collecting every struct as a named component.
+type schemaBuilder struct {
+	components map[string]interface{}
+	refPrefix  string
+	// jsonSchema writes nullable types the JSON Schema way instead of with the OpenAPI 3.0 keyword
+	jsonSchema bool
+}
+
+func newSchemaBuilder() *schemaBuilder {
+	return &schemaBuilder{components: map[string]interface{}{}, refPrefix: "#/components/schemas/"}
+}
+
+// JSONSchema builds a standalone JSON Schema (draft 2020-12) of the value's type, with the
+// structs it uses under $defs.
+func JSONSchema(v interface{}, id string, title string) map[string]interface{} {
+	b := &schemaBuilder{components: map[string]interface{}{}, refPrefix: "#/$defs/", jsonSchema: true}
+	root := b.schemaOf(v)
+
+	schema := map[string]interface{}{
+		"$schema": "https://json-schema.org/draft/2020-12/schema",
+		"$id":     id,
+		"title":   title,
+		"$defs":   b.components,
+	}
+	for key, value := range root {
+		schema[key] = value
+	}
+	return schema
+}
+
+// schemaOf returns the schema of the value's type, a $ref for structs.
+func (b *schemaBuilder) schemaOf(v interface{}) map[string]interface{} {
+	return b.schema(reflect.TypeOf(v))
+}
+
+func (b *schemaBuilder) schema(t reflect.Type) map[string]interface{} {
+	if t == rawMessageType {
+		return map[string]interface{}{}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		schema := b.schema(t.Elem())
+		_, isRef := schema["$ref"]
+		switch {
+		case b.jsonSchema && isRef:
+			return map[string]interface{}{"anyOf": []interface{}{schema, map[string]interface{}{"type": "null"}}}
+		case b.jsonSchema:
+			if schemaType, ok := schema["type"].(string); ok {
+				schema["type"] = []string{schemaType, "null"}
+			}
+		case isRef:
+			return map[string]interface{}{"allOf": []interface{}{schema}, "nullable": true}
+		default:
+			schema["nullable"] = true
+		}
+		return schema
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int32:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Int64:
+		return map[string]interface{}{"type": "integer", "format": "int64"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": b.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": b.schema(t.Elem())}
+	case reflect.Struct:
+		if t == timeType {
+			return map[string]interface{}{"type": "string", "format": "date-time"}
+		}
+		return b.ref(t)
+	default:
+		// interface{} and anything else accept any JSON value
+		return map[string]interface{}{}
+	}
+}
+
+// ref registers the struct as a component on first use and returns a reference to it.
+func (b *schemaBuilder) ref(t reflect.Type) map[string]interface{} {
+	name := componentName(t)
+	if _, ok := b.components[name]; !ok {
+		// Reserve the name first so self-referencing types terminate
+		b.components[name] = nil
+		b.components[name] = b.object(t)
+	}
+	return map[string]interface{}{"$ref": b.refPrefix + name}
+}
+
+func (b *schemaBuilder) object(t reflect.Type) map[string]interface{} {
+	properties := map[string]interface{}{}
+	var required []string
+	b.addFields(t, properties, &required)
+
+	object := map[string]interface{}{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		object["required"] = required
+	}
+	return object
+}
+
+// addFields adds the JSON properties of the struct's fields, flattening embedded structs the
+// way encoding/json does.
+func (b *schemaBuilder) addFields(t reflect.Type, properties map[string]interface{}, required *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		jsonTag := field.Tag.Get("json")
+		if jsonTag == "-" || !field.IsExported() {
+			continue
+		}
+		if field.Anonymous && jsonTag == "" && field.Type.Kind() == reflect.Struct {
+			b.addFields(field.Type, properties, required)
+			continue
+		}
+
+		name, options, _ := strings.Cut(jsonTag, ",")
+		if name == "" {
+			name = field.Name
+		}
+
+		// encoding/json leaves out nil pointers tagged omitempty, so they are never null
+		fieldType := field.Type
+		if fieldType.Kind() == reflect.Ptr && strings.Contains(options, "omitempty") {
+			fieldType = fieldType.Elem()
+		}
+		schema := b.schema(fieldType)
+		if applyRules(schema, field.Tag.Get("validate")) {
+			*required = append(*required, name)
+		}
+		properties[name] = schema
+	}
+}
+
+// applyRules translates the validate tag into schema keywords and reports whether the field is required.
+func applyRules(schema map[string]interface{}, tag string) bool {
+	if tag == "" {
+		return false
+	}
+
+	required := false
+	for _, rule := range strings.Split(tag, ",") {
+		ruleName, arg, _ := strings.Cut(rule, "=")
+		switch ruleName {
+		case "required":
+			required = true
+		case "min", "max":
+			limit, err := strconv.Atoi(arg)
+			if err != nil {
+				continue
+			}
+			switch schema["type"] {
+			case "string":
+				schema[ruleName+"Length"] = limit
+			case "array":
+				schema[ruleName+"Items"] = limit
+			}
+		case "slug":
+			schema["pattern"] = slugPattern
+		case "hashtag":
+			target := schema
+			if items, ok := schema["items"].(map[string]interface{}); ok {
+				target = items
+			}
+			target["pattern"] = hashtagPattern
+		case "oneof":
+			target := schema
+			if values, ok := schema["additionalProperties"].(map[string]interface{}); ok {
+				target = values
+			}
+			target["enum"] = strings.Fields(arg)
+		}
+	}
+	return required
+}
+
+// componentName is the exported form of the type name, so unexported response shapes still get a proper name.
+func componentName(t reflect.Type) string {
+	name := []rune(t.Name())
+	if len(name) == 0 {
+		return "Object"
+	}
+	name[0] = unicode.ToUpper(name[0])
+	return string(name)
+}
@@ -0,0 +1,418 @@
+package openapi
+
+import (
+	"encoding/json"
+	"fold/internal/models"
+	"fold/internal/validation"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Path is the route serving the specification.
+const Path = "/openapi.json"
+
+// Shapes of the responses the handlers build from maps instead of models.
+type errorResponse struct {
+	Error string `json:"error" validate:"required"`
+}
+
+type validationErrorResponse struct {
+	Error   string            `json:"error" validate:"required"`
+	Details validation.Errors `json:"details,omitempty"`
+}
+
+type missingReferencesResponse struct {
+	Error             string `json:"error" validate:"required"`
+	MissingUserIds    []int  `json:"missing_user_ids,omitempty"`
+	MissingHashtagIds []int  `json:"missing_hashtag_ids,omitempty"`
+}
+
+type hashtagConflictResponse struct {
+	Error            string   `json:"error" validate:"required"`
+	ConflictingNames []string `json:"conflicting_names" validate:"required"`
+}
+
+type messageResponse struct {
+	Message string `json:"message" validate:"required"`
+}
+
+type hashtagMergeResponse struct {
+	Message            string `json:"message" validate:"required"`
+	AffectedProjectIds []int  `json:"affected_project_ids" validate:"required"`
+}
+
+type bulkProjectResponse struct {
+	Results []models.BulkProjectResult `json:"results" validate:"required"`
+}
+
+// bulkProjectFailure is returned when an operation of an atomic bulk request fails. Malformed
+// bulk requests get a validation error body instead.
+type bulkProjectFailure struct {
+	Error  string                   `json:"error" validate:"required"`
+	Result models.BulkProjectResult `json:"result"`
+}
+
+// response is a documented status of an operation. A nil body means the default error body of the status.
+type response struct {
+	status int
+	body   interface{}
+}
+
+type parameter struct {
+	name        string
+	description string
+	schema      map[string]interface{}
+	required    bool
+}
+
+type operation struct {
+	method  string
+	path    string // path below /v1
+	legacy  string // "METHOD path" of the deprecated unversioned route, empty when there is none
+	summary string
+	tag     string
+	query   []parameter
+	body    interface{}
+	// optionalBody marks a JSON request body that may be left out
+	optionalBody bool
+	// bodyTypes replaces the JSON request body with raw bodies of the given content types
+	bodyTypes []string
+	status    int
+	result    interface{}
+	// resultTypes replaces the JSON response body with raw bodies of the given content types
+	resultTypes []string
+	errors      []response
+}
+
+var (
+	includeDeleted = parameter{name: "include_deleted", description: "Also list soft-deleted rows", schema: boolean()}
+	entityEnum     = []string{"users", "hashtags", "projects"}
+)
+
+func boolean() map[string]interface{} {
+	return map[string]interface{}{"type": "boolean"}
+}
+
+func enum(values ...string) map[string]interface{} {
+	return map[string]interface{}{"type": "string", "enum": values}
+}
+
+func fail(statuses ...int) []response {
+	responses := make([]response, 0, len(statuses))
+	for _, status := range statuses {
+		responses = append(responses, response{status: status})
+	}
+	return responses
+}
+
+// operations lists every route registered by routes.SetRouter below /v1.
+var operations = []operation{
+	// Users
+	{method: "POST", path: "/users", legacy: "POST /users", summary: "Create user", tag: "users",
+		body: models.User{}, status: http.StatusCreated, result: models.User{}, errors: fail(400, 500)},
+	{method: "GET", path: "/users", legacy: "GET /users", summary: "Get all users", tag: "users",
+		query: []parameter{includeDeleted}, status: http.StatusOK, result: []models.User{}, errors: fail(500)},
+	{method: "GET", path: "/users/{id}", legacy: "GET /users/{id}", summary: "Get user", tag: "users",
+		status: http.StatusOK, result: models.User{}, errors: fail(400, 404, 500)},
+	{method: "PUT", path: "/users/{id}", legacy: "POST /users/update/{id}", summary: "Replace user", tag: "users",
+		body: models.User{}, status: http.StatusOK, result: models.User{}, errors: fail(400, 404, 500)},
+	{method: "PATCH", path: "/users/{id}", summary: "Merge patch user", tag: "users",
+		body: models.User{}, status: http.StatusOK, result: models.User{}, errors: fail(400, 404, 500)},
+	{method: "DELETE", path: "/users/{id}", legacy: "DELETE /users/delete/{id}", summary: "Delete user", tag: "users",
+		status: http.StatusOK, result: messageResponse{}, errors: fail(400, 404, 500)},
+	{method: "POST", path: "/users/{id}/restore", legacy: "POST /users/{id}/restore", summary: "Restore deleted user", tag: "users",
+		status: http.StatusOK, result: messageResponse{}, errors: fail(400, 404, 500)},
+	{method: "GET", path: "/users/{id}/projects", legacy: "GET /users/{id}/projects", summary: "Get projects of user", tag: "users",
+		query:  []parameter{{name: "role", description: "Only projects where the user has this role", schema: enum(models.RoleOwner, models.RoleMaintainer, models.RoleContributor, models.RoleViewer)}},
+		status: http.StatusOK, result: []models.Project{}, errors: fail(400, 404, 500)},
+
+	// Hashtags
+	{method: "POST", path: "/hashtags", legacy: "POST /hashtags", summary: "Create hashtag", tag: "hashtags",
+		body: models.Hashtag{}, status: http.StatusCreated, result: models.Hashtag{},
+		errors: append(fail(400, 500), response{http.StatusConflict, hashtagConflictResponse{}})},
+	{method: "GET", path: "/hashtags", legacy: "GET /hashtags", summary: "Get all hashtags", tag: "hashtags",
+		query: []parameter{includeDeleted}, status: http.StatusOK, result: []models.Hashtag{}, errors: fail(500)},
+	{method: "GET", path: "/hashtags/{id}", legacy: "GET /hashtags/{id}", summary: "Get hashtag", tag: "hashtags",
+		status: http.StatusOK, result: models.Hashtag{}, errors: fail(400, 404, 500)},
+	{method: "PUT", path: "/hashtags/{id}", legacy: "POST /hashtags/update/{id}", summary: "Replace hashtag", tag: "hashtags",
+		body: models.Hashtag{}, status: http.StatusOK, result: models.Hashtag{},
+		errors: append(fail(400, 404, 500), response{http.StatusConflict, hashtagConflictResponse{}})},
+	{method: "PATCH", path: "/hashtags/{id}", summary: "Merge patch hashtag", tag: "hashtags",
+		body: models.Hashtag{}, status: http.StatusOK, result: models.Hashtag{},
+		errors: append(fail(400, 404, 500), response{http.StatusConflict, hashtagConflictResponse{}})},
+	{method: "DELETE", path: "/hashtags/{id}", legacy: "DELETE /hashtags/delete/{id}", summary: "Delete hashtag", tag: "hashtags",
+		status: http.StatusOK, result: messageResponse{}, errors: fail(400, 404, 500)},
+	{method: "POST", path: "/hashtags/{id}/restore", legacy: "POST /hashtags/{id}/restore", summary: "Restore deleted hashtag", tag: "hashtags",
+		status: http.StatusOK, result: messageResponse{},
+		errors: append(fail(400, 404, 500), response{http.StatusConflict, hashtagConflictResponse{}})},
+	{method: "POST", path: "/hashtags/{id}/merge", legacy: "POST /hashtags/{id}/merge", summary: "Merge hashtag into another", tag: "hashtags",
+		body: models.HashtagMerge{}, status: http.StatusOK, result: hashtagMergeResponse{}, errors: fail(400, 404, 500, 503)},
+	{method: "GET", path: "/hashtags/{id}/projects", legacy: "GET /hashtags/{id}/projects", summary: "Get projects tagged with hashtag", tag: "hashtags",
+		status: http.StatusOK, result: []models.Project{}, errors: fail(400, 404, 500)},
+
+	// Projects
+	{method: "POST", path: "/projects", legacy: "POST /projects", summary: "Create project", tag: "projects",
+		body: models.Project{}, status: http.StatusCreated, result: models.DenormalizedProject{},
+		errors: append(fail(400, 409, 500), response{http.StatusUnprocessableEntity, missingReferencesResponse{}})},
+	{method: "POST", path: "/projects/bulk", legacy: "POST /projects/bulk", summary: "Bulk create, update and delete projects", tag: "projects",
+		body: models.BulkProjectRequest{}, status: http.StatusOK, result: bulkProjectResponse{},
+		errors: append(fail(500),
+			response{http.StatusBadRequest, bulkProjectFailure{}},
+			response{http.StatusNotFound, bulkProjectFailure{}},
+			response{http.StatusConflict, bulkProjectFailure{}},
+			response{http.StatusUnprocessableEntity, bulkProjectFailure{}})},
+	{method: "GET", path: "/projects", legacy: "GET /projects", summary: "Get all projects", tag: "projects",
+		query: []parameter{includeDeleted}, status: http.StatusOK, result: []models.Project{}, errors: fail(500)},
+	{method: "GET", path: "/projects/{id}", legacy: "GET /projects/{id}", summary: "Get project", tag: "projects",
+		status: http.StatusOK, result: models.Project{}, errors: fail(400, 404, 500)},
+	{method: "PUT", path: "/projects/{id}", legacy: "POST /projects/update/{id}", summary: "Replace project", tag: "projects",
+		body: models.Project{}, status: http.StatusOK, result: models.DenormalizedProject{},
+		errors: append(fail(400, 404, 409, 500), response{http.StatusUnprocessableEntity, missingReferencesResponse{}})},
+	{method: "PATCH", path: "/projects/{id}", summary: "Merge patch project", tag: "projects",
+		body: models.Project{}, status: http.StatusOK, result: models.DenormalizedProject{},
+		errors: append(fail(400, 404, 409, 500), response{http.StatusUnprocessableEntity, missingReferencesResponse{}})},
+	{method: "DELETE", path: "/projects/{id}", legacy: "DELETE /projects/delete/{id}", summary: "Delete project", tag: "projects",
+		status: http.StatusCreated, result: messageResponse{}, errors: fail(400, 404, 500)},
+	{method: "POST", path: "/projects/{id}/restore", legacy: "POST /projects/{id}/restore", summary: "Restore deleted project", tag: "projects",
+		status: http.StatusOK, result: messageResponse{}, errors: fail(400, 404, 500)},
+	{method: "PUT", path: "/projects/{id}/users/{userId}", legacy: "PUT /projects/{id}/users/{userId}", summary: "Add user to project", tag: "projects",
+		body: models.ProjectMembership{}, optionalBody: true, status: http.StatusOK, result: models.DenormalizedProject{},
+		errors: append(fail(400, 404, 500), response{http.StatusUnprocessableEntity, missingReferencesResponse{}})},
+	{method: "DELETE", path: "/projects/{id}/users/{userId}", legacy: "DELETE /projects/{id}/users/{userId}", summary: "Remove user from project", tag: "projects",
+		status: http.StatusOK, result: models.DenormalizedProject{}, errors: fail(400, 404, 422, 500)},
+	{method: "PUT", path: "/projects/{id}/hashtags/{hashtagId}", legacy: "PUT /projects/{id}/hashtags/{hashtagId}", summary: "Tag project with hashtag", tag: "projects",
+		status: http.StatusOK, result: models.DenormalizedProject{},
+		errors: append(fail(400, 404, 500), response{http.StatusUnprocessableEntity, missingReferencesResponse{}})},
+	{method: "DELETE", path: "/projects/{id}/hashtags/{hashtagId}", legacy: "DELETE /projects/{id}/hashtags/{hashtagId}", summary: "Remove hashtag from project", tag: "projects",
+		status: http.StatusOK, result: models.DenormalizedProject{}, errors: fail(400, 404, 500)},
+	{method: "GET", path: "/projects/by-slug/{slug}", legacy: "GET /projects/by-slug/{slug}", summary: "Get project by slug", tag: "projects",
+		status: http.StatusOK, result: models.Project{}, errors: fail(301, 404, 500)},
+
+	// Import, export and audit
+	{method: "POST", path: "/import", legacy: "POST /import", summary: "Import users, hashtags or projects", tag: "data",
+		query: []parameter{
+			{name: "entity", description: "Entity of the imported rows", schema: enum(entityEnum...), required: true},
+			{name: "format", description: "Format of the file, derived from Content-Type when missing", schema: enum("ndjson", "csv")},
+			{name: "dry_run", description: "Validate and report without importing", schema: boolean()},
+		},
+		bodyTypes: []string{"application/x-ndjson", "text/csv"},
+		status:    http.StatusOK, result: models.ImportReport{}, errors: fail(400, 413, 500)},
+	{method: "GET", path: "/export/{entity}", legacy: "GET /export/{entity}", summary: "Export users, hashtags or projects", tag: "data",
+		query: []parameter{
+			{name: "format", description: "Format of the export, ndjson by default; documents exports projects as search documents", schema: enum("ndjson", "csv", "documents")},
+			includeDeleted,
+		},
+		status: http.StatusOK, resultTypes: []string{"application/x-ndjson", "text/csv"}, errors: fail(400, 500)},
+	{method: "GET", path: "/audit", legacy: "GET /audit", summary: "Get audit history", tag: "audit",
+		query: []parameter{
+			{name: "entity", description: "Entity type of the history", schema: enum("user", "hashtag", "project"), required: true},
+			{name: "id", description: "Only the history of this entity", schema: map[string]interface{}{"type": "integer", "minimum": 1}},
+			{name: "limit", description: "Maximum number of events, newest first", schema: map[string]interface{}{"type": "integer", "minimum": 1, "maximum": 1000, "default": 100}},
+		},
+		status: http.StatusOK, result: []models.AuditEvent{}, errors: fail(400, 500)},
+}
+
+var (
+	specOnce sync.Once
+	specJSON []byte
+	specErr  error
+
+	pathParamPattern = regexp.MustCompile(`\{(\w+)\}`)
+)
+
+// JSON returns the OpenAPI 3 document describing the API, built once from the operations table.
+func JSON() ([]byte, error) {
+	specOnce.Do(func() {
+		specJSON, specErr = json.MarshalIndent(Spec(), "", "  ")
+	})
+	return specJSON, specErr
+}
+
+// Spec builds the OpenAPI 3 document describing the API.
+func Spec() map[string]interface{} {
+	b := newSchemaBuilder()
+	paths := map[string]map[string]interface{}{}
+	addPath := func(method, path string, op map[string]interface{}) {
+		if paths[path] == nil {
+			paths[path] = map[string]interface{}{}
+		}
+		paths[path][strings.ToLower(method)] = op
+	}
+
+	for _, op := range operations {
+		addPath(op.method, "/v1"+op.path, b.operation(op, false))
+		if op.legacy != "" {
+			method, path, _ := strings.Cut(op.legacy, " ")
+			addPath(method, path, b.operation(op, true))
+		}
+	}
+	addPath("GET", Path, map[string]interface{}{
+		"operationId": "getOpenAPI",
+		"summary":     "Get this OpenAPI document",
+		"tags":        []string{"meta"},
+		"responses": map[string]interface{}{
+			"200": map[string]interface{}{
+				"description": "OpenAPI 3 document",
+				"content":     map[string]interface{}{"application/json": map[string]interface{}{"schema": map[string]interface{}{"type": "object"}}},
+			},
+		},
+	})
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":       "Fold API",
+			"version":     "1.0.0",
+			"description": "Users, hashtags and projects, synced to Elasticsearch. Unversioned routes are deprecated in favour of their /v1 successors.",
+		},
+		"paths":      paths,
+		"components": map[string]interface{}{"schemas": b.components},
+	}
+}
+
+func (b *schemaBuilder) operation(op operation, legacy bool) map[string]interface{} {
+	path := op.path
+	id := operationID(op.method, op.path)
+	if legacy {
+		var method string
+		method, path, _ = strings.Cut(op.legacy, " ")
+		id = operationID(method, path)
+		id = "legacy" + strings.ToUpper(id[:1]) + id[1:]
+	}
+
+	var parameters []interface{}
+	for _, match := range pathParamPattern.FindAllStringSubmatch(path, -1) {
+		parameters = append(parameters, map[string]interface{}{
+			"name":     match[1],
+			"in":       "path",
+			"required": true,
+			"schema":   pathParamSchema(match[1]),
+		})
+	}
+	for _, param := range op.query {
+		parameters = append(parameters, map[string]interface{}{
+			"name":        param.name,
+			"in":          "query",
+			"description": param.description,
+			"required":    param.required,
+			"schema":      param.schema,
+		})
+	}
+	if op.method != http.MethodGet {
+		parameters = append(parameters,
+			map[string]interface{}{
+				"name":        "Idempotency-Key",
+				"in":          "header",
+				"description": "Replays the stored response when the same request is retried with this key",
+				"schema":      map[string]interface{}{"type": "string", "maxLength": 255},
+			},
+			map[string]interface{}{
+				"name":        "X-Actor",
+				"in":          "header",
+				"description": "Acting user recorded in the audit history",
+				"schema":      map[string]interface{}{"type": "string"},
+			},
+		)
+	}
+
+	responses := map[string]interface{}{
+		strconv.Itoa(op.status): b.response(http.StatusText(op.status), op.result, op.resultTypes),
+	}
+	for _, resp := range op.errors {
+		if resp.status == http.StatusMovedPermanently {
+			responses["301"] = map[string]interface{}{"description": "Slug was renamed, redirects to the current slug"}
+			continue
+		}
+		body := resp.body
+		if body == nil {
+			body = defaultErrorBody(resp.status)
+		}
+		responses[strconv.Itoa(resp.status)] = b.response(http.StatusText(resp.status), body, nil)
+	}
+	if op.method != http.MethodGet {
+		if _, ok := responses["409"]; !ok {
+			responses["409"] = b.response("A request with the same Idempotency-Key is still in progress", errorResponse{}, nil)
+		}
+		if _, ok := responses["422"]; !ok {
+			responses["422"] = b.response("Idempotency-Key was already used with a different request", errorResponse{}, nil)
+		}
+	}
+
+	operation := map[string]interface{}{
+		"operationId": id,
+		"summary":     op.summary,
+		"tags":        []string{op.tag},
+		"responses":   responses,
+	}
+	if len(parameters) > 0 {
+		operation["parameters"] = parameters
+	}
+	if legacy {
+		operation["deprecated"] = true
+	}
+
+	switch {
+	case len(op.bodyTypes) > 0:
+		content := map[string]interface{}{}
+		for _, contentType := range op.bodyTypes {
+			content[contentType] = map[string]interface{}{"schema": map[string]interface{}{"type": "string"}}
+		}
+		operation["requestBody"] = map[string]interface{}{"required": true, "content": content}
+	case op.body != nil:
+		operation["requestBody"] = map[string]interface{}{
+			"required": !op.optionalBody,
+			"content":  map[string]interface{}{"application/json": map[string]interface{}{"schema": b.schemaOf(op.body)}},
+		}
+	}
+	return operation
+}
+
+func (b *schemaBuilder) response(description string, body interface{}, contentTypes []string) map[string]interface{} {
+	resp := map[string]interface{}{"description": description}
+	switch {
+	case len(contentTypes) > 0:
+		content := map[string]interface{}{}
+		for _, contentType := range contentTypes {
+			content[contentType] = map[string]interface{}{"schema": map[string]interface{}{"type": "string"}}
+		}
+		resp["content"] = content
+	case body != nil:
+		resp["content"] = map[string]interface{}{"application/json": map[string]interface{}{"schema": b.schemaOf(body)}}
+	}
+	return resp
+}
+
+func defaultErrorBody(status int) interface{} {
+	if status == http.StatusBadRequest {
+		return validationErrorResponse{}
+	}
+	return errorResponse{}
+}
+
+func pathParamSchema(name string) map[string]interface{} {
+	switch name {
+	case "slug":
+		return map[string]interface{}{"type": "string", "pattern": slugPattern}
+	case "entity":
+		return enum(entityEnum...)
+	default:
+		return map[string]interface{}{"type": "integer", "minimum": 1}
+	}
+}
+
+// operationID derives an ID like getUsersIdProjects from the method and path.
+func operationID(method, path string) string {
+	id := strings.ToLower(method)
+	for _, segment := range strings.Split(path, "/") {
+		segment = strings.Trim(segment, "{}")
+		for _, word := range strings.Split(segment, "-") {
+			if word != "" {
+				id += strings.ToUpper(word[:1]) + word[1:]
+			}
+		}
+	}
+	return id
+}
@@ -0,0 +1,34 @@
+package openapi_test
+
+import (
+	"encoding/json"
+	"fold/internal/openapi"
+	"fold/internal/routes"
+	"testing"
+)
+
+func TestSpecCoversRoutes(t *testing.T) {
+	err := openapi.Verify(routes.NewRouter())
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestJSON(t *testing.T) {
+	data, err := openapi.JSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var spec struct {
+		OpenAPI string                 `json:"openapi"`
+		Paths   map[string]interface{} `json:"paths"`
+	}
+	err = json.Unmarshal(data, &spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spec.OpenAPI == "" || len(spec.Paths) == 0 {
+		t.Fatalf("document has version %q and %d paths", spec.OpenAPI, len(spec.Paths))
+	}
+}
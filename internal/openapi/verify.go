@@ -0,0 +1,58 @@
+package openapi
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// Verify compares the routes registered on router with the paths of the specification and
+// reports every route that is missing from one side.
+func Verify(router *mux.Router) error {
+	routed := map[string]bool{}
+	err := router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		if err != nil {
+			return nil
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			// Subrouters and prefixes have no methods of their own
+			return nil
+		}
+		for _, method := range methods {
+			routed[method+" "+path] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	documented := map[string]bool{}
+	paths := Spec()["paths"].(map[string]map[string]interface{})
+	for path, operations := range paths {
+		for method := range operations {
+			documented[strings.ToUpper(method)+" "+path] = true
+		}
+	}
+
+	var problems []string
+	for route := range routed {
+		if !documented[route] {
+			problems = append(problems, "undocumented route "+route)
+		}
+	}
+	for route := range documented {
+		if !routed[route] {
+			problems = append(problems, "documented route not registered "+route)
+		}
+	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("openapi: routes and specification diverge:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
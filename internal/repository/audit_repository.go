@@ -0,0 +1,184 @@
+package repository
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"fold/internal/database"
+	"fold/internal/models"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	AuditEntityUser    = "user"
+	AuditEntityHashtag = "hashtag"
+	AuditEntityProject = "project"
+)
+
+// SystemAudit is used for changes made by background jobs.
+var SystemAudit = models.AuditInfo{Actor: "system"}
+
+type userSnapshot struct {
+	models.User
+	ProjectIds []int `json:"project_ids"`
+}
+
+type hashtagSnapshot struct {
+	models.Hashtag
+	ProjectIds []int `json:"project_ids"`
+}
+
+// GetAuditSnapshot loads an entity with its links as stored, including soft-deleted rows.
+// It returns nil when the entity does not exist.
+func GetAuditSnapshot(tx *sql.Tx, entityType string, entityId int) (interface{}, error) {
+	var err error
+	var snapshot interface{}
+
+	switch entityType {
+	case AuditEntityUser:
+		var user userSnapshot
+		err = scanUser(tx.QueryRow("SELECT "+userColumns+" FROM users u WHERE u.id = $1", entityId), &user.User)
+		if err == nil {
+			user.ProjectIds, err = getLinkedIds(tx, "SELECT project_id FROM user_projects WHERE user_id = $1 ORDER BY project_id", entityId)
+		}
+		snapshot = user
+	case AuditEntityHashtag:
+		var hashtag hashtagSnapshot
+		err = scanHashtag(tx.QueryRow("SELECT "+hashtagColumns+" FROM hashtags h LEFT JOIN hashtag_aliases a ON a.hashtag_id = h.id WHERE h.id = $1 GROUP BY h.id", entityId), &hashtag.Hashtag)
+		if err == nil {
+			hashtag.ProjectIds, err = getLinkedIds(tx, "SELECT project_id FROM project_hashtags WHERE hashtag_id = $1 ORDER BY project_id", entityId)
+		}
+		snapshot = hashtag
+	case AuditEntityProject:
+		var project models.Project
+		err = scanProject(tx.QueryRow("SELECT "+projectColumns+" FROM projects p WHERE p.id = $1", entityId), &project)
+		if err == nil {
+			project.UserIds, err = getLinkedIds(tx, "SELECT user_id FROM user_projects WHERE project_id = $1 ORDER BY user_id", entityId)
+		}
+		if err == nil {
+			project.UserRoles, err = getProjectUserRoles(tx, entityId, true)
+		}
+		if err == nil {
+			project.HashtagIds, err = getLinkedIds(tx, "SELECT hashtag_id FROM project_hashtags WHERE project_id = $1 ORDER BY hashtag_id", entityId)
+		}
+		snapshot = project
+	default:
+		return nil, fmt.Errorf("unknown audit entity type %q", entityType)
+	}
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+func getLinkedIds(tx *sql.Tx, query string, entityId int) ([]int, error) {
+	ids := []int{}
+	rows, err := tx.Query(query, entityId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int
+		err := rows.Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
+
+// RecordAuditChange stores an audit event with the given before snapshot and the current
+// state of the entity, as seen by the transaction, as after snapshot.
+func RecordAuditChange(tx *sql.Tx, info models.AuditInfo, entityType string, entityId int, action string, before interface{}) error {
+	after, err := GetAuditSnapshot(tx, entityType, entityId)
+	if err != nil {
+		return err
+	}
+
+	beforeJSON, err := marshalSnapshot(before)
+	if err != nil {
+		return err
+	}
+
+	afterJSON, err := marshalSnapshot(after)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(
+		"INSERT INTO audit_events (entity_type, entity_id, action, actor, request_id, before, after) VALUES ($1, $2, $3, $4, $5, $6, $7)",
+		entityType, entityId, action, info.Actor, info.RequestID, beforeJSON, afterJSON)
+	return err
+}
+
+func marshalSnapshot(snapshot interface{}) (interface{}, error) {
+	if snapshot == nil {
+		return nil, nil
+	}
+
+	snapshotJSON, err := json.Marshal(snapshot)
+	if err != nil {
+		return nil, err
+	}
+	return string(snapshotJSON), nil
+}
+
+// GetAuditEvents returns the newest audit events of an entity type, optionally for one entity.
+func GetAuditEvents(entityType string, entityId int, limit int) ([]models.AuditEvent, error) {
+	events := []models.AuditEvent{}
+
+	rows, err := database.DB.Query(
+		`SELECT id, entity_type, entity_id, action, COALESCE(actor, ''), COALESCE(request_id, ''), before, after, created_at
+		FROM audit_events WHERE entity_type = $1 AND ($2 = 0 OR entity_id = $2)
+		ORDER BY id DESC LIMIT $3`,
+		entityType, entityId, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var event models.AuditEvent
+		var before, after []byte
+		err := rows.Scan(&event.ID, &event.EntityType, &event.EntityID, &event.Action, &event.Actor, &event.RequestID, &before, &after, &event.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		event.Before = nullableJSON(before)
+		event.After = nullableJSON(after)
+		events = append(events, event)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+func nullableJSON(data []byte) json.RawMessage {
+	if data == nil {
+		return json.RawMessage("null")
+	}
+	return json.RawMessage(data)
+}
+
+// RecordPurgeAuditEvents stores a purge event with the row as before snapshot for every row of
+// the table that was soft-deleted before cutoff.
+func RecordPurgeAuditEvents(tx *sql.Tx, entityType string, table string, cutoff time.Time) error {
+	_, err := tx.Exec(
+		fmt.Sprintf(`INSERT INTO audit_events (entity_type, entity_id, action, actor, request_id, before, after)
+		SELECT $1, t.id, 'purge', $2, '', row_to_json(t), NULL FROM %s t WHERE t.deleted_at < $3`, pq.QuoteIdentifier(table)),
+		entityType, SystemAudit.Actor, cutoff)
+	return err
+}
@@ -0,0 +1,68 @@
+package repository
+
+import (
+	"encoding/json"
+	"fold/internal/models"
+	"testing"
+)
+
+func TestAuditEvents(t *testing.T) {
+	capture := setupDB(t)
+
+	userId := createTestUser(t, "Ada")
+	projectId := createTestProject(t, capture, "Compiler", []int{userId}, nil)
+	err := UpdateUserTransaction(testInfo, &models.User{ID: userId, Name: "Ada Lovelace"})
+	if err == nil {
+		err = DeleteUserTransaction(testInfo, userId)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	capture.take()
+
+	// Events are listed newest first with the actor and request of the change
+	events, err := GetAuditEvents(AuditEntityUser, userId, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var actions []string
+	for _, event := range events {
+		actions = append(actions, event.Action)
+		if event.EntityID != userId || event.Actor != testInfo.Actor || event.RequestID != testInfo.RequestID {
+			t.Fatalf("unexpected event %+v", event)
+		}
+	}
+	expectStrings(t, "actions", actions, "delete", "update", "create")
+
+	// Snapshots hold the row and its links before and after the change
+	var before, after userSnapshot
+	if string(events[2].Before) != "null" {
+		t.Fatalf("create has before snapshot %s", events[2].Before)
+	}
+	err = json.Unmarshal(events[1].Before, &before)
+	if err == nil {
+		err = json.Unmarshal(events[1].After, &after)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if before.Name != "Ada" || after.Name != "Ada Lovelace" || len(after.ProjectIds) != 1 || after.ProjectIds[0] != projectId {
+		t.Fatalf("update snapshots before %+v, after %+v", before, after)
+	}
+	err = json.Unmarshal(events[0].After, &after)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.DeletedAt == nil {
+		t.Fatal("delete snapshot has no deleted_at")
+	}
+
+	// The limit keeps the newest events
+	events, err = GetAuditEvents(AuditEntityUser, 0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Action != "delete" {
+		t.Fatalf("limited events %+v, want the delete", events)
+	}
+}
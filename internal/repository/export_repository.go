@@ -0,0 +1,156 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"fold/internal/database"
+	"fold/internal/models"
+
+	"github.com/lib/pq"
+)
+
+// exportBatchSize is the number of rows fetched from an export cursor at a time.
+const exportBatchSize = 500
+
+// StreamUsers passes every user to fn in ID order without loading them all into memory.
+func StreamUsers(ctx context.Context, includeDeleted bool, fn func(*models.User) error) error {
+	query := "SELECT " + userColumns + " FROM users u WHERE " + deletedFilter("u.deleted_at", includeDeleted) + " ORDER BY u.id"
+	return streamCursor(ctx, query, func(rows *sql.Rows) error {
+		var user models.User
+		err := scanUser(rows, &user)
+		if err != nil {
+			return err
+		}
+		return fn(&user)
+	}, nil)
+}
+
+// StreamHashtags passes every hashtag with its aliases to fn in ID order.
+func StreamHashtags(ctx context.Context, includeDeleted bool, fn func(*models.Hashtag) error) error {
+	query := "SELECT " + hashtagColumns + " FROM hashtags h LEFT JOIN hashtag_aliases a ON a.hashtag_id = h.id WHERE " +
+		deletedFilter("h.deleted_at", includeDeleted) + " GROUP BY h.id ORDER BY h.id"
+	return streamCursor(ctx, query, func(rows *sql.Rows) error {
+		var hashtag models.Hashtag
+		err := scanHashtag(rows, &hashtag)
+		if err != nil {
+			return err
+		}
+		return fn(&hashtag)
+	}, nil)
+}
+
+// StreamProjects passes every project with its user IDs, roles and hashtag IDs to fn in ID order.
+func StreamProjects(ctx context.Context, includeDeleted bool, fn func(*models.Project) error) error {
+	query := "SELECT " + projectColumns + `,
+		ARRAY(SELECT up.user_id FROM user_projects up JOIN users u ON u.id = up.user_id
+			WHERE up.project_id = p.id AND u.deleted_at IS NULL ORDER BY up.user_id),
+		(SELECT json_object_agg(up.user_id, up.role) FROM user_projects up JOIN users u ON u.id = up.user_id
+			WHERE up.project_id = p.id AND u.deleted_at IS NULL),
+		ARRAY(SELECT ph.hashtag_id FROM project_hashtags ph JOIN hashtags h ON h.id = ph.hashtag_id
+			WHERE ph.project_id = p.id AND h.deleted_at IS NULL ORDER BY ph.hashtag_id)
+		FROM projects p WHERE ` + deletedFilter("p.deleted_at", includeDeleted) + " ORDER BY p.id"
+	return streamCursor(ctx, query, func(rows *sql.Rows) error {
+		var project models.Project
+		var userIds, hashtagIds pq.Int64Array
+		var roles []byte
+		err := rows.Scan(&project.ID, &project.Name, &project.Slug, &project.Description, &project.CreatedAt, &project.UpdatedAt, &project.DeletedAt,
+			&userIds, &roles, &hashtagIds)
+		if err != nil {
+			return err
+		}
+
+		project.UserIds = intsOf(userIds)
+		project.HashtagIds = intsOf(hashtagIds)
+		if roles != nil {
+			err = json.Unmarshal(roles, &project.UserRoles)
+			if err != nil {
+				return err
+			}
+		}
+		return fn(&project)
+	}, nil)
+}
+
+// StreamProjectDocs passes the denormalized document of every project that is not deleted to fn
+// in ID order, exactly as it is sent to the search index.
+func StreamProjectDocs(ctx context.Context, fn func(*models.DenormalizedProject) error) error {
+	var projectIds []int
+	return streamCursor(ctx, "SELECT p.id FROM projects p WHERE p.deleted_at IS NULL ORDER BY p.id", func(rows *sql.Rows) error {
+		var id int
+		err := rows.Scan(&id)
+		projectIds = append(projectIds, id)
+		return err
+	}, func(tx *sql.Tx) error {
+		// Denormalize the projects of the batch once the cursor rows are read.
+		for _, projectId := range projectIds {
+			doc, err := GetProjectDoc(tx, projectId)
+			if err != nil {
+				return err
+			}
+			err = fn(&doc)
+			if err != nil {
+				return err
+			}
+		}
+		projectIds = projectIds[:0]
+		return nil
+	})
+}
+
+// streamCursor runs query through a server-side cursor in a read-only snapshot transaction,
+// fetching exportBatchSize rows at a time and calling scanRow for each of them. endBatch, when
+// set, runs after every batch and may run further queries on the transaction.
+func streamCursor(ctx context.Context, query string, scanRow func(*sql.Rows) error, endBatch func(*sql.Tx) error) error {
+	tx, err := database.DB.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, "DECLARE export_cursor NO SCROLL CURSOR FOR "+query)
+	if err != nil {
+		return err
+	}
+
+	for {
+		rows, err := tx.QueryContext(ctx, fmt.Sprintf("FETCH %d FROM export_cursor", exportBatchSize))
+		if err != nil {
+			return err
+		}
+
+		fetched := 0
+		for rows.Next() {
+			fetched++
+			err = scanRow(rows)
+			if err != nil {
+				rows.Close()
+				return err
+			}
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			return err
+		}
+
+		if endBatch != nil {
+			err = endBatch(tx)
+			if err != nil {
+				return err
+			}
+		}
+
+		if fetched < exportBatchSize {
+			return nil
+		}
+	}
+}
+
+func intsOf(values pq.Int64Array) []int {
+	ints := make([]int, len(values))
+	for i, value := range values {
+		ints[i] = int(value)
+	}
+	return ints
+}
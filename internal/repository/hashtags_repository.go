@@ -2,26 +2,80 @@ package repository
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"fold/internal/database"
+	"fold/internal/events"
 	"fold/internal/models"
-	"time"
+	"fold/internal/normalize"
+
+	"github.com/lib/pq"
 )
 
-func CreateHashtag(hashtag *models.Hashtag) error {
-	_, err := database.DB.Exec("INSERT INTO hashtags (name, created_at) VALUES ($1, $2)", hashtag.Name, time.Now())
-	return err
+// hashtagColumns selects a hashtag with its aliases. Queries using it must join
+// hashtag_aliases as a and group by h.id.
+const hashtagColumns = "h.id, h.name, h.created_at, h.updated_at, h.deleted_at, COALESCE(array_agg(a.alias ORDER BY a.alias) FILTER (WHERE a.alias IS NOT NULL), '{}')"
+
+// hashtagNamesLock is the advisory lock CheckHashtagNames holds until the end of the transaction.
+// Names and aliases share one namespace that no index covers, so checking and writing them is serialized.
+const hashtagNamesLock = 0x68617368
+
+var ErrMergeIntoSelf = errors.New("cannot merge a hashtag into itself")
+
+// HashtagConflictError lists names that are already used by another hashtag or alias.
+type HashtagConflictError struct {
+	Names []string
+}
+
+func (e *HashtagConflictError) Error() string {
+	return fmt.Sprintf("hashtag names already in use: %v", e.Names)
+}
+
+func scanHashtag(row rowScanner, hashtag *models.Hashtag) error {
+	return row.Scan(&hashtag.ID, &hashtag.Name, &hashtag.CreatedAt, &hashtag.UpdatedAt, &hashtag.DeletedAt, (*pq.StringArray)(&hashtag.Aliases))
+}
+
+// NormalizeHashtag brings the name and aliases of a hashtag into their canonical form.
+// Aliases that equal the name are dropped. Nil aliases stay nil so updates can keep the existing ones.
+func NormalizeHashtag(hashtag *models.Hashtag) {
+	hashtag.Name = normalize.Hashtag(hashtag.Name)
+	if hashtag.Aliases == nil {
+		return
+	}
+
+	aliases := make([]string, 0, len(hashtag.Aliases))
+	for _, alias := range normalize.Hashtags(hashtag.Aliases) {
+		if alias != hashtag.Name {
+			aliases = append(aliases, alias)
+		}
+	}
+	hashtag.Aliases = aliases
+}
+
+func CreateHashtag(tx *sql.Tx, hashtag *models.Hashtag) (int, error) {
+	err := tx.QueryRow("INSERT INTO hashtags (name) VALUES ($1) RETURNING id, created_at, updated_at", hashtag.Name).Scan(&hashtag.ID, &hashtag.CreatedAt, &hashtag.UpdatedAt)
+	if isUniqueViolation(err, "hashtags_name_key") {
+		// The unique index backs up CheckHashtagNames
+		return 0, &HashtagConflictError{Names: []string{hashtag.Name}}
+	}
+	return hashtag.ID, err
 }
 
 func GetHashtagById(hashtagID int, hashtag *models.Hashtag) error {
-	err := database.DB.QueryRow("SELECT * FROM hashtags WHERE id = $1", hashtagID).Scan(&hashtag.ID, &hashtag.Name, &hashtag.CreatedAt)
-	return err
+	row := database.DB.QueryRow("SELECT "+hashtagColumns+" FROM hashtags h LEFT JOIN hashtag_aliases a ON a.hashtag_id = h.id WHERE h.id = $1 AND h.deleted_at IS NULL GROUP BY h.id", hashtagID)
+	return scanHashtag(row, hashtag)
 }
 
-func GetAllHashtags() ([]models.Hashtag, error) {
+// GetHashtagForTransaction loads a hashtag with its aliases, including a soft-deleted one.
+func GetHashtagForTransaction(tx *sql.Tx, hashtagId int, hashtag *models.Hashtag) error {
+	row := tx.QueryRow("SELECT "+hashtagColumns+" FROM hashtags h LEFT JOIN hashtag_aliases a ON a.hashtag_id = h.id WHERE h.id = $1 GROUP BY h.id", hashtagId)
+	return scanHashtag(row, hashtag)
+}
+
+func GetAllHashtags(includeDeleted bool) ([]models.Hashtag, error) {
 	var hashtags []models.Hashtag
 
-	rows, err := database.DB.Query("SELECT id, name, created_at FROM hashtags")
+	rows, err := database.DB.Query("SELECT " + hashtagColumns + " FROM hashtags h LEFT JOIN hashtag_aliases a ON a.hashtag_id = h.id WHERE " + deletedFilter("h.deleted_at", includeDeleted) + " GROUP BY h.id ORDER BY h.id")
 	if err != nil {
 		return nil, err
 	}
@@ -29,7 +83,7 @@ func GetAllHashtags() ([]models.Hashtag, error) {
 
 	for rows.Next() {
 		var hashtag models.Hashtag
-		err := rows.Scan(&hashtag.ID, &hashtag.Name, &hashtag.CreatedAt)
+		err := scanHashtag(rows, &hashtag)
 		if err != nil {
 			return nil, err
 		}
@@ -43,8 +97,74 @@ func GetAllHashtags() ([]models.Hashtag, error) {
 	return hashtags, nil
 }
 
+// CheckHashtagNames returns a HashtagConflictError when the name or an alias of the hashtag
+// is already used as the name or alias of another live hashtag. It locks the hashtag names for
+// the rest of the transaction so concurrent writes cannot take the same names.
+func CheckHashtagNames(tx *sql.Tx, hashtag *models.Hashtag) error {
+	_, err := tx.Exec("SELECT pg_advisory_xact_lock($1)", hashtagNamesLock)
+	if err != nil {
+		return err
+	}
+
+	names := append([]string{hashtag.Name}, hashtag.Aliases...)
+	rows, err := tx.Query(
+		`SELECT name FROM hashtags WHERE id <> $2 AND deleted_at IS NULL AND name = ANY($1)
+		UNION
+		SELECT a.alias FROM hashtag_aliases a JOIN hashtags h ON h.id = a.hashtag_id
+		WHERE a.hashtag_id <> $2 AND h.deleted_at IS NULL AND a.alias = ANY($1)
+		ORDER BY 1`,
+		pq.Array(names), hashtag.ID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var conflict HashtagConflictError
+	for rows.Next() {
+		var name string
+		err := rows.Scan(&name)
+		if err != nil {
+			return err
+		}
+		conflict.Names = append(conflict.Names, name)
+	}
+
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	if len(conflict.Names) > 0 {
+		return &conflict
+	}
+	return nil
+}
+
+// ReplaceHashtagAliases sets the aliases of a hashtag to exactly the given list.
+func ReplaceHashtagAliases(tx *sql.Tx, hashtagId int, aliases []string) error {
+	err := DeleteHashtagAliases(tx, hashtagId)
+	if err != nil {
+		return err
+	}
+
+	for _, alias := range aliases {
+		_, err = tx.Exec("INSERT INTO hashtag_aliases (alias, hashtag_id) VALUES ($1, $2)", alias, hashtagId)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func DeleteHashtagAliases(tx *sql.Tx, hashtagId int) error {
+	_, err := tx.Exec("DELETE FROM hashtag_aliases WHERE hashtag_id = $1", hashtagId)
+	return err
+}
+
 func UpdateHashtag(tx *sql.Tx, hashtag *models.Hashtag) error {
-	_, err := database.DB.Exec("UPDATE hashtags SET name = $1 WHERE id = $2", hashtag.Name, hashtag.ID)
+	err := requireRowsAffected(tx.Exec("UPDATE hashtags SET name = $1, updated_at = now() WHERE id = $2 AND deleted_at IS NULL", hashtag.Name, hashtag.ID))
+	if isUniqueViolation(err, "hashtags_name_key") {
+		return &HashtagConflictError{Names: []string{hashtag.Name}}
+	}
 	return err
 }
 
@@ -53,9 +173,29 @@ func DeleteHashtag(tx *sql.Tx, hashtagId int) error {
 	return err
 }
 
+// TouchHashtag marks a hashtag as changed when only its links or aliases were modified.
+func TouchHashtag(tx *sql.Tx, hashtagId int) error {
+	_, err := tx.Exec("UPDATE hashtags SET updated_at = now() WHERE id = $1", hashtagId)
+	return err
+}
+
+func SoftDeleteHashtag(tx *sql.Tx, hashtagId int) error {
+	return requireRowsAffected(tx.Exec("UPDATE hashtags SET deleted_at = now(), updated_at = now() WHERE id = $1 AND deleted_at IS NULL", hashtagId))
+}
+
+func RestoreHashtag(tx *sql.Tx, hashtagId int) error {
+	err := requireRowsAffected(tx.Exec("UPDATE hashtags SET deleted_at = NULL, updated_at = now() WHERE id = $1 AND deleted_at IS NOT NULL", hashtagId))
+	if isUniqueViolation(err, "hashtags_name_key") {
+		var name string
+		tx.QueryRow("SELECT name FROM hashtags WHERE id = $1", hashtagId).Scan(&name)
+		return &HashtagConflictError{Names: []string{name}}
+	}
+	return err
+}
+
 func HashtagExists(hashtagId int) bool {
 	var exists bool
-	err := database.DB.QueryRow("SELECT EXISTS(SELECT 1 FROM hashtags WHERE id = $1)", hashtagId).Scan(&exists)
+	err := database.DB.QueryRow("SELECT EXISTS(SELECT 1 FROM hashtags WHERE id = $1 AND deleted_at IS NULL)", hashtagId).Scan(&exists)
 	if err != nil {
 		fmt.Println(err)
 		return false
@@ -64,7 +204,7 @@ func HashtagExists(hashtagId int) bool {
 }
 
 func GetHashtagProjectIds(tx *sql.Tx, hashtagId int, projectIds *[]int) error {
-	rows, err := tx.Query("SELECT project_id FROM project_hashtags WHERE hashtag_id = $1", hashtagId)
+	rows, err := tx.Query("SELECT DISTINCT ph.project_id FROM project_hashtags ph JOIN projects p ON p.id = ph.project_id WHERE ph.hashtag_id = $1 AND p.deleted_at IS NULL ORDER BY ph.project_id", hashtagId)
 	if err != nil {
 		return err
 	}
@@ -87,20 +227,93 @@ func DeleteHashtagProjectIds(tx *sql.Tx, hashtagId int) error {
 	return err
 }
 
-func UpdateHashtagTransaction(hashtag *models.Hashtag) error {
+func CreateHashtagTransaction(info models.AuditInfo, hashtag *models.Hashtag) error {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return err
+	}
+
+	// Insert hashtag with its aliases into the database
+	err = applyHashtagCreate(tx, info, hashtag)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// applyHashtagCreate normalizes and inserts a hashtag with its aliases and records it in the audit log.
+func applyHashtagCreate(tx *sql.Tx, info models.AuditInfo, hashtag *models.Hashtag) error {
+	NormalizeHashtag(hashtag)
+
+	// Check that the name and aliases are not used by another hashtag.
+	err := CheckHashtagNames(tx, hashtag)
+	if err != nil {
+		return err
+	}
+
+	// Insert hashtag into the database
+	hashtag.ID, err = CreateHashtag(tx, hashtag)
+	if err != nil {
+		return err
+	}
+
+	// Create entries in hashtag_aliases.
+	err = ReplaceHashtagAliases(tx, hashtag.ID, hashtag.Aliases)
+	if err != nil {
+		return err
+	}
+
+	// Record the change in the audit log.
+	return RecordAuditChange(tx, info, AuditEntityHashtag, hashtag.ID, "create", nil)
+}
+
+func UpdateHashtagTransaction(info models.AuditInfo, hashtag *models.Hashtag) error {
+	NormalizeHashtag(hashtag)
 
 	tx, err := database.DB.Begin()
 	if err != nil {
 		return err
 	}
 
-	//Update user in database
+	// Snapshot the hashtag before the change for the audit log.
+	before, err := GetAuditSnapshot(tx, AuditEntityHashtag, hashtag.ID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	// Check that the name and aliases are not used by another hashtag.
+	err = CheckHashtagNames(tx, hashtag)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	//Update hashtag in database
 	err = UpdateHashtag(tx, hashtag)
 	if err != nil {
 		tx.Rollback()
 		return err
 	}
 
+	// Replace aliases when they are part of the update.
+	if hashtag.Aliases != nil {
+		err = ReplaceHashtagAliases(tx, hashtag.ID, hashtag.Aliases)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	// Record the change in the audit log.
+	err = RecordAuditChange(tx, info, AuditEntityHashtag, hashtag.ID, "update", before)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
 	//Get list of projectIds that need to be changed.
 	var projectIds []int
 	err = GetHashtagProjectIds(tx, hashtag.ID, &projectIds)
@@ -109,9 +322,22 @@ func UpdateHashtagTransaction(hashtag *models.Hashtag) error {
 		return err
 	}
 
+	// Describe the rename once instead of resending every project when consumers support it.
+	if renameEventsEnabled() {
+		if len(projectIds) > 0 {
+			err = syncHashtagRename(tx, info, hashtag.ID)
+			if err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+		return tx.Commit()
+	}
+
 	//Sync Elastic Search for every project edited.
+	cause := fanOutCause(info, AuditEntityHashtag, hashtag.ID, "update", len(projectIds))
 	for _, projectId := range projectIds {
-		err = SyncElasticsearch(tx, projectId, "POST")
+		err = SyncElasticsearch(tx, projectId, models.EventProjectUpserted, cause)
 		if err != nil {
 			tx.Rollback()
 			return err
@@ -121,13 +347,34 @@ func UpdateHashtagTransaction(hashtag *models.Hashtag) error {
 	return tx.Commit()
 }
 
-func DeleteHashtagTransaction(hashtagId int) error {
+func DeleteHashtagTransaction(info models.AuditInfo, hashtagId int) error {
 
 	tx, err := database.DB.Begin()
 	if err != nil {
 		return err
 	}
 
+	// Snapshot the hashtag before the change for the audit log.
+	before, err := GetAuditSnapshot(tx, AuditEntityHashtag, hashtagId)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	//Soft delete hashtag in database
+	err = SoftDeleteHashtag(tx, hashtagId)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	// Record the change in the audit log.
+	err = RecordAuditChange(tx, info, AuditEntityHashtag, hashtagId, "delete", before)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
 	//Get list of projectIds that need to be changed.
 	var projectIds []int
 	err = GetHashtagProjectIds(tx, hashtagId, &projectIds)
@@ -136,28 +383,268 @@ func DeleteHashtagTransaction(hashtagId int) error {
 		return err
 	}
 
-	//Delete rows from project_hashtags
-	err = DeleteHashtagProjectIds(tx, hashtagId)
+	//Sync Elastic Search for every project the hashtag is hidden from.
+	cause := fanOutCause(info, AuditEntityHashtag, hashtagId, "delete", len(projectIds))
+	for _, projectId := range projectIds {
+		err = SyncElasticsearch(tx, projectId, models.EventProjectUpserted, cause)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func RestoreHashtagTransaction(info models.AuditInfo, hashtagId int) error {
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return err
+	}
+
+	// Snapshot the hashtag before the change for the audit log.
+	before, err := GetAuditSnapshot(tx, AuditEntityHashtag, hashtagId)
 	if err != nil {
 		tx.Rollback()
 		return err
 	}
 
-	//Sync Elastic Search for every project deleted.
+	// Check that the name and aliases were not taken while the hashtag was deleted.
+	var hashtag models.Hashtag
+	err = GetHashtagForTransaction(tx, hashtagId, &hashtag)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	err = CheckHashtagNames(tx, &hashtag)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	//Restore hashtag in database
+	err = RestoreHashtag(tx, hashtagId)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	// Record the change in the audit log.
+	err = RecordAuditChange(tx, info, AuditEntityHashtag, hashtagId, "restore", before)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	//Get list of projectIds that need to be changed.
+	var projectIds []int
+	err = GetHashtagProjectIds(tx, hashtagId, &projectIds)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	//Sync Elastic Search for every project the hashtag is back in.
+	cause := fanOutCause(info, AuditEntityHashtag, hashtagId, "restore", len(projectIds))
 	for _, projectId := range projectIds {
-		err = SyncElasticsearch(tx, projectId, "POST")
+		err = SyncElasticsearch(tx, projectId, models.EventProjectUpserted, cause)
 		if err != nil {
 			tx.Rollback()
 			return err
 		}
 	}
 
-	//Update user in database
-	err = DeleteUser(tx, hashtagId)
+	return tx.Commit()
+}
+
+// LockHashtagNames locks the live hashtags with the given distinct IDs and returns their names by ID.
+// The rows are locked in ID order, so transactions locking the same hashtags cannot deadlock.
+// It returns sql.ErrNoRows when one of the hashtags does not exist.
+func LockHashtagNames(tx *sql.Tx, hashtagIds ...int) (map[int]string, error) {
+	rows, err := tx.Query("SELECT id, name FROM hashtags WHERE id = ANY($1) AND deleted_at IS NULL ORDER BY id FOR UPDATE", pq.Array(hashtagIds))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	names := make(map[int]string)
+	for rows.Next() {
+		var id int
+		var name string
+		err := rows.Scan(&id, &name)
+		if err != nil {
+			return nil, err
+		}
+		names[id] = name
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(names) < len(hashtagIds) {
+		return nil, sql.ErrNoRows
+	}
+	return names, nil
+}
+
+// MoveHashtagProjects links every project of the source hashtag to the target hashtag,
+// skipping projects that already have the target, and removes the source links.
+func MoveHashtagProjects(tx *sql.Tx, sourceId int, targetId int) error {
+	_, err := tx.Exec(
+		`INSERT INTO project_hashtags (hashtag_id, project_id)
+		SELECT DISTINCT $2::int, s.project_id FROM project_hashtags s
+		WHERE s.hashtag_id = $1 AND NOT EXISTS (
+			SELECT 1 FROM project_hashtags t WHERE t.hashtag_id = $2 AND t.project_id = s.project_id)`,
+		sourceId, targetId)
+	if err != nil {
+		return err
+	}
+
+	return DeleteHashtagProjectIds(tx, sourceId)
+}
+
+func MoveHashtagAliases(tx *sql.Tx, sourceId int, targetId int) error {
+	_, err := tx.Exec("UPDATE hashtag_aliases SET hashtag_id = $2 WHERE hashtag_id = $1", sourceId, targetId)
+	return err
+}
+
+func CreateHashtagAlias(tx *sql.Tx, hashtagId int, alias string) error {
+	_, err := tx.Exec("INSERT INTO hashtag_aliases (alias, hashtag_id) VALUES ($1, $2)", alias, hashtagId)
+	return err
+}
+
+// MergeHashtagsTransaction folds the source hashtag into the target hashtag and returns the IDs
+// of the projects that were re-synced. The source hashtag is deleted; with keepAsAlias its name
+// and aliases become aliases of the target, otherwise they are dropped.
+func MergeHashtagsTransaction(info models.AuditInfo, sourceId int, merge *models.HashtagMerge) ([]int, error) {
+	if sourceId == merge.TargetID {
+		return nil, ErrMergeIntoSelf
+	}
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	// Lock both hashtags so they cannot change while merging.
+	names, err := LockHashtagNames(tx, sourceId, merge.TargetID)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	sourceName := names[sourceId]
+
+	// Snapshot both hashtags before the change for the audit log.
+	sourceBefore, err := GetAuditSnapshot(tx, AuditEntityHashtag, sourceId)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	targetBefore, err := GetAuditSnapshot(tx, AuditEntityHashtag, merge.TargetID)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	//Get list of projectIds that need to be changed.
+	var projectIds []int
+	err = GetHashtagProjectIds(tx, sourceId, &projectIds)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	//Move rows in project_hashtags to the target hashtag.
+	err = MoveHashtagProjects(tx, sourceId, merge.TargetID)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	//Move or drop the aliases of the source hashtag.
+	if merge.KeepAsAlias {
+		err = MoveHashtagAliases(tx, sourceId, merge.TargetID)
+	} else {
+		err = DeleteHashtagAliases(tx, sourceId)
+	}
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	//Delete source hashtag from database
+	err = DeleteHashtag(tx, sourceId)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	//Keep the source name as an alias of the target.
+	if merge.KeepAsAlias {
+		err = CreateHashtagAlias(tx, merge.TargetID, sourceName)
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+
+	//Mark the target and every relinked project as changed.
+	err = TouchHashtag(tx, merge.TargetID)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	for _, projectId := range projectIds {
+		err = TouchProject(tx, projectId)
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+
+	// Record the change of both hashtags in the audit log.
+	err = RecordAuditChange(tx, info, AuditEntityHashtag, sourceId, "merge", sourceBefore)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	err = RecordAuditChange(tx, info, AuditEntityHashtag, merge.TargetID, "merge", targetBefore)
 	if err != nil {
 		tx.Rollback()
+		return nil, err
+	}
+
+	//Sync Elastic Search for every project moved to the target.
+	cause := fanOutCause(info, AuditEntityHashtag, sourceId, "merge", len(projectIds))
+	for _, projectId := range projectIds {
+		err = SyncElasticsearch(tx, projectId, models.EventProjectUpserted, cause)
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+
+	return projectIds, tx.Commit()
+}
+
+// syncHashtagRename sends a single hashtag.renamed event that consumers apply to every project of the hashtag.
+func syncHashtagRename(tx *sql.Tx, info models.AuditInfo, hashtagId int) error {
+	var hashtag models.Hashtag
+	err := GetHashtagForTransaction(tx, hashtagId, &hashtag)
+	if err != nil {
 		return err
 	}
 
-	return tx.Commit()
+	revision, err := nextRevision(tx, "hashtags", hashtagId)
+	if err != nil {
+		return err
+	}
+
+	return sendSyncEvent(events.HashtagRenamed(hashtag, revision, eventCause(info, AuditEntityHashtag, hashtagId, "update")))
 }
@@ -0,0 +1,296 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"fold/internal/database"
+	"fold/internal/models"
+	"testing"
+
+	"github.com/lib/pq"
+)
+
+func hashtagAliases(t *testing.T, hashtagId int) []string {
+	t.Helper()
+	var aliases []string
+	err := database.DB.QueryRow("SELECT COALESCE(array_agg(alias ORDER BY alias), '{}') FROM hashtag_aliases WHERE hashtag_id = $1", hashtagId).Scan((*pq.StringArray)(&aliases))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return aliases
+}
+
+func TestHashtagTransactions(t *testing.T) {
+	capture := setupDB(t)
+
+	// Create normalizes the name and aliases without syncing anything
+	hashtagId := createTestHashtag(t, "#Go", "Golang", "#go")
+	capture.expectNoEvents(t)
+	if name := stringColumn(t, "SELECT name FROM hashtags WHERE id = $1", hashtagId); name != "go" {
+		t.Fatalf("stored name %q, want go", name)
+	}
+	expectStrings(t, "aliases", hashtagAliases(t, hashtagId), "golang")
+
+	// Names and aliases of other hashtags are rejected
+	err := CreateHashtagTransaction(testInfo, &models.Hashtag{Name: "GOLANG"})
+	var conflict *HashtagConflictError
+	if !errors.As(err, &conflict) {
+		t.Fatalf("got error %v, want a HashtagConflictError", err)
+	}
+
+	ownerId := createTestUser(t, "Ada")
+	projectId := createTestProject(t, capture, "Compiler", []int{ownerId}, []int{hashtagId})
+
+	// Update syncs every project of the hashtag and keeps the aliases when none are given
+	err = UpdateHashtagTransaction(testInfo, &models.Hashtag{ID: hashtagId, Name: "Gopher"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := capture.takeEvents(t, models.EventProjectUpserted, projectId)
+	expectStrings(t, "hashtags", hashtagNames(events[0].Doc), "gopher")
+	if cause := events[0].Cause; cause.EntityType != AuditEntityHashtag || cause.Action != "update" || cause.FanOut != 1 {
+		t.Fatalf("unexpected cause %+v", cause)
+	}
+	expectStrings(t, "aliases", events[0].Doc.Hashtags[0].Aliases, "golang")
+
+	// Delete hides the hashtag from its projects but keeps the links and aliases
+	err = DeleteHashtagTransaction(testInfo, hashtagId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isDeleted(t, "hashtags", hashtagId) {
+		t.Fatal("hashtag is not soft-deleted")
+	}
+	expectStrings(t, "aliases", hashtagAliases(t, hashtagId), "golang")
+	if n := countRows(t, "SELECT count(*) FROM project_hashtags WHERE hashtag_id = $1", hashtagId); n != 1 {
+		t.Fatalf("hashtag has %d project links after delete, want 1", n)
+	}
+	events = capture.takeEvents(t, models.EventProjectUpserted, projectId)
+	expectStrings(t, "hashtags", hashtagNames(events[0].Doc))
+
+	err = DeleteHashtagTransaction(testInfo, hashtagId)
+	expectError(t, err, sql.ErrNoRows)
+	capture.expectNoEvents(t)
+
+	// Restore brings the hashtag back into its projects
+	err = RestoreHashtagTransaction(testInfo, hashtagId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if isDeleted(t, "hashtags", hashtagId) {
+		t.Fatal("hashtag is still soft-deleted")
+	}
+	events = capture.takeEvents(t, models.EventProjectUpserted, projectId)
+	expectStrings(t, "hashtags", hashtagNames(events[0].Doc), "gopher")
+}
+
+func TestDeletedHashtagNamesCanBeReused(t *testing.T) {
+	capture := setupDB(t)
+
+	hashtagId := createTestHashtag(t, "go", "golang")
+	err := DeleteHashtagTransaction(testInfo, hashtagId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	capture.take()
+
+	// The name and alias of the deleted hashtag are free again, as name and as alias
+	reusedId := createTestHashtag(t, "golang", "go")
+	expectStrings(t, "aliases", hashtagAliases(t, reusedId), "go")
+
+	// Restoring the deleted hashtag would use the names twice
+	err = RestoreHashtagTransaction(testInfo, hashtagId)
+	var conflict *HashtagConflictError
+	if !errors.As(err, &conflict) {
+		t.Fatalf("got error %v, want a HashtagConflictError", err)
+	}
+	expectStrings(t, "conflicting names", conflict.Names, "go", "golang")
+	if !isDeleted(t, "hashtags", hashtagId) {
+		t.Fatal("conflicting hashtag was restored")
+	}
+
+	// Once the names are free again, the hashtag can be restored
+	err = DeleteHashtagTransaction(testInfo, reusedId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = RestoreHashtagTransaction(testInfo, hashtagId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectStrings(t, "aliases", hashtagAliases(t, hashtagId), "golang")
+}
+
+func TestDeleteHashtagTransactionKeepsUserWithSameId(t *testing.T) {
+	capture := setupDB(t)
+
+	userId := createTestUser(t, "Ada")
+	hashtagId := createTestHashtag(t, "go")
+	if userId != hashtagId {
+		t.Fatalf("user %d and hashtag %d should share their ID for this test", userId, hashtagId)
+	}
+	projectId := createTestProject(t, capture, "Compiler", []int{userId}, []int{hashtagId})
+
+	err := DeleteHashtagTransaction(testInfo, hashtagId)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The hashtag row is soft-deleted, the user with the same ID is untouched
+	if !isDeleted(t, "hashtags", hashtagId) {
+		t.Fatal("hashtag is not soft-deleted")
+	}
+	if isDeleted(t, "users", userId) {
+		t.Fatal("user with the ID of the hashtag was deleted")
+	}
+	if name := stringColumn(t, "SELECT name FROM users WHERE id = $1", userId); name != "Ada" {
+		t.Fatalf("user name changed to %q", name)
+	}
+	if n := countRows(t, "SELECT count(*) FROM user_projects WHERE user_id = $1", userId); n != 1 {
+		t.Fatalf("user has %d project links, want 1", n)
+	}
+
+	events := capture.takeEvents(t, models.EventProjectUpserted, projectId)
+	expectStrings(t, "users", userNames(events[0].Doc), "Ada:owner")
+	expectStrings(t, "hashtags", hashtagNames(events[0].Doc))
+}
+
+func TestMergeHashtagsTransaction(t *testing.T) {
+	capture := setupDB(t)
+
+	targetId := createTestHashtag(t, "go", "golang")
+	sourceId := createTestHashtag(t, "gopher", "gophers")
+	ownerId := createTestUser(t, "Ada")
+	sourceOnly := createTestProject(t, capture, "Compiler", []int{ownerId}, []int{sourceId})
+	both := createTestProject(t, capture, "Debugger", []int{ownerId}, []int{sourceId, targetId})
+	targetOnly := createTestProject(t, capture, "Linker", []int{ownerId}, []int{targetId})
+
+	_, err := MergeHashtagsTransaction(testInfo, sourceId, &models.HashtagMerge{TargetID: sourceId})
+	expectError(t, err, ErrMergeIntoSelf)
+
+	projectIds, err := MergeHashtagsTransaction(testInfo, sourceId, &models.HashtagMerge{TargetID: targetId, KeepAsAlias: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(projectIds) != 2 {
+		t.Fatalf("merge re-synced projects %v, want %d and %d", projectIds, sourceOnly, both)
+	}
+
+	// The source is gone, its names are aliases of the target and its projects moved over
+	if rowExists(t, "hashtags", sourceId) {
+		t.Fatal("source hashtag still exists")
+	}
+	expectStrings(t, "aliases", hashtagAliases(t, targetId), "golang", "gopher", "gophers")
+	if n := countRows(t, "SELECT count(*) FROM project_hashtags WHERE hashtag_id = $1", sourceId); n != 0 {
+		t.Fatalf("source hashtag still has %d project links", n)
+	}
+	for _, projectId := range []int{sourceOnly, both, targetOnly} {
+		if n := countRows(t, "SELECT count(*) FROM project_hashtags WHERE project_id = $1 AND hashtag_id = $2", projectId, targetId); n != 1 {
+			t.Fatalf("project %d has %d links to the target, want 1", projectId, n)
+		}
+	}
+
+	capture.sortByProject()
+	events := capture.takeEvents(t, models.EventProjectUpserted, sourceOnly, both)
+	for _, event := range events {
+		expectStrings(t, "hashtags", hashtagNames(event.Doc), "go")
+		if event.Cause.Action != "merge" || event.Cause.FanOut != 2 {
+			t.Fatalf("unexpected cause %+v", event.Cause)
+		}
+	}
+}
+
+func TestMergeHashtagsTransactionInOppositeDirections(t *testing.T) {
+	capture := setupDB(t)
+
+	for i := 0; i < 20; i++ {
+		first := createTestHashtag(t, fmt.Sprintf("first%d", i))
+		second := createTestHashtag(t, fmt.Sprintf("second%d", i))
+
+		// Both merges lock the same rows in the same order, so one waits for the other and
+		// then finds its source or target gone instead of deadlocking
+		errs := make(chan error, 2)
+		for _, ids := range [][2]int{{first, second}, {second, first}} {
+			go func(sourceId int, targetId int) {
+				_, err := MergeHashtagsTransaction(testInfo, sourceId, &models.HashtagMerge{TargetID: targetId})
+				errs <- err
+			}(ids[0], ids[1])
+		}
+
+		var merged, missing int
+		for j := 0; j < 2; j++ {
+			err := <-errs
+			switch {
+			case err == nil:
+				merged++
+			case errors.Is(err, sql.ErrNoRows):
+				missing++
+			default:
+				t.Fatalf("merge %d failed: %v", i, err)
+			}
+		}
+		if merged != 1 || missing != 1 {
+			t.Fatalf("merge %d: %d merges succeeded and %d found a hashtag missing, want 1 and 1", i, merged, missing)
+		}
+	}
+	capture.expectNoEvents(t)
+}
+
+func TestIsDeadlock(t *testing.T) {
+	if !IsDeadlock(fmt.Errorf("merge: %w", &pq.Error{Code: "40P01"})) {
+		t.Fatal("deadlock error is not reported as a deadlock")
+	}
+	if IsDeadlock(&pq.Error{Code: "23505"}) || IsDeadlock(sql.ErrNoRows) {
+		t.Fatal("other errors are reported as deadlocks")
+	}
+}
+
+func TestUpdateHashtagTransactionSyncsNewName(t *testing.T) {
+	capture := setupDB(t)
+
+	hashtagId := createTestHashtag(t, "go")
+	ownerId := createTestUser(t, "Ada")
+	projectId := createTestProject(t, capture, "Compiler", []int{ownerId}, []int{hashtagId})
+
+	// The published document is built inside the transaction and has the new name
+	err := UpdateHashtagTransaction(testInfo, &models.Hashtag{ID: hashtagId, Name: "golang", Aliases: []string{"go"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := capture.takeEvents(t, models.EventProjectUpserted, projectId)
+	expectStrings(t, "hashtags", hashtagNames(events[0].Doc), "golang")
+	expectStrings(t, "aliases", events[0].Doc.Hashtags[0].Aliases, "go")
+
+	// A failing sync rolls the rename and the new aliases back
+	capture.fail = errors.New("queue unavailable")
+	err = UpdateHashtagTransaction(testInfo, &models.Hashtag{ID: hashtagId, Name: "gopher", Aliases: []string{}})
+	expectError(t, err, capture.fail)
+	if name := stringColumn(t, "SELECT name FROM hashtags WHERE id = $1", hashtagId); name != "golang" {
+		t.Fatalf("name %q was stored although the sync failed", name)
+	}
+	expectStrings(t, "aliases", hashtagAliases(t, hashtagId), "go")
+}
+
+func TestUpdateHashtagTransactionSendsRenameEvent(t *testing.T) {
+	capture := setupDB(t)
+	t.Setenv("SYNC_RENAME_EVENTS", "true")
+
+	hashtagId := createTestHashtag(t, "go")
+	ownerId := createTestUser(t, "Ada")
+	createTestProject(t, capture, "Compiler", []int{ownerId}, []int{hashtagId})
+
+	err := UpdateHashtagTransaction(testInfo, &models.Hashtag{ID: hashtagId, Name: "golang", Aliases: []string{"go"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := capture.take()
+	if len(events) != 1 || events[0].EventType != models.EventHashtagRenamed {
+		t.Fatalf("got %d sync events, want one %s", len(events), models.EventHashtagRenamed)
+	}
+	hashtag := events[0].Hashtag
+	if hashtag == nil || hashtag.ID != hashtagId || hashtag.Name != "golang" || events[0].Doc != nil {
+		t.Fatalf("unexpected rename event %+v", events[0])
+	}
+	expectStrings(t, "aliases", hashtag.Aliases, "go")
+}
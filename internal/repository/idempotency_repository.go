@@ -0,0 +1,101 @@
+package repository
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fold/internal/database"
+	"fold/internal/models"
+	"time"
+)
+
+var (
+	ErrIdempotencyKeyReused     = errors.New("idempotency key was already used with a different request")
+	ErrIdempotencyKeyInProgress = errors.New("a request with this idempotency key is still in progress")
+	ErrIdempotencyClaimLost     = errors.New("idempotency key claim expired and was taken over by a retry")
+)
+
+// ClaimIdempotencyKey reserves the key of actor for the request identified by requestHash until ttl passes.
+// Keys are scoped by actor, so different actors can use the same key.
+// It returns the time of the claim when the key is new or expired, so the request should be
+// processed, and the stored response when the same request already completed. A claim without a
+// response is held for lease; after that a retry of the same request takes it over, so a request
+// that died while holding the key does not block its retries until the key expires.
+func ClaimIdempotencyKey(actor string, key string, requestHash string, ttl time.Duration, lease time.Duration) (time.Time, *models.IdempotentResponse, error) {
+	// Forget the key when its stored response expired.
+	_, err := database.DB.Exec("DELETE FROM idempotency_keys WHERE actor = $1 AND key = $2 AND expires_at < now()", actor, key)
+	if err != nil {
+		return time.Time{}, nil, err
+	}
+
+	// Claim a new key or take over an abandoned claim of the same request.
+	var claimedAt time.Time
+	err = database.DB.QueryRow(
+		`INSERT INTO idempotency_keys (actor, key, request_hash, claimed_at, expires_at) VALUES ($1, $2, $3, now(), now() + make_interval(secs => $4))
+		ON CONFLICT (actor, key) DO UPDATE SET claimed_at = EXCLUDED.claimed_at, expires_at = EXCLUDED.expires_at
+		WHERE idempotency_keys.status_code IS NULL AND idempotency_keys.request_hash = EXCLUDED.request_hash
+			AND idempotency_keys.claimed_at < now() - make_interval(secs => $5)
+		RETURNING claimed_at`,
+		actor, key, requestHash, int64(ttl.Seconds()), lease.Seconds()).Scan(&claimedAt)
+	if err == nil {
+		return claimedAt, nil, nil
+	}
+	if err != sql.ErrNoRows {
+		return time.Time{}, nil, err
+	}
+
+	// The key is taken, check whether it belongs to the same request.
+	var storedHash string
+	var statusCode *int
+	var headers []byte
+	var response models.IdempotentResponse
+	err = database.DB.QueryRow("SELECT request_hash, status_code, response_headers, response_body FROM idempotency_keys WHERE actor = $1 AND key = $2", actor, key).
+		Scan(&storedHash, &statusCode, &headers, &response.Body)
+	if err != nil {
+		return time.Time{}, nil, err
+	}
+	if storedHash != requestHash {
+		return time.Time{}, nil, ErrIdempotencyKeyReused
+	}
+	if statusCode == nil {
+		return time.Time{}, nil, ErrIdempotencyKeyInProgress
+	}
+
+	response.StatusCode = *statusCode
+	err = json.Unmarshal(headers, &response.Headers)
+	if err != nil {
+		return time.Time{}, nil, err
+	}
+	return time.Time{}, &response, nil
+}
+
+// SaveIdempotentResponse stores the response of the request that claimed the key of actor at claimedAt.
+// It fails with ErrIdempotencyClaimLost when a retry took the claim over in the meantime.
+func SaveIdempotentResponse(actor string, key string, claimedAt time.Time, response *models.IdempotentResponse) error {
+	headers, err := json.Marshal(response.Headers)
+	if err != nil {
+		return err
+	}
+
+	err = requireRowsAffected(database.DB.Exec("UPDATE idempotency_keys SET status_code = $1, response_headers = $2, response_body = $3 WHERE actor = $4 AND key = $5 AND claimed_at = $6",
+		response.StatusCode, headers, response.Body, actor, key, claimedAt))
+	if err == sql.ErrNoRows {
+		return ErrIdempotencyClaimLost
+	}
+	return err
+}
+
+// ReleaseIdempotencyKey forgets the key of actor so the request can be retried, used when it did not succeed.
+// A claim taken over by a retry is left alone.
+func ReleaseIdempotencyKey(actor string, key string, claimedAt time.Time) error {
+	_, err := database.DB.Exec("DELETE FROM idempotency_keys WHERE actor = $1 AND key = $2 AND claimed_at = $3 AND status_code IS NULL", actor, key, claimedAt)
+	return err
+}
+
+func DeleteExpiredIdempotencyKeys() (int64, error) {
+	result, err := database.DB.Exec("DELETE FROM idempotency_keys WHERE expires_at < now()")
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
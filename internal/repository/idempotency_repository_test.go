@@ -0,0 +1,71 @@
+package repository
+
+import (
+	"fold/internal/models"
+	"testing"
+	"time"
+)
+
+func TestClaimIdempotencyKey(t *testing.T) {
+	setupDB(t)
+
+	// The first request claims the key, a retry while it runs is told so
+	claimedAt, stored, err := ClaimIdempotencyKey("ada", "key-1", "hash-1", time.Hour, time.Hour)
+	if err != nil || stored != nil || claimedAt.IsZero() {
+		t.Fatalf("first claim: claimed at %v, stored %v, error %v", claimedAt, stored, err)
+	}
+	_, _, err = ClaimIdempotencyKey("ada", "key-1", "hash-1", time.Hour, time.Hour)
+	expectError(t, err, ErrIdempotencyKeyInProgress)
+	_, _, err = ClaimIdempotencyKey("ada", "key-1", "hash-2", time.Hour, time.Hour)
+	expectError(t, err, ErrIdempotencyKeyReused)
+
+	// Keys are scoped by actor, another actor can use the same key for its own request
+	otherAt, stored, err := ClaimIdempotencyKey("grace", "key-1", "hash-2", time.Hour, time.Hour)
+	if err != nil || stored != nil || otherAt.IsZero() {
+		t.Fatalf("claim of another actor: claimed at %v, stored %v, error %v", otherAt, stored, err)
+	}
+
+	// A retry after the response was stored gets it replayed
+	response := &models.IdempotentResponse{StatusCode: 201, Headers: map[string]string{"Location": "/v1/users/1"}, Body: []byte(`{"id":1}`)}
+	err = SaveIdempotentResponse("ada", "key-1", claimedAt, response)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, stored, err = ClaimIdempotencyKey("ada", "key-1", "hash-1", time.Hour, time.Hour)
+	if err != nil || stored == nil {
+		t.Fatalf("replay: stored %v, error %v", stored, err)
+	}
+	if stored.StatusCode != 201 || stored.Headers["Location"] != "/v1/users/1" || string(stored.Body) != `{"id":1}` {
+		t.Fatalf("replayed %+v", stored)
+	}
+
+	// An abandoned claim is taken over by a retry of the same request once its lease ran out
+	abandonedAt, _, err := ClaimIdempotencyKey("ada", "key-2", "hash-1", time.Hour, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = ClaimIdempotencyKey("ada", "key-2", "hash-2", time.Hour, 0)
+	expectError(t, err, ErrIdempotencyKeyReused)
+	retriedAt, stored, err := ClaimIdempotencyKey("ada", "key-2", "hash-1", time.Hour, 0)
+	if err != nil || stored != nil || !retriedAt.After(abandonedAt) {
+		t.Fatalf("takeover: claimed at %v after %v, stored %v, error %v", retriedAt, abandonedAt, stored, err)
+	}
+
+	// The request that lost its claim can neither store its response nor release the key
+	err = SaveIdempotentResponse("ada", "key-2", abandonedAt, response)
+	expectError(t, err, ErrIdempotencyClaimLost)
+	err = ReleaseIdempotencyKey("ada", "key-2", abandonedAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := countRows(t, "SELECT count(*) FROM idempotency_keys WHERE actor = 'ada' AND key = 'key-2'"); n != 1 {
+		t.Fatal("lost claim released the key of the retry")
+	}
+	err = ReleaseIdempotencyKey("ada", "key-2", retriedAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := countRows(t, "SELECT count(*) FROM idempotency_keys WHERE actor = 'ada' AND key = 'key-2'"); n != 0 {
+		t.Fatal("key was not released")
+	}
+}
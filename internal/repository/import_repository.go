@@ -0,0 +1,182 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+	"fold/internal/database"
+	"fold/internal/models"
+	"fold/internal/normalize"
+	"fold/internal/validation"
+)
+
+// Entity collections that can be imported and exported.
+const (
+	EntityUsers    = "users"
+	EntityHashtags = "hashtags"
+	EntityProjects = "projects"
+)
+
+// ImportTransaction creates the users, hashtags or projects of records in a single transaction.
+// Every record runs in its own savepoint, so a failing record is skipped and its error returned
+// at its index of the error slice; records that already carry an error are skipped as well.
+// Projects may reference users and hashtags created earlier in the same import. With dryRun set
+// the transaction is rolled back at the end, otherwise every imported project is synced once,
+// in batches, before the commit.
+func ImportTransaction(info models.AuditInfo, entity string, records []models.ImportRecord, dryRun bool) ([]int, []error, error) {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ids := make([]int, len(records))
+	errs := make([]error, len(records))
+	var projectIds []int
+
+	for i, record := range records {
+		if record.Err != nil {
+			errs[i] = record.Err
+			continue
+		}
+
+		_, err = tx.Exec("SAVEPOINT import_record")
+		if err != nil {
+			tx.Rollback()
+			return nil, nil, err
+		}
+
+		ids[i], errs[i] = importRecord(tx, info, entity, record)
+		if errs[i] != nil {
+			// Undo what the record changed before it failed.
+			_, err = tx.Exec("ROLLBACK TO SAVEPOINT import_record")
+		} else {
+			_, err = tx.Exec("RELEASE SAVEPOINT import_record")
+		}
+		if err != nil {
+			tx.Rollback()
+			return nil, nil, err
+		}
+
+		if errs[i] == nil && entity == EntityProjects {
+			projectIds = append(projectIds, ids[i])
+		}
+	}
+
+	if dryRun {
+		return ids, errs, tx.Rollback()
+	}
+
+	// Sync ElasticSearch for every imported project in batches.
+	var syncEvents []*models.SyncEvent
+	for _, projectId := range projectIds {
+		event, err := projectEvent(tx, projectId, models.EventProjectUpserted, eventCause(info, AuditEntityProject, projectId, "import"))
+		if err != nil {
+			tx.Rollback()
+			return nil, nil, err
+		}
+		syncEvents = append(syncEvents, event)
+	}
+
+	err = sendSyncEvents(syncEvents)
+	if err != nil {
+		tx.Rollback()
+		return nil, nil, err
+	}
+
+	return ids, errs, tx.Commit()
+}
+
+func importRecord(tx *sql.Tx, info models.AuditInfo, entity string, record models.ImportRecord) (int, error) {
+	switch entity {
+	case EntityUsers:
+		err := applyUserCreate(tx, info, record.User)
+		return record.User.ID, err
+	case EntityHashtags:
+		err := applyHashtagCreate(tx, info, record.Hashtag)
+		return record.Hashtag.ID, err
+	case EntityProjects:
+		project := record.Project
+
+		// Resolve users and hashtags given by name to their IDs.
+		userIds, err := resolveImportReferences(tx, record.UserRefs, "user",
+			"SELECT id FROM users WHERE name = $1 AND deleted_at IS NULL", func(name string) string { return name })
+		if err != nil {
+			return 0, err
+		}
+		hashtagIds, err := resolveImportReferences(tx, record.HashtagRefs, "hashtag",
+			`SELECT id FROM hashtags WHERE name = $1 AND deleted_at IS NULL
+			UNION SELECT a.hashtag_id FROM hashtag_aliases a JOIN hashtags h ON h.id = a.hashtag_id WHERE a.alias = $1 AND h.deleted_at IS NULL`,
+			normalize.Hashtag)
+		if err != nil {
+			return 0, err
+		}
+		project.UserIds = appendUnique(project.UserIds, userIds...)
+		project.HashtagIds = appendUnique(project.HashtagIds, hashtagIds...)
+
+		// Check the number of users and hashtags now that they are known.
+		err = validation.Validate(project)
+		if err != nil {
+			return 0, err
+		}
+
+		// Check that all users and hashtags exist.
+		err = ValidateProjectReferences(tx, project)
+		if err != nil {
+			return 0, err
+		}
+
+		err = applyProjectCreate(tx, info, project)
+		return project.ID, err
+	default:
+		return 0, fmt.Errorf("unknown import entity %q", entity)
+	}
+}
+
+// resolveImportReferences returns the IDs of refs, looking up the ones given by name with query
+// after normalizing the name. A name must match exactly one row.
+func resolveImportReferences(tx *sql.Tx, refs []models.ImportReference, kind string, query string, normalizeName func(string) string) ([]int, error) {
+	var ids []int
+	for _, ref := range refs {
+		if ref.Name == "" {
+			ids = append(ids, ref.ID)
+			continue
+		}
+
+		rows, err := tx.Query(query, normalizeName(ref.Name))
+		if err != nil {
+			return nil, err
+		}
+		var matches []int
+		for rows.Next() {
+			var id int
+			err = rows.Scan(&id)
+			if err != nil {
+				rows.Close()
+				return nil, err
+			}
+			matches = append(matches, id)
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			return nil, err
+		}
+
+		switch len(matches) {
+		case 0:
+			return nil, fmt.Errorf("%s %q does not exist", kind, ref.Name)
+		case 1:
+			ids = append(ids, matches[0])
+		default:
+			return nil, fmt.Errorf("%s name %q is ambiguous, it matches %ss %v", kind, ref.Name, kind, matches)
+		}
+	}
+	return ids, nil
+}
+
+func appendUnique(ids []int, more ...int) []int {
+	for _, id := range more {
+		if !containsInt(ids, id) {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
@@ -0,0 +1,82 @@
+package repository
+
+import (
+	"errors"
+	"fold/internal/models"
+	"testing"
+)
+
+func TestImportTransaction(t *testing.T) {
+	capture := setupDB(t)
+
+	// Records with a parse error are skipped, the others are created without syncing anything
+	parseErr := errors.New("line 2: invalid JSON")
+	ids, errs, err := ImportTransaction(testInfo, EntityUsers, []models.ImportRecord{
+		{Line: 1, User: &models.User{Name: "Ada"}},
+		{Line: 2, Err: parseErr},
+		{Line: 3, User: &models.User{Name: "Grace"}},
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectError(t, errs[1], parseErr)
+	if errs[0] != nil || errs[2] != nil || ids[0] == 0 || ids[2] == 0 {
+		t.Fatalf("users not imported: ids %v, errors %v", ids, errs)
+	}
+	adaId := ids[0]
+	capture.expectNoEvents(t)
+
+	// A hashtag clashing with an earlier record fails on its own
+	ids, errs, err = ImportTransaction(testInfo, EntityHashtags, []models.ImportRecord{
+		{Line: 1, Hashtag: &models.Hashtag{Name: "go", Aliases: []string{"golang"}}},
+		{Line: 2, Hashtag: &models.Hashtag{Name: "Golang"}},
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var conflict *HashtagConflictError
+	if errs[0] != nil || !errors.As(errs[1], &conflict) {
+		t.Fatalf("got errors %v, want a conflict of the second hashtag", errs)
+	}
+	hashtagId := ids[0]
+	if n := countRows(t, "SELECT count(*) FROM hashtags"); n != 1 {
+		t.Fatalf("%d hashtags stored, want 1", n)
+	}
+
+	// Projects resolve users by name and hashtags by name or alias
+	ids, errs, err = ImportTransaction(testInfo, EntityProjects, []models.ImportRecord{
+		{Line: 1, Project: &models.Project{Name: "Compiler"},
+			UserRefs: []models.ImportReference{{Name: "Ada"}}, HashtagRefs: []models.ImportReference{{Name: "#GoLang"}}},
+		{Line: 2, Project: &models.Project{Name: "Debugger"},
+			UserRefs: []models.ImportReference{{Name: "Nobody"}}},
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if errs[0] != nil || errs[1] == nil {
+		t.Fatalf("got errors %v, want only the second project to fail", errs)
+	}
+	if n := countRows(t, "SELECT count(*) FROM user_projects WHERE project_id = $1 AND user_id = $2", ids[0], adaId); n != 1 {
+		t.Fatal("imported project is not linked to its user")
+	}
+	if n := countRows(t, "SELECT count(*) FROM project_hashtags WHERE project_id = $1 AND hashtag_id = $2", ids[0], hashtagId); n != 1 {
+		t.Fatal("imported project is not linked to the hashtag of the alias")
+	}
+	events := capture.takeEvents(t, models.EventProjectUpserted, ids[0])
+	expectStrings(t, "users", userNames(events[0].Doc), "Ada:owner")
+	if events[0].Cause.Action != "import" {
+		t.Fatalf("unexpected cause %+v", events[0].Cause)
+	}
+
+	// A dry run reports the IDs but stores and syncs nothing
+	ids, errs, err = ImportTransaction(testInfo, EntityProjects, []models.ImportRecord{
+		{Line: 1, Project: &models.Project{Name: "Linker"}, UserRefs: []models.ImportReference{{ID: adaId}}},
+	}, true)
+	if err != nil || errs[0] != nil || ids[0] == 0 {
+		t.Fatalf("dry run: ids %v, errors %v, error %v", ids, errs, err)
+	}
+	if n := countRows(t, "SELECT count(*) FROM projects WHERE name = 'Linker'"); n != 0 {
+		t.Fatal("dry run stored the project")
+	}
+	capture.expectNoEvents(t)
+}
@@ -0,0 +1,197 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+	"fold/internal/database"
+	"fold/internal/models"
+)
+
+// BulkOperationError identifies the operation that made an atomic bulk request roll back.
+type BulkOperationError struct {
+	Index int
+	Err   error
+}
+
+func (e *BulkOperationError) Error() string {
+	return fmt.Sprintf("bulk operation %d: %v", e.Index, e.Err)
+}
+
+func (e *BulkOperationError) Unwrap() error {
+	return e.Err
+}
+
+// ProjectBulkTransaction applies project operations in a single transaction. The users and
+// hashtags of all operations are validated in one query and the affected projects are synced
+// once each, in batches, before the commit.
+//
+// Every operation runs in its own savepoint: a failing operation is undone and its error is
+// returned at its index of the error slice while the others are applied. With atomic set, the
+// first failure rolls back the whole transaction and is returned as a *BulkOperationError.
+func ProjectBulkTransaction(info models.AuditInfo, ops []models.BulkProjectOperation, atomic bool) ([]error, error) {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	// Check the users and hashtags of every operation in a single query.
+	missing, err := validateBulkReferences(tx, ops)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	errs := make([]error, len(ops))
+	var syncOrder []int
+	syncTypes := make(map[int]string)
+	syncCauses := make(map[int]*models.EventCause)
+	deletedDocs := make(map[int]models.DenormalizedProject)
+
+	for i, op := range ops {
+		_, err = tx.Exec("SAVEPOINT bulk_operation")
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+
+		projectId, deletedDoc, opErr := applyBulkOperation(tx, info, op, missing)
+		if opErr != nil {
+			if atomic {
+				tx.Rollback()
+				return nil, &BulkOperationError{Index: i, Err: opErr}
+			}
+			errs[i] = opErr
+
+			// Undo what the operation changed before it failed.
+			_, err = tx.Exec("ROLLBACK TO SAVEPOINT bulk_operation")
+			if err != nil {
+				tx.Rollback()
+				return nil, err
+			}
+			continue
+		}
+
+		_, err = tx.Exec("RELEASE SAVEPOINT bulk_operation")
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+
+		// Remember the last change of every project to sync it once.
+		if _, seen := syncTypes[projectId]; !seen {
+			syncOrder = append(syncOrder, projectId)
+		}
+		if deletedDoc != nil {
+			syncTypes[projectId] = models.EventProjectDeleted
+			deletedDocs[projectId] = *deletedDoc
+		} else {
+			syncTypes[projectId] = models.EventProjectUpserted
+		}
+		syncCauses[projectId] = eventCause(info, AuditEntityProject, projectId, op.Op)
+	}
+
+	// Sync ElasticSearch for every affected project in batches.
+	var syncEvents []*models.SyncEvent
+	for _, projectId := range syncOrder {
+		var event *models.SyncEvent
+		if syncTypes[projectId] == models.EventProjectDeleted {
+			event, err = projectEventOf(tx, deletedDocs[projectId], models.EventProjectDeleted, syncCauses[projectId])
+		} else {
+			event, err = projectEvent(tx, projectId, models.EventProjectUpserted, syncCauses[projectId])
+		}
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		syncEvents = append(syncEvents, event)
+	}
+
+	err = sendSyncEvents(syncEvents)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	return errs, tx.Commit()
+}
+
+// applyBulkOperation applies one bulk operation and returns the ID of the affected project.
+// Deletes also return the document of the project as it was before the delete.
+func applyBulkOperation(tx *sql.Tx, info models.AuditInfo, op models.BulkProjectOperation, missing *MissingReferencesError) (int, *models.DenormalizedProject, error) {
+	switch op.Op {
+	case "create":
+		err := missingReferencesOf(missing, op.Project)
+		if err != nil {
+			return 0, nil, err
+		}
+		err = applyProjectCreate(tx, info, op.Project)
+		return op.Project.ID, nil, err
+	case "update":
+		op.Project.ID = op.ID
+		err := missingReferencesOf(missing, op.Project)
+		if err != nil {
+			return 0, nil, err
+		}
+		return op.ID, nil, applyProjectUpdate(tx, info, op.Project, AllProjectLinks)
+	case "delete":
+		doc, err := GetProjectDoc(tx, op.ID)
+		if err != nil {
+			return 0, nil, err
+		}
+		return op.ID, &doc, applyProjectDelete(tx, info, op.ID)
+	default:
+		return 0, nil, fmt.Errorf("unknown bulk operation %q", op.Op)
+	}
+}
+
+// validateBulkReferences checks the users and hashtags of all operations together and returns
+// the ones that do not exist, or nil when all exist.
+func validateBulkReferences(tx *sql.Tx, ops []models.BulkProjectOperation) (*MissingReferencesError, error) {
+	var all models.Project
+	for _, op := range ops {
+		if op.Project != nil && op.Op != "delete" {
+			all.UserIds = append(all.UserIds, op.Project.UserIds...)
+			all.HashtagIds = append(all.HashtagIds, op.Project.HashtagIds...)
+		}
+	}
+
+	err := ValidateProjectReferences(tx, &all)
+	if missing, ok := err.(*MissingReferencesError); ok {
+		return missing, nil
+	}
+	return nil, err
+}
+
+// missingReferencesOf returns a *MissingReferencesError with the missing users and hashtags
+// of project, or nil when it references none of them.
+func missingReferencesOf(missing *MissingReferencesError, project *models.Project) error {
+	if missing == nil {
+		return nil
+	}
+
+	var projectMissing MissingReferencesError
+	for _, id := range project.UserIds {
+		if containsInt(missing.UserIds, id) {
+			projectMissing.UserIds = append(projectMissing.UserIds, id)
+		}
+	}
+	for _, id := range project.HashtagIds {
+		if containsInt(missing.HashtagIds, id) {
+			projectMissing.HashtagIds = append(projectMissing.HashtagIds, id)
+		}
+	}
+
+	if len(projectMissing.UserIds) > 0 || len(projectMissing.HashtagIds) > 0 {
+		return &projectMissing
+	}
+	return nil
+}
+
+func containsInt(values []int, value int) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,75 @@
+package repository
+
+import (
+	"errors"
+	"fold/internal/models"
+	"testing"
+)
+
+func TestProjectBulkTransaction(t *testing.T) {
+	capture := setupDB(t)
+
+	ownerId := createTestUser(t, "Ada")
+	hashtagId := createTestHashtag(t, "go")
+	updatedId := createTestProject(t, capture, "Compiler", []int{ownerId}, nil)
+	deletedId := createTestProject(t, capture, "Debugger", []int{ownerId}, nil)
+
+	// Without atomic, a failing operation is undone and the others are applied
+	ops := []models.BulkProjectOperation{
+		{Op: "create", Project: &models.Project{Name: "Linker", UserIds: []int{ownerId}, HashtagIds: []int{hashtagId}}},
+		{Op: "create", Project: &models.Project{Name: "Broken", UserIds: []int{ownerId}, HashtagIds: []int{999}}},
+		{Op: "update", ID: updatedId, Project: &models.Project{Name: "Compiler 2", UserIds: []int{ownerId}}},
+		{Op: "delete", ID: deletedId},
+	}
+	errs, err := ProjectBulkTransaction(testInfo, ops, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var missing *MissingReferencesError
+	if !errors.As(errs[1], &missing) || len(missing.HashtagIds) != 1 || missing.HashtagIds[0] != 999 {
+		t.Fatalf("operation 1 failed with %v, want missing hashtag 999", errs[1])
+	}
+	for _, i := range []int{0, 2, 3} {
+		if errs[i] != nil {
+			t.Fatalf("operation %d failed: %v", i, errs[i])
+		}
+	}
+	createdId := ops[0].Project.ID
+	if n := countRows(t, "SELECT count(*) FROM projects WHERE name = 'Broken'"); n != 0 {
+		t.Fatal("failed operation was not undone")
+	}
+	if !isDeleted(t, "projects", deletedId) {
+		t.Fatal("deleted project is not soft-deleted")
+	}
+
+	events := capture.take()
+	if len(events) != 3 {
+		t.Fatalf("got %d sync events, want 3", len(events))
+	}
+	capture.events = events[:2]
+	upserted := capture.takeEvents(t, models.EventProjectUpserted, createdId, updatedId)
+	expectStrings(t, "hashtags", hashtagNames(upserted[0].Doc), "go")
+	if upserted[1].Doc.Name != "Compiler 2" || upserted[1].Cause.Action != "update" {
+		t.Fatalf("unexpected update event %+v", upserted[1])
+	}
+	capture.events = events[2:]
+	deleted := capture.takeEvents(t, models.EventProjectDeleted, deletedId)
+	if deleted[0].Doc.Name != "Debugger" {
+		t.Fatalf("delete event has document %+v", deleted[0].Doc)
+	}
+
+	// With atomic, the first failure rolls back every operation
+	ops = []models.BulkProjectOperation{
+		{Op: "create", Project: &models.Project{Name: "Assembler", UserIds: []int{ownerId}}},
+		{Op: "delete", ID: deletedId},
+	}
+	_, err = ProjectBulkTransaction(testInfo, ops, true)
+	var opErr *BulkOperationError
+	if !errors.As(err, &opErr) || opErr.Index != 1 {
+		t.Fatalf("got error %v, want a failure of operation 1", err)
+	}
+	if n := countRows(t, "SELECT count(*) FROM projects WHERE name = 'Assembler'"); n != 0 {
+		t.Fatal("atomic bulk request was not rolled back")
+	}
+	capture.expectNoEvents(t)
+}
@@ -11,6 +11,25 @@ func CreateProjectHashtags(tx *sql.Tx, hashtagId int, projectId int) error {
 	return err
 }
 
+// AddProjectHashtag tags a project with a hashtag and reports whether the tag is new.
+func AddProjectHashtag(tx *sql.Tx, hashtagId int, projectId int) (bool, error) {
+	result, err := tx.Exec("INSERT INTO project_hashtags(hashtag_id, project_id) VALUES($1, $2) ON CONFLICT DO NOTHING", hashtagId, projectId)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
+
+// RemoveProjectHashtag removes a hashtag from a project, returning ErrLinkNotFound when it was not tagged.
+func RemoveProjectHashtag(tx *sql.Tx, hashtagId int, projectId int) error {
+	err := requireRowsAffected(tx.Exec("DELETE FROM project_hashtags WHERE hashtag_id = $1 AND project_id = $2", hashtagId, projectId))
+	if err == sql.ErrNoRows {
+		return ErrLinkNotFound
+	}
+	return err
+}
+
 func ExistsProjectHastags(hashtagId int, projectId int) bool {
 	var exists bool
 	err := database.DB.QueryRow("SELECT EXISTS(SELECT 1 FROM project_hashtags WHERE hashtag_id = $1 AND project_id = $2)", hashtagId, projectId).Scan(&exists)
@@ -21,7 +40,9 @@ func ExistsProjectHastags(hashtagId int, projectId int) bool {
 	return exists
 }
 
+// DeleteProjectHashtags removes the links of a project to hashtags that are not soft-deleted. Links to
+// soft-deleted hashtags stay so restoring the hashtag brings them back.
 func DeleteProjectHashtags(tx *sql.Tx, projectId int) error {
-	_, err := tx.Exec("DELETE FROM project_hashtags WHERE project_id = $1", projectId)
+	_, err := tx.Exec("DELETE FROM project_hashtags ph USING hashtags h WHERE h.id = ph.hashtag_id AND ph.project_id = $1 AND h.deleted_at IS NULL", projectId)
 	return err
 }
@@ -0,0 +1,142 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"fold/internal/database"
+	"fold/internal/models"
+	"fold/internal/slug"
+)
+
+var ErrSlugTaken = errors.New("slug is already in use by another project")
+
+// AssignProjectSlug makes sure project.Slug is set and unique. An empty slug is generated from
+// the project name and suffixed with -2, -3, ... on collision, while an explicit slug that
+// belongs to another project (currently or in its history) returns ErrSlugTaken.
+func AssignProjectSlug(tx *sql.Tx, project *models.Project) error {
+	if project.Slug != "" {
+		takenSlugs, err := GetTakenSlugs(tx, project.Slug, project.ID)
+		if err != nil {
+			return err
+		}
+		if takenSlugs[project.Slug] {
+			return ErrSlugTaken
+		}
+		return nil
+	}
+
+	base := slug.Make(project.Name)
+	takenSlugs, err := GetTakenSlugs(tx, base, project.ID)
+	if err != nil {
+		return err
+	}
+
+	candidate := base
+	for suffix := 2; takenSlugs[candidate]; suffix++ {
+		candidate = fmt.Sprintf("%s-%d", base, suffix)
+	}
+	project.Slug = candidate
+	return nil
+}
+
+// maxSlugAttempts bounds how often a generated slug is retried when concurrent writes keep taking it.
+const maxSlugAttempts = 5
+
+// writeWithProjectSlug assigns the project slug and runs write, which stores it, under a savepoint.
+// When a concurrent transaction committed the same slug first, a generated slug moves on to the
+// next free suffix, while an explicit one returns ErrSlugTaken.
+func writeWithProjectSlug(tx *sql.Tx, project *models.Project, write func() error) error {
+	explicit := project.Slug != ""
+	for attempt := 1; ; attempt++ {
+		err := AssignProjectSlug(tx, project)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec("SAVEPOINT project_slug")
+		if err != nil {
+			return err
+		}
+		err = write()
+		if err == nil {
+			_, err = tx.Exec("RELEASE SAVEPOINT project_slug")
+			return err
+		}
+		if !isUniqueViolation(err, "projects_slug_key") {
+			return err
+		}
+
+		// The failed statement aborted the transaction, undo it before looking again
+		_, rollbackErr := tx.Exec("ROLLBACK TO SAVEPOINT project_slug")
+		if rollbackErr != nil {
+			return rollbackErr
+		}
+		if explicit {
+			return ErrSlugTaken
+		}
+		if attempt == maxSlugAttempts {
+			return err
+		}
+		project.Slug = ""
+	}
+}
+
+// GetTakenSlugs returns the slugs equal to base or base-<suffix> used by projects other than projectId.
+func GetTakenSlugs(tx *sql.Tx, base string, projectId int) (map[string]bool, error) {
+	rows, err := tx.Query(
+		`SELECT slug FROM projects WHERE id <> $2 AND (slug = $1 OR slug LIKE $1 || '-%')
+		UNION
+		SELECT slug FROM project_slugs WHERE project_id <> $2 AND (slug = $1 OR slug LIKE $1 || '-%')`,
+		base, projectId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	takenSlugs := make(map[string]bool)
+	for rows.Next() {
+		var taken string
+		err := rows.Scan(&taken)
+		if err != nil {
+			return nil, err
+		}
+		takenSlugs[taken] = true
+	}
+
+	return takenSlugs, rows.Err()
+}
+
+// RecordProjectSlugChange keeps oldSlug in the history so it keeps redirecting to the project,
+// and drops newSlug from the history in case the project is renamed back to it.
+func RecordProjectSlugChange(tx *sql.Tx, projectId int, oldSlug string, newSlug string) error {
+	_, err := tx.Exec("DELETE FROM project_slugs WHERE slug = $1", newSlug)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(
+		"INSERT INTO project_slugs (slug, project_id) VALUES ($1, $2) ON CONFLICT (slug) DO UPDATE SET project_id = $2, created_at = now()",
+		oldSlug, projectId)
+	return err
+}
+
+func DeleteProjectSlugHistory(tx *sql.Tx, projectId int) error {
+	_, err := tx.Exec("DELETE FROM project_slugs WHERE project_id = $1", projectId)
+	return err
+}
+
+func GetProjectBySlug(projectSlug string, project *models.Project) error {
+	err := scanProject(database.DB.QueryRow("SELECT "+projectColumns+" FROM projects p WHERE p.slug = $1 AND p.deleted_at IS NULL", projectSlug), project)
+	if err != nil {
+		return err
+	}
+	return loadProjectLinks(project)
+}
+
+// GetCurrentSlugForHistoricSlug returns the current slug of the project that used to own oldSlug.
+func GetCurrentSlugForHistoricSlug(oldSlug string) (string, error) {
+	var currentSlug string
+	err := database.DB.QueryRow("SELECT p.slug FROM project_slugs s JOIN projects p ON p.id = s.project_id WHERE s.slug = $1 AND p.deleted_at IS NULL", oldSlug).Scan(&currentSlug)
+	return currentSlug, err
+}
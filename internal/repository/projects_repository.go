@@ -3,31 +3,57 @@ package repository
 import (
 	"database/sql"
 	"fmt"
+	"fold/internal/config"
 	"fold/internal/database"
+	"fold/internal/events"
 	"fold/internal/models"
 	"fold/internal/services"
 	"log"
-	"time"
+
+	"github.com/lib/pq"
 )
 
+// projectColumns selects a full project row from projects aliased as p.
+const projectColumns = "p.id, p.name, p.slug, p.description, p.created_at, p.updated_at, p.deleted_at"
+
+func scanProject(row rowScanner, project *models.Project) error {
+	return row.Scan(&project.ID, &project.Name, &project.Slug, &project.Description, &project.CreatedAt, &project.UpdatedAt, &project.DeletedAt)
+}
+
 func CreateProject(tx *sql.Tx, project *models.Project) (int, error) {
-	var projectId int
 	err := tx.QueryRow(
-		"INSERT INTO projects (name, slug, description, created_at) VALUES ($1, $2, $3, $4) RETURNING id",
-		project.Name, project.Slug, project.Description, time.Now()).Scan(&projectId)
+		"INSERT INTO projects (name, slug, description) VALUES ($1, $2, $3) RETURNING id, created_at, updated_at",
+		project.Name, project.Slug, project.Description).Scan(&project.ID, &project.CreatedAt, &project.UpdatedAt)
 
-	return projectId, err
+	return project.ID, err
 }
 
 func GetProjectById(projectId int, project *models.Project) error {
-	err := database.DB.QueryRow("SELECT * FROM projects WHERE id = $1", projectId).Scan(&project.ID, &project.Name, &project.Slug, &project.Description, &project.CreatedAt)
-	return err
+	err := scanProject(database.DB.QueryRow("SELECT "+projectColumns+" FROM projects p WHERE p.id = $1 AND p.deleted_at IS NULL", projectId), project)
+	if err != nil {
+		return err
+	}
+	return loadProjectLinks(project)
 }
 
-func GetAllProjects() ([]models.Project, error) {
-	var projects []models.Project
+func GetAllProjects(includeDeleted bool) ([]models.Project, error) {
+	return queryProjects("SELECT " + projectColumns + " FROM projects p WHERE " + deletedFilter("p.deleted_at", includeDeleted) + " ORDER BY p.id")
+}
 
-	rows, err := database.DB.Query("SELECT id, name, slug, description, created_at FROM projects")
+// GetUserProjects lists the projects of a user, only those where the user has the given role unless role is empty.
+func GetUserProjects(userId int, role string) ([]models.Project, error) {
+	return queryProjects("SELECT "+projectColumns+" FROM projects p JOIN user_projects up ON up.project_id = p.id WHERE up.user_id = $1 AND ($2 = '' OR up.role = $2) AND p.deleted_at IS NULL ORDER BY p.id", userId, role)
+}
+
+func GetHashtagProjects(hashtagId int) ([]models.Project, error) {
+	return queryProjects("SELECT "+projectColumns+" FROM projects p JOIN project_hashtags ph ON ph.project_id = p.id WHERE ph.hashtag_id = $1 AND p.deleted_at IS NULL ORDER BY p.id", hashtagId)
+}
+
+// queryProjects runs a query selecting projectColumns and loads the user and hashtag IDs of every project.
+func queryProjects(query string, args ...interface{}) ([]models.Project, error) {
+	projects := []models.Project{}
+
+	rows, err := database.DB.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -35,15 +61,11 @@ func GetAllProjects() ([]models.Project, error) {
 
 	for rows.Next() {
 		var project models.Project
-		err := rows.Scan(&project.ID, &project.Name, &project.Slug, &project.Description, &project.CreatedAt)
+		err := scanProject(rows, &project)
 		if err != nil {
 			return nil, err
 		}
-		project.UserIds, err = GetProjectUsersId(project.ID)
-		if err != nil {
-			return nil, err
-		}
-		project.HashtagIds, err = GetProjectHashtagsId(project.ID)
+		err = loadProjectLinks(&project)
 		if err != nil {
 			return nil, err
 		}
@@ -58,14 +80,27 @@ func GetAllProjects() ([]models.Project, error) {
 	return projects, nil
 }
 
-func GetProjectByIdForTransaction(tx *sql.Tx, projectId int, project *models.Project) error {
-	err := tx.QueryRow("SELECT * FROM projects WHERE id = $1", projectId).Scan(&project.ID, &project.Name, &project.Slug, &project.Description, &project.CreatedAt)
+// loadProjectLinks loads the user IDs, user roles and hashtag IDs of a project.
+func loadProjectLinks(project *models.Project) error {
+	var err error
+	project.UserIds, err = GetProjectUsersId(project.ID)
+	if err != nil {
+		return err
+	}
+	project.UserRoles, err = GetProjectUserRoles(project.ID)
+	if err != nil {
+		return err
+	}
+	project.HashtagIds, err = GetProjectHashtagsId(project.ID)
 	return err
 }
 
+func GetProjectByIdForTransaction(tx *sql.Tx, projectId int, project *models.Project) error {
+	return scanProject(tx.QueryRow("SELECT "+projectColumns+" FROM projects p WHERE p.id = $1 AND p.deleted_at IS NULL", projectId), project)
+}
+
 func UpdateProject(tx *sql.Tx, project *models.Project) error {
-	_, err := tx.Exec("UPDATE projects SET name = $1, slug = $2, description = $3 WHERE id = $4", project.Name, project.Slug, project.Description, project.ID)
-	return err
+	return requireRowsAffected(tx.Exec("UPDATE projects SET name = $1, slug = $2, description = $3, updated_at = now() WHERE id = $4 AND deleted_at IS NULL", project.Name, project.Slug, project.Description, project.ID))
 }
 
 func DeleteProject(tx *sql.Tx, projectId int) error {
@@ -73,9 +108,29 @@ func DeleteProject(tx *sql.Tx, projectId int) error {
 	return err
 }
 
+// TouchProject marks a project as changed when only its links were modified.
+func TouchProject(tx *sql.Tx, projectId int) error {
+	_, err := tx.Exec("UPDATE projects SET updated_at = now() WHERE id = $1", projectId)
+	return err
+}
+
+// LockProject locks a project row for the rest of the transaction, returning sql.ErrNoRows when it does not exist.
+func LockProject(tx *sql.Tx, projectId int) error {
+	var id int
+	return tx.QueryRow("SELECT id FROM projects WHERE id = $1 AND deleted_at IS NULL FOR UPDATE", projectId).Scan(&id)
+}
+
+func SoftDeleteProject(tx *sql.Tx, projectId int) error {
+	return requireRowsAffected(tx.Exec("UPDATE projects SET deleted_at = now(), updated_at = now() WHERE id = $1 AND deleted_at IS NULL", projectId))
+}
+
+func RestoreProject(tx *sql.Tx, projectId int) error {
+	return requireRowsAffected(tx.Exec("UPDATE projects SET deleted_at = NULL, updated_at = now() WHERE id = $1 AND deleted_at IS NOT NULL", projectId))
+}
+
 func ProjectExists(projectId int) bool {
 	var exists bool
-	err := database.DB.QueryRow("SELECT EXISTS(SELECT 1 FROM projects WHERE id = $1)", projectId).Scan(&exists)
+	err := database.DB.QueryRow("SELECT EXISTS(SELECT 1 FROM projects WHERE id = $1 AND deleted_at IS NULL)", projectId).Scan(&exists)
 	if err != nil {
 		log.Fatal(err)
 		return false
@@ -85,7 +140,7 @@ func ProjectExists(projectId int) bool {
 
 func GetProjectUsersId(projectId int) ([]int, error) {
 	var userIds []int
-	rows, err := database.DB.Query("SELECT u.id FROM users u JOIN user_projects p ON u.id = p.user_id WHERE p.project_id = $1", projectId)
+	rows, err := database.DB.Query("SELECT u.id FROM users u JOIN user_projects p ON u.id = p.user_id WHERE p.project_id = $1 AND u.deleted_at IS NULL", projectId)
 	if err != nil {
 		return nil, err
 	}
@@ -105,7 +160,7 @@ func GetProjectUsersId(projectId int) ([]int, error) {
 func GetProjectHashtagsId(projectId int) ([]int, error) {
 	var hashtagIds []int
 
-	rows, err := database.DB.Query("SELECT h.id FROM hashtags h JOIN project_hashtags p ON h.id = p.hashtag_id WHERE p.project_id = $1", projectId)
+	rows, err := database.DB.Query("SELECT h.id FROM hashtags h JOIN project_hashtags p ON h.id = p.hashtag_id WHERE p.project_id = $1 AND h.deleted_at IS NULL", projectId)
 	if err != nil {
 		return nil, err
 	}
@@ -124,26 +179,26 @@ func GetProjectHashtagsId(projectId int) ([]int, error) {
 }
 
 func GetProjectUsers(tx *sql.Tx, projectId int, doc *models.DenormalizedProject) error {
-	rows, err := tx.Query("SELECT u.id, u.name, u.created_at FROM users u JOIN user_projects p ON u.id = p.user_id WHERE p.project_id = $1", projectId)
+	rows, err := tx.Query("SELECT "+userColumns+", up.role FROM users u JOIN user_projects up ON u.id = up.user_id WHERE up.project_id = $1 AND u.deleted_at IS NULL", projectId)
 	if err != nil {
 		return err
 	}
 	defer rows.Close()
 
 	for rows.Next() {
-		var user models.User
-		err := rows.Scan(&user.ID, &user.Name, &user.CreatedAt)
+		var member models.ProjectMember
+		err := rows.Scan(&member.ID, &member.Name, &member.CreatedAt, &member.UpdatedAt, &member.DeletedAt, &member.Role)
 		if err != nil {
 			return err
 		}
-		doc.Users = append(doc.Users, user)
+		doc.Users = append(doc.Users, member)
 	}
 
 	return err
 }
 
 func GetProjectHashtags(tx *sql.Tx, projectId int, doc *models.DenormalizedProject) error {
-	rows, err := tx.Query("SELECT h.id, h.name, h.created_at FROM hashtags h JOIN project_hashtags p ON h.id = p.hashtag_id WHERE p.project_id = $1", projectId)
+	rows, err := tx.Query("SELECT "+hashtagColumns+" FROM hashtags h JOIN project_hashtags p ON h.id = p.hashtag_id LEFT JOIN hashtag_aliases a ON a.hashtag_id = h.id WHERE p.project_id = $1 AND h.deleted_at IS NULL GROUP BY h.id", projectId)
 	if err != nil {
 		return err
 	}
@@ -151,7 +206,7 @@ func GetProjectHashtags(tx *sql.Tx, projectId int, doc *models.DenormalizedProje
 
 	for rows.Next() {
 		var hashtag models.Hashtag
-		err := rows.Scan(&hashtag.ID, &hashtag.Name, &hashtag.CreatedAt)
+		err := scanHashtag(rows, &hashtag)
 		if err != nil {
 			return err
 		}
@@ -161,102 +216,385 @@ func GetProjectHashtags(tx *sql.Tx, projectId int, doc *models.DenormalizedProje
 	return err
 }
 
-func ProjectCreationAndSyncTransaction(project *models.Project) error {
+// MissingReferencesError lists the user and hashtag IDs of a project payload that do not exist.
+type MissingReferencesError struct {
+	UserIds    []int
+	HashtagIds []int
+}
+
+func (e *MissingReferencesError) Error() string {
+	return fmt.Sprintf("missing references: users %v, hashtags %v", e.UserIds, e.HashtagIds)
+}
+
+// ValidateProjectReferences checks every user and hashtag ID of a project in a single query.
+// Existing rows are locked with FOR KEY SHARE so they cannot be deleted before the transaction commits.
+func ValidateProjectReferences(tx *sql.Tx, project *models.Project) error {
+	rows, err := tx.Query(
+		`SELECT 'user', ids.id FROM unnest($1::int[]) AS ids(id)
+			LEFT JOIN (SELECT id FROM users WHERE id = ANY($1) AND deleted_at IS NULL FOR KEY SHARE) u ON u.id = ids.id
+			WHERE u.id IS NULL
+		UNION ALL
+		SELECT 'hashtag', ids.id FROM unnest($2::int[]) AS ids(id)
+			LEFT JOIN (SELECT id FROM hashtags WHERE id = ANY($2) AND deleted_at IS NULL FOR KEY SHARE) h ON h.id = ids.id
+			WHERE h.id IS NULL`,
+		pq.Array(project.UserIds), pq.Array(project.HashtagIds))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var missing MissingReferencesError
+	for rows.Next() {
+		var kind string
+		var id int
+		err := rows.Scan(&kind, &id)
+		if err != nil {
+			return err
+		}
+		if kind == "user" {
+			missing.UserIds = append(missing.UserIds, id)
+		} else {
+			missing.HashtagIds = append(missing.HashtagIds, id)
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	if len(missing.UserIds) > 0 || len(missing.HashtagIds) > 0 {
+		return &missing
+	}
+	return nil
+}
+
+func ProjectCreationAndSyncTransaction(info models.AuditInfo, project *models.Project) error {
 	tx, err := database.DB.Begin()
 	if err != nil {
 		return err
 	}
 
-	// Insert project into the database
-	projectId, err := CreateProject(tx, project)
+	// Check that all users and hashtags exist.
+	err = ValidateProjectReferences(tx, project)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	// Insert project with its links into the database
+	err = applyProjectCreate(tx, info, project)
 	if err != nil {
 		tx.Rollback()
 		return err
 	}
 
-	// Create entries in user_projects.
-	users := project.UserIds
+	// Sync ElasticSearch
+	err = SyncElasticsearch(tx, project.ID, models.EventProjectUpserted, eventCause(info, AuditEntityProject, project.ID, "create"))
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	// Commit the transaction
+	return tx.Commit()
+}
 
-	for _, userId := range users {
-		err = CreateProjectUsers(tx, projectId, userId)
+// applyProjectCreate inserts a project with its users and hashtags and records it in the audit log.
+// The references of the project must already be validated.
+func applyProjectCreate(tx *sql.Tx, info models.AuditInfo, project *models.Project) error {
+	// Insert project into the database with a generated or checked slug
+	var projectId int
+	err := writeWithProjectSlug(tx, project, func() error {
+		var err error
+		projectId, err = CreateProject(tx, project)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	project.ID = projectId
+
+	// Create entries in user_projects, making the first user the owner when no owner is given.
+	roles := projectUserRoles(project, nil)
+	if !hasOwner(roles) && len(project.UserIds) > 0 {
+		roles[project.UserIds[0]] = models.RoleOwner
+	}
+
+	for _, userId := range project.UserIds {
+		err = CreateProjectUsers(tx, projectId, userId, roles[userId])
 		if err != nil {
-			tx.Rollback()
 			return err
 		}
 	}
 
-	// Create entries in project_hashtags.
-	hashtags := project.HashtagIds
+	// Check that the project has an owner.
+	err = RequireProjectOwner(tx, projectId)
+	if err != nil {
+		return err
+	}
 
-	for _, hashtagId := range hashtags {
+	// Create entries in project_hashtags.
+	for _, hashtagId := range project.HashtagIds {
 		err = CreateProjectHashtags(tx, hashtagId, projectId)
 		if err != nil {
-			tx.Rollback()
 			return err
 		}
 	}
 
+	// Record the change in the audit log.
+	return RecordAuditChange(tx, info, AuditEntityProject, projectId, "create", nil)
+}
+
+func ProjectUpdateAndSyncTransaction(info models.AuditInfo, project *models.Project) error {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return err
+	}
+
+	// Check that all users and hashtags exist.
+	err = ValidateProjectReferences(tx, project)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	// Update project with its links in the database
+	err = applyProjectUpdate(tx, info, project, AllProjectLinks)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
 	// Sync ElasticSearch
-	err = SyncElasticsearch(tx, projectId, "POST")
+	err = SyncElasticsearch(tx, project.ID, models.EventProjectUpserted, eventCause(info, AuditEntityProject, project.ID, "update"))
 	if err != nil {
 		tx.Rollback()
 		return err
 	}
+
 	// Commit the transaction
 	return tx.Commit()
 }
 
-func ProjectUpdateAndSyncTransaction(project *models.Project) error {
+// ProjectLinks selects the links of a project that an update replaces. Links left out are kept as they are,
+// including the links to soft-deleted users and hashtags.
+type ProjectLinks struct {
+	Users    bool
+	Hashtags bool
+}
+
+// AllProjectLinks replaces the users and hashtags of a project, as PUT does.
+var AllProjectLinks = ProjectLinks{Users: true, Hashtags: true}
+
+// ProjectPatchAndSyncTransaction updates a project with the result of patch, which is applied to the
+// current project while the project is locked, so concurrent changes are not lost. The current
+// project passed to patch lists the users and hashtags that are not soft-deleted, with their roles.
+// Only the links selected by links are replaced. An error of patch is returned as is.
+func ProjectPatchAndSyncTransaction(info models.AuditInfo, projectId int, links ProjectLinks, patch func(current *models.Project) (*models.Project, error)) error {
 	tx, err := database.DB.Begin()
 	if err != nil {
 		return err
 	}
 
-	// Update project into the database
-	err = UpdateProject(tx, project)
+	// Lock the project so concurrent changes are applied one after another.
+	err = LockProject(tx, projectId)
 	if err != nil {
 		tx.Rollback()
 		return err
 	}
 
-	//Remove old entries in user_projects.
-	err = DeleteProjectUsers(tx, project.ID)
+	// Load the current project with its links to apply the patch to.
+	var currentProject models.Project
+	err = GetProjectByIdForTransaction(tx, projectId, &currentProject)
+	if err == nil {
+		currentProject.UserIds, err = getLinkedIds(tx, "SELECT up.user_id FROM user_projects up JOIN users u ON u.id = up.user_id WHERE up.project_id = $1 AND u.deleted_at IS NULL ORDER BY up.user_id", projectId)
+	}
+	if err == nil {
+		currentProject.UserRoles, err = getProjectUserRoles(tx, projectId, false)
+	}
+	if err == nil {
+		currentProject.HashtagIds, err = getLinkedIds(tx, "SELECT ph.hashtag_id FROM project_hashtags ph JOIN hashtags h ON h.id = ph.hashtag_id WHERE ph.project_id = $1 AND h.deleted_at IS NULL ORDER BY ph.hashtag_id", projectId)
+	}
 	if err != nil {
 		tx.Rollback()
 		return err
 	}
 
+	// Apply the patch.
+	project, err := patch(&currentProject)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	project.ID = projectId
+
+	// Check that all users and hashtags exist.
+	err = ValidateProjectReferences(tx, project)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	// Update project and the patched links in the database
+	err = applyProjectUpdate(tx, info, project, links)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	// Sync ElasticSearch
+	err = SyncElasticsearch(tx, projectId, models.EventProjectUpserted, eventCause(info, AuditEntityProject, projectId, "update"))
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	// Commit the transaction
+	return tx.Commit()
+}
+
+// applyProjectUpdate replaces a project and the selected links and records it in the audit log.
+// The references of the project must already be validated.
+func applyProjectUpdate(tx *sql.Tx, info models.AuditInfo, project *models.Project, links ProjectLinks) error {
+	// Snapshot the project before the change for the audit log.
+	before, err := GetAuditSnapshot(tx, AuditEntityProject, project.ID)
+	if err != nil {
+		return err
+	}
+
+	// Load the current project to detect slug changes.
+	var currentProject models.Project
+	err = GetProjectByIdForTransaction(tx, project.ID, &currentProject)
+	if err != nil {
+		return err
+	}
+
+	// Keep the current slug unless a new one is given or the name changed.
+	if project.Slug == "" && project.Name == currentProject.Name {
+		project.Slug = currentProject.Slug
+	}
+
+	// Update project into the database with a generated or checked slug
+	err = writeWithProjectSlug(tx, project, func() error {
+		return UpdateProject(tx, project)
+	})
+	if err != nil {
+		return err
+	}
+
+	// Keep the old slug redirecting to the project.
+	if project.Slug != currentProject.Slug {
+		err = RecordProjectSlugChange(tx, project.ID, currentProject.Slug, project.Slug)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Replace the links that are part of the update.
+	if links.Users {
+		err = replaceProjectUsers(tx, project)
+		if err != nil {
+			return err
+		}
+	}
+
+	if links.Hashtags {
+		err = replaceProjectHashtags(tx, project)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Record the change in the audit log.
+	return RecordAuditChange(tx, info, AuditEntityProject, project.ID, "update", before)
+}
+
+// replaceProjectUsers replaces the users of a project, keeping the current role of users
+// without a new one.
+func replaceProjectUsers(tx *sql.Tx, project *models.Project) error {
+	// Load the current roles so users without a new role keep theirs.
+	currentRoles, err := getProjectUserRoles(tx, project.ID, true)
+	if err != nil {
+		return err
+	}
+
+	//Remove old entries in user_projects, keeping those of soft-deleted users.
+	err = DeleteProjectUsers(tx, project.ID)
+	if err != nil {
+		return err
+	}
+
 	// Update entries in user_projects.
-	users := project.UserIds
+	roles := projectUserRoles(project, currentRoles)
 
-	for _, userId := range users {
-		err = CreateProjectUsers(tx, project.ID, userId)
+	for _, userId := range project.UserIds {
+		err = CreateProjectUsers(tx, project.ID, userId, roles[userId])
 		if err != nil {
-			tx.Rollback()
 			return err
 		}
 	}
 
-	//Remove old entries in project_hastags.
-	err = DeleteProjectHashtags(tx, project.ID)
+	// Check that the project still has an owner.
+	return RequireProjectOwner(tx, project.ID)
+}
+
+// replaceProjectHashtags replaces the hashtags of a project.
+func replaceProjectHashtags(tx *sql.Tx, project *models.Project) error {
+	//Remove old entries in project_hastags, keeping those of soft-deleted hashtags.
+	err := DeleteProjectHashtags(tx, project.ID)
 	if err != nil {
-		tx.Rollback()
 		return err
 	}
 
 	// Update entries in project_hashtags.
-	hashtags := project.HashtagIds
-
-	for _, hashtagId := range hashtags {
+	for _, hashtagId := range project.HashtagIds {
 		err = CreateProjectHashtags(tx, hashtagId, project.ID)
 		if err != nil {
-			tx.Rollback()
 			return err
 		}
 	}
+	return nil
+}
 
-	// Sync ElasticSearch
-	err = SyncElasticsearch(tx, project.ID, "POST")
+// projectUserRoles resolves the role of every user of a project payload: the role given in the
+// payload, else the current role, else the default role.
+func projectUserRoles(project *models.Project, currentRoles map[int]string) map[int]string {
+	roles := make(map[int]string, len(project.UserIds))
+	for _, userId := range project.UserIds {
+		if role, ok := project.UserRoles[userId]; ok {
+			roles[userId] = role
+		} else if role, ok := currentRoles[userId]; ok {
+			roles[userId] = role
+		} else {
+			roles[userId] = models.DefaultRole
+		}
+	}
+	return roles
+}
+
+func hasOwner(roles map[int]string) bool {
+	for _, role := range roles {
+		if role == models.RoleOwner {
+			return true
+		}
+	}
+	return false
+}
+
+func ProjectDeleteAndSyncTransaction(info models.AuditInfo, projectId int) error {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return err
+	}
+
+	// Delete document from elasticsearch
+	err = SyncElasticsearch(tx, projectId, models.EventProjectDeleted, eventCause(info, AuditEntityProject, projectId, "delete"))
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	// Soft delete project in the database
+	err = applyProjectDelete(tx, info, projectId)
 	if err != nil {
 		tx.Rollback()
 		return err
@@ -266,35 +604,52 @@ func ProjectUpdateAndSyncTransaction(project *models.Project) error {
 	return tx.Commit()
 }
 
-func ProjectDeleteAndSyncTransaction(projectId int) error {
+// applyProjectDelete soft deletes a project, keeping its links for a restore, and records it in the audit log.
+func applyProjectDelete(tx *sql.Tx, info models.AuditInfo, projectId int) error {
+	// Snapshot the project before the change for the audit log.
+	before, err := GetAuditSnapshot(tx, AuditEntityProject, projectId)
+	if err != nil {
+		return err
+	}
+
+	err = SoftDeleteProject(tx, projectId)
+	if err != nil {
+		return err
+	}
+
+	// Record the change in the audit log.
+	return RecordAuditChange(tx, info, AuditEntityProject, projectId, "delete", before)
+}
+
+func ProjectRestoreAndSyncTransaction(info models.AuditInfo, projectId int) error {
 	tx, err := database.DB.Begin()
 	if err != nil {
 		return err
 	}
 
-	//Remove entries in user_projects.
-	err = DeleteProjectUsers(tx, projectId)
+	// Snapshot the project before the change for the audit log.
+	before, err := GetAuditSnapshot(tx, AuditEntityProject, projectId)
 	if err != nil {
 		tx.Rollback()
 		return err
 	}
 
-	//Remove entries in project_hastags.
-	err = DeleteProjectHashtags(tx, projectId)
+	// Restore project in the database
+	err = RestoreProject(tx, projectId)
 	if err != nil {
 		tx.Rollback()
 		return err
 	}
 
-	// Delete document from elasticsearch
-	err = SyncElasticsearch(tx, projectId, "DELETE")
+	// Record the change in the audit log.
+	err = RecordAuditChange(tx, info, AuditEntityProject, projectId, "restore", before)
 	if err != nil {
 		tx.Rollback()
 		return err
 	}
 
-	// Delete project into the database
-	err = DeleteProject(tx, projectId)
+	// Index the restored document in elasticsearch
+	err = SyncElasticsearch(tx, projectId, models.EventProjectUpserted, eventCause(info, AuditEntityProject, projectId, "restore"))
 	if err != nil {
 		tx.Rollback()
 		return err
@@ -304,35 +659,146 @@ func ProjectDeleteAndSyncTransaction(projectId int) error {
 	return tx.Commit()
 }
 
-func SyncElasticsearch(tx *sql.Tx, projectId int, method string) error {
-	// Perform Denormalization of project and send it to sqs queue.
+// ProjectLinkTransaction applies a single membership or tagging change to a project and emits one
+// sync event for it. change reports whether anything was modified; when it was not, the
+// transaction is rolled back without an audit or sync event and false is returned.
+func ProjectLinkTransaction(info models.AuditInfo, projectId int, action string, change func(tx *sql.Tx) (bool, error)) (bool, error) {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return false, err
+	}
+
+	// Lock the project so concurrent link changes are applied one after another.
+	err = LockProject(tx, projectId)
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+
+	// Snapshot the project before the change for the audit log.
+	before, err := GetAuditSnapshot(tx, AuditEntityProject, projectId)
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+
+	// Apply the link change.
+	changed, err := change(tx)
+	if err != nil || !changed {
+		tx.Rollback()
+		return false, err
+	}
+
+	// Mark the project as changed.
+	err = TouchProject(tx, projectId)
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+
+	// Record the change in the audit log.
+	err = RecordAuditChange(tx, info, AuditEntityProject, projectId, action, before)
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+
+	// Sync ElasticSearch
+	err = SyncElasticsearch(tx, projectId, models.EventProjectUpserted, eventCause(info, AuditEntityProject, projectId, action))
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+
+	return true, tx.Commit()
+}
+
+// AddProjectUserTransaction links a user to a project or changes their role. An empty role
+// adds the user with the default role and keeps the role of a user already linked.
+func AddProjectUserTransaction(info models.AuditInfo, projectId int, userId int, role string) (bool, error) {
+	return ProjectLinkTransaction(info, projectId, "add_user", func(tx *sql.Tx) (bool, error) {
+		err := ValidateProjectReferences(tx, &models.Project{UserIds: []int{userId}})
+		if err != nil {
+			return false, err
+		}
+		changed, err := AddProjectUser(tx, projectId, userId, role)
+		if err != nil || !changed {
+			return false, err
+		}
+		return true, RequireProjectOwner(tx, projectId)
+	})
+}
+
+func RemoveProjectUserTransaction(info models.AuditInfo, projectId int, userId int) (bool, error) {
+	return ProjectLinkTransaction(info, projectId, "remove_user", func(tx *sql.Tx) (bool, error) {
+		err := RemoveProjectUser(tx, projectId, userId)
+		if err != nil {
+			return false, err
+		}
+		return true, RequireProjectOwner(tx, projectId)
+	})
+}
+
+func AddProjectHashtagTransaction(info models.AuditInfo, projectId int, hashtagId int) (bool, error) {
+	return ProjectLinkTransaction(info, projectId, "add_hashtag", func(tx *sql.Tx) (bool, error) {
+		err := ValidateProjectReferences(tx, &models.Project{HashtagIds: []int{hashtagId}})
+		if err != nil {
+			return false, err
+		}
+		return AddProjectHashtag(tx, hashtagId, projectId)
+	})
+}
+
+func RemoveProjectHashtagTransaction(info models.AuditInfo, projectId int, hashtagId int) (bool, error) {
+	return ProjectLinkTransaction(info, projectId, "remove_hashtag", func(tx *sql.Tx) (bool, error) {
+		return true, RemoveProjectHashtag(tx, hashtagId, projectId)
+	})
+}
+
+// GetProjectDoc denormalizes a project with its users and hashtags as seen by the transaction.
+func GetProjectDoc(tx *sql.Tx, projectId int) (models.DenormalizedProject, error) {
 	var project models.Project
 	err := GetProjectByIdForTransaction(tx, projectId, &project)
 	if err != nil {
-		tx.Rollback()
-		return err
+		return models.DenormalizedProject{}, err
 	}
 
 	doc := createDoc(projectId, &project)
 
 	err = GetProjectUsers(tx, projectId, &doc)
 	if err != nil {
-		tx.Rollback()
-		return err
+		return models.DenormalizedProject{}, err
 	}
 
 	err = GetProjectHashtags(tx, projectId, &doc)
+	if err != nil {
+		return models.DenormalizedProject{}, err
+	}
+
+	return doc, nil
+}
+
+// GetProjectDetails returns a project with its resolved users and hashtags.
+func GetProjectDetails(projectId int) (models.DenormalizedProject, error) {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return models.DenormalizedProject{}, err
+	}
+	defer tx.Rollback()
+
+	return GetProjectDoc(tx, projectId)
+}
+
+// SyncElasticsearch sends a sync event of the project as seen by the transaction to the queue.
+func SyncElasticsearch(tx *sql.Tx, projectId int, eventType string, cause *models.EventCause) error {
+	// Perform Denormalization of project and send it to sqs queue.
+	event, err := projectEvent(tx, projectId, eventType, cause)
 	if err != nil {
 		tx.Rollback()
 		return err
 	}
 
-	//create payload
-	var payload models.Payload
-	payload.Doc = doc
-	payload.Method = method
-
-	err = services.SQS(&payload)
+	err = sendSyncEvent(event)
 	if err != nil {
 		fmt.Println("SQS push failed")
 		tx.Rollback()
@@ -342,12 +808,68 @@ func SyncElasticsearch(tx *sql.Tx, projectId int, method string) error {
 	return err
 }
 
+// projectEvent builds the sync event of a project as seen by the transaction.
+func projectEvent(tx *sql.Tx, projectId int, eventType string, cause *models.EventCause) (*models.SyncEvent, error) {
+	doc, err := GetProjectDoc(tx, projectId)
+	if err != nil {
+		return nil, err
+	}
+
+	return projectEventOf(tx, doc, eventType, cause)
+}
+
+// projectEventOf wraps a document captured earlier in the transaction in a sync event.
+func projectEventOf(tx *sql.Tx, doc models.DenormalizedProject, eventType string, cause *models.EventCause) (*models.SyncEvent, error) {
+	revision, err := nextProjectRevision(tx, doc.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return events.New(eventType, doc, revision, cause), nil
+}
+
+// nextProjectRevision counts up the revision of a project for its next sync event.
+func nextProjectRevision(tx *sql.Tx, projectId int) (int64, error) {
+	return nextRevision(tx, "projects", projectId)
+}
+
+// nextRevision counts up the revision of a user, hashtag or project row for its next sync event.
+// The row lock it takes keeps the revisions of concurrent transactions in commit order.
+func nextRevision(tx *sql.Tx, table string, id int) (int64, error) {
+	var revision int64
+	err := tx.QueryRow("UPDATE "+table+" SET revision = revision + 1 WHERE id = $1 RETURNING revision", id).Scan(&revision)
+	return revision, err
+}
+
+// eventCause describes the change of the request as the cause of sync events.
+func eventCause(info models.AuditInfo, entityType string, entityId int, action string) *models.EventCause {
+	return &models.EventCause{EntityType: entityType, EntityID: entityId, Action: action, RequestID: info.RequestID}
+}
+
+// renameEventsEnabled tells whether user and hashtag updates send a single rename event instead
+// of the documents of all their projects. Enable it once every consumer applies rename events.
+func renameEventsEnabled() bool {
+	return config.Bool("SYNC_RENAME_EVENTS", false)
+}
+
+// fanOutCause describes a user or hashtag change as the shared cause of the sync events of its
+// projects, so consumers can tell them apart from direct project edits and handle them together.
+func fanOutCause(info models.AuditInfo, entityType string, entityId int, action string, projects int) *models.EventCause {
+	cause := eventCause(info, entityType, entityId, action)
+	cause.CorrelationID = services.GenerateUniqueID()
+	cause.FanOut = projects
+	return cause
+}
+
 func createDoc(projectId int, project *models.Project) models.DenormalizedProject {
 	var doc models.DenormalizedProject
 	doc.ID = projectId
 	doc.Slug = project.Slug
 	doc.Name = project.Name
 	doc.CreatedAt = project.CreatedAt
+	doc.UpdatedAt = project.UpdatedAt
 	doc.Description = project.Description
+	doc.Users = []models.ProjectMember{}
+	doc.Hashtags = []models.Hashtag{}
 	return doc
 }
@@ -0,0 +1,409 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fold/internal/models"
+	"reflect"
+	"testing"
+)
+
+func TestProjectTransactions(t *testing.T) {
+	capture := setupDB(t)
+
+	adaId := createTestUser(t, "Ada")
+	graceId := createTestUser(t, "Grace")
+	hashtagId := createTestHashtag(t, "go")
+
+	// Create stores the project with a generated slug and makes the first user the owner
+	project := models.Project{Name: "Fold Search", Description: "Search", UserIds: []int{adaId, graceId}, HashtagIds: []int{hashtagId}}
+	err := ProjectCreationAndSyncTransaction(testInfo, &project)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if project.Slug != "fold-search" {
+		t.Fatalf("slug %q, want fold-search", project.Slug)
+	}
+	projectId := project.ID
+	if role := stringColumn(t, "SELECT role FROM user_projects WHERE project_id = $1 AND user_id = $2", projectId, adaId); role != models.RoleOwner {
+		t.Fatalf("first user has role %q, want owner", role)
+	}
+	events := capture.takeEvents(t, models.EventProjectUpserted, projectId)
+	doc := events[0].Doc
+	if doc.Name != "Fold Search" || doc.Slug != "fold-search" || doc.Description != "Search" || doc.UpdatedAt.IsZero() {
+		t.Fatalf("unexpected document %+v", doc)
+	}
+	expectStrings(t, "users", userNames(doc), "Ada:owner", "Grace:contributor")
+	expectStrings(t, "hashtags", hashtagNames(doc), "go")
+	if events[0].EntityRevision != 1 {
+		t.Fatalf("revision %d, want 1", events[0].EntityRevision)
+	}
+	cause := events[0].Cause
+	if cause.EntityType != AuditEntityProject || cause.EntityID != projectId || cause.Action != "create" || cause.CorrelationID != "" || cause.RequestID != testInfo.RequestID {
+		t.Fatalf("unexpected cause %+v", cause)
+	}
+
+	// A second project with the same name gets the next free slug
+	second := models.Project{Name: "Fold Search", UserIds: []int{graceId}}
+	err = ProjectCreationAndSyncTransaction(testInfo, &second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.Slug != "fold-search-2" {
+		t.Fatalf("slug %q, want fold-search-2", second.Slug)
+	}
+	capture.takeEvents(t, models.EventProjectUpserted, second.ID)
+
+	// Missing references fail without a row or a event
+	err = ProjectCreationAndSyncTransaction(testInfo, &models.Project{Name: "Broken", UserIds: []int{adaId, 999}})
+	var missing *MissingReferencesError
+	if !errors.As(err, &missing) || len(missing.UserIds) != 1 || missing.UserIds[0] != 999 {
+		t.Fatalf("got error %v, want missing user 999", err)
+	}
+	if n := countRows(t, "SELECT count(*) FROM projects"); n != 2 {
+		t.Fatalf("%d projects stored, want 2", n)
+	}
+	capture.expectNoEvents(t)
+
+	// Update replaces the links, keeps the old slug redirecting and counts up the revision
+	err = ProjectUpdateAndSyncTransaction(testInfo, &models.Project{ID: projectId, Name: "Fold Finder", UserIds: []int{graceId}, UserRoles: map[int]string{graceId: models.RoleOwner}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if slug := stringColumn(t, "SELECT slug FROM projects WHERE id = $1", projectId); slug != "fold-finder" {
+		t.Fatalf("slug %q, want fold-finder", slug)
+	}
+	if n := countRows(t, "SELECT count(*) FROM project_slugs WHERE slug = 'fold-search' AND project_id = $1", projectId); n != 1 {
+		t.Fatal("old slug is not kept in the history")
+	}
+	if n := countRows(t, "SELECT count(*) FROM project_hashtags WHERE project_id = $1", projectId); n != 0 {
+		t.Fatalf("project has %d hashtags after update, want 0", n)
+	}
+	events = capture.takeEvents(t, models.EventProjectUpserted, projectId)
+	expectStrings(t, "users", userNames(events[0].Doc), "Grace:owner")
+	expectStrings(t, "hashtags", hashtagNames(events[0].Doc))
+	if events[0].EntityRevision != 2 {
+		t.Fatalf("revision %d, want 2", events[0].EntityRevision)
+	}
+	if !events[0].Doc.UpdatedAt.After(doc.UpdatedAt) {
+		t.Fatalf("updated_at %v did not advance past %v", events[0].Doc.UpdatedAt, doc.UpdatedAt)
+	}
+
+	// An update leaving the project without an owner is rolled back
+	err = ProjectUpdateAndSyncTransaction(testInfo, &models.Project{ID: projectId, Name: "Fold Finder", UserIds: []int{adaId}, UserRoles: map[int]string{adaId: models.RoleViewer}})
+	expectError(t, err, ErrNoProjectOwner)
+	if n := countRows(t, "SELECT count(*) FROM user_projects WHERE project_id = $1 AND user_id = $2", projectId, graceId); n != 1 {
+		t.Fatal("failed update removed the owner")
+	}
+	capture.expectNoEvents(t)
+
+	// Delete sends the document as it was and keeps the links for a restore
+	err = ProjectDeleteAndSyncTransaction(testInfo, projectId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isDeleted(t, "projects", projectId) {
+		t.Fatal("project is not soft-deleted")
+	}
+	if n := countRows(t, "SELECT count(*) FROM user_projects WHERE project_id = $1", projectId); n != 1 {
+		t.Fatalf("project has %d user links after delete, want 1", n)
+	}
+	events = capture.takeEvents(t, models.EventProjectDeleted, projectId)
+	if events[0].Doc.Name != "Fold Finder" || events[0].EntityRevision != 3 {
+		t.Fatalf("unexpected delete event %+v", events[0])
+	}
+	expectStrings(t, "users", userNames(events[0].Doc), "Grace:owner")
+
+	err = ProjectDeleteAndSyncTransaction(testInfo, projectId)
+	expectError(t, err, sql.ErrNoRows)
+	err = ProjectUpdateAndSyncTransaction(testInfo, &models.Project{ID: projectId, Name: "Gone", UserIds: []int{graceId}})
+	expectError(t, err, sql.ErrNoRows)
+	capture.expectNoEvents(t)
+
+	// Restore indexes the project again
+	err = ProjectRestoreAndSyncTransaction(testInfo, projectId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if isDeleted(t, "projects", projectId) {
+		t.Fatal("project is still soft-deleted")
+	}
+	events = capture.takeEvents(t, models.EventProjectUpserted, projectId)
+	expectStrings(t, "users", userNames(events[0].Doc), "Grace:owner")
+	if events[0].Cause.Action != "restore" || events[0].EntityRevision != 4 {
+		t.Fatalf("unexpected restore event %+v", events[0])
+	}
+
+	err = ProjectRestoreAndSyncTransaction(testInfo, projectId)
+	expectError(t, err, sql.ErrNoRows)
+}
+
+func TestProjectLinkTransactions(t *testing.T) {
+	capture := setupDB(t)
+
+	ownerId := createTestUser(t, "Ada")
+	memberId := createTestUser(t, "Grace")
+	hashtagId := createTestHashtag(t, "go")
+	projectId := createTestProject(t, capture, "Compiler", []int{ownerId}, nil)
+
+	// Adding a hashtag syncs the project once, adding it again changes nothing
+	changed, err := AddProjectHashtagTransaction(testInfo, projectId, hashtagId)
+	if err != nil || !changed {
+		t.Fatalf("add hashtag: changed %t, error %v", changed, err)
+	}
+	events := capture.takeEvents(t, models.EventProjectUpserted, projectId)
+	expectStrings(t, "hashtags", hashtagNames(events[0].Doc), "go")
+	if n := countRows(t, "SELECT count(*) FROM audit_events WHERE action = 'add_hashtag' AND entity_id = $1", projectId); n != 1 {
+		t.Fatalf("%d add_hashtag audit events, want 1", n)
+	}
+	if events[0].Cause.Action != "add_hashtag" {
+		t.Fatalf("unexpected cause %+v", events[0].Cause)
+	}
+
+	changed, err = AddProjectHashtagTransaction(testInfo, projectId, hashtagId)
+	if err != nil || changed {
+		t.Fatalf("add hashtag again: changed %t, error %v", changed, err)
+	}
+	capture.expectNoEvents(t)
+
+	// Adding a user with the default role, then changing the role
+	changed, err = AddProjectUserTransaction(testInfo, projectId, memberId, "")
+	if err != nil || !changed {
+		t.Fatalf("add user: changed %t, error %v", changed, err)
+	}
+	events = capture.takeEvents(t, models.EventProjectUpserted, projectId)
+	expectStrings(t, "users", userNames(events[0].Doc), "Ada:owner", "Grace:contributor")
+
+	changed, err = AddProjectUserTransaction(testInfo, projectId, memberId, models.RoleMaintainer)
+	if err != nil || !changed {
+		t.Fatalf("change role: changed %t, error %v", changed, err)
+	}
+	events = capture.takeEvents(t, models.EventProjectUpserted, projectId)
+	expectStrings(t, "users", userNames(events[0].Doc), "Ada:owner", "Grace:maintainer")
+
+	// Missing users and the last owner are rejected without an event
+	_, err = AddProjectUserTransaction(testInfo, projectId, 999, "")
+	var missing *MissingReferencesError
+	if !errors.As(err, &missing) {
+		t.Fatalf("got error %v, want missing references", err)
+	}
+	_, err = RemoveProjectUserTransaction(testInfo, projectId, ownerId)
+	expectError(t, err, ErrNoProjectOwner)
+	if n := countRows(t, "SELECT count(*) FROM user_projects WHERE project_id = $1 AND user_id = $2", projectId, ownerId); n != 1 {
+		t.Fatal("rejected removal unlinked the owner")
+	}
+	capture.expectNoEvents(t)
+
+	// Removing links
+	changed, err = RemoveProjectUserTransaction(testInfo, projectId, memberId)
+	if err != nil || !changed {
+		t.Fatalf("remove user: changed %t, error %v", changed, err)
+	}
+	events = capture.takeEvents(t, models.EventProjectUpserted, projectId)
+	expectStrings(t, "users", userNames(events[0].Doc), "Ada:owner")
+
+	changed, err = RemoveProjectHashtagTransaction(testInfo, projectId, hashtagId)
+	if err != nil || !changed {
+		t.Fatalf("remove hashtag: changed %t, error %v", changed, err)
+	}
+	events = capture.takeEvents(t, models.EventProjectUpserted, projectId)
+	expectStrings(t, "hashtags", hashtagNames(events[0].Doc))
+
+	_, err = RemoveProjectHashtagTransaction(testInfo, projectId, hashtagId)
+	expectError(t, err, ErrLinkNotFound)
+	capture.expectNoEvents(t)
+
+	// Deleted projects cannot be changed
+	err = ProjectDeleteAndSyncTransaction(testInfo, projectId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	capture.take()
+	_, err = AddProjectHashtagTransaction(testInfo, projectId, hashtagId)
+	expectError(t, err, sql.ErrNoRows)
+	capture.expectNoEvents(t)
+}
+
+func TestProjectTransactionRollsBackWhenSyncFails(t *testing.T) {
+	capture := setupDB(t)
+
+	ownerId := createTestUser(t, "Ada")
+	capture.fail = errors.New("queue unavailable")
+
+	err := ProjectCreationAndSyncTransaction(testInfo, &models.Project{Name: "Compiler", UserIds: []int{ownerId}})
+	expectError(t, err, capture.fail)
+	if n := countRows(t, "SELECT count(*) FROM projects"); n != 0 {
+		t.Fatalf("%d projects stored although the sync failed", n)
+	}
+	if n := countRows(t, "SELECT count(*) FROM audit_events WHERE entity_type = 'project'"); n != 0 {
+		t.Fatalf("%d audit events stored although the sync failed", n)
+	}
+}
+
+func TestProjectPatchAndSyncTransactionKeepsLinks(t *testing.T) {
+	capture := setupDB(t)
+
+	ownerId := createTestUser(t, "Ada")
+	deletedUserId := createTestUser(t, "Grace")
+	deletedHashtagId := createTestHashtag(t, "go")
+	projectId := createTestProject(t, capture, "Compiler", []int{ownerId, deletedUserId}, []int{deletedHashtagId})
+
+	err := DeleteUserTransaction(testInfo, deletedUserId)
+	if err == nil {
+		err = DeleteHashtagTransaction(testInfo, deletedHashtagId)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	capture.take()
+
+	// A patch without links sees only the visible ones and leaves every link in place
+	err = ProjectPatchAndSyncTransaction(testInfo, projectId, ProjectLinks{}, func(current *models.Project) (*models.Project, error) {
+		if len(current.UserIds) != 1 || current.UserIds[0] != ownerId || len(current.HashtagIds) != 0 {
+			t.Errorf("patch got users %v and hashtags %v, want only user %d", current.UserIds, current.HashtagIds, ownerId)
+		}
+		if current.UserRoles[ownerId] != models.RoleOwner {
+			t.Errorf("patch got roles %v", current.UserRoles)
+		}
+		patched := *current
+		patched.Description = "Patched"
+		return &patched, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := countRows(t, "SELECT count(*) FROM user_projects WHERE project_id = $1", projectId); n != 2 {
+		t.Fatalf("project has %d user links after the patch, want 2", n)
+	}
+	if n := countRows(t, "SELECT count(*) FROM project_hashtags WHERE project_id = $1", projectId); n != 1 {
+		t.Fatalf("project has %d hashtag links after the patch, want 1", n)
+	}
+	events := capture.takeEvents(t, models.EventProjectUpserted, projectId)
+	if events[0].Doc.Description != "Patched" {
+		t.Fatalf("description %q, want Patched", events[0].Doc.Description)
+	}
+
+	// Restoring brings back the links the patch could not see
+	err = RestoreUserTransaction(testInfo, deletedUserId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	events = capture.takeEvents(t, models.EventProjectUpserted, projectId)
+	expectStrings(t, "users", userNames(events[0].Doc), "Ada:owner", "Grace:contributor")
+
+	// A patch of the users replaces them, an error of the patch rolls back
+	err = ProjectPatchAndSyncTransaction(testInfo, projectId, ProjectLinks{Users: true}, func(current *models.Project) (*models.Project, error) {
+		patched := *current
+		patched.UserIds = []int{deletedUserId}
+		return &patched, nil
+	})
+	expectError(t, err, ErrNoProjectOwner)
+	patchErr := errors.New("invalid patch")
+	err = ProjectPatchAndSyncTransaction(testInfo, projectId, AllProjectLinks, func(current *models.Project) (*models.Project, error) {
+		return nil, patchErr
+	})
+	expectError(t, err, patchErr)
+	capture.expectNoEvents(t)
+
+	// Deleted projects cannot be patched
+	err = ProjectDeleteAndSyncTransaction(testInfo, projectId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	capture.take()
+	err = ProjectPatchAndSyncTransaction(testInfo, projectId, ProjectLinks{}, func(current *models.Project) (*models.Project, error) {
+		t.Error("patch applied to a deleted project")
+		return current, nil
+	})
+	expectError(t, err, sql.ErrNoRows)
+}
+
+func TestProjectUpdateTransactionKeepsDeletedLinks(t *testing.T) {
+	capture := setupDB(t)
+
+	adaId := createTestUser(t, "Ada")
+	graceId := createTestUser(t, "Grace")
+	goId := createTestHashtag(t, "go")
+	rustId := createTestHashtag(t, "rust")
+	projectId := createTestProject(t, capture, "Compiler", []int{adaId, graceId}, []int{goId, rustId})
+
+	for _, err := range []error{
+		DeleteUserTransaction(testInfo, graceId),
+		DeleteHashtagTransaction(testInfo, rustId),
+	} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	capture.take()
+
+	// Replacing the links only replaces those of live users and hashtags
+	err := ProjectUpdateAndSyncTransaction(testInfo, &models.Project{ID: projectId, Name: "Compiler", UserIds: []int{adaId}, HashtagIds: []int{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	capture.take()
+
+	// Restoring brings the links back
+	err = RestoreUserTransaction(testInfo, graceId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = RestoreHashtagTransaction(testInfo, rustId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := capture.takeEvents(t, models.EventProjectUpserted, projectId, projectId)
+	expectStrings(t, "users", userNames(events[1].Doc), "Ada:owner", "Grace:contributor")
+	expectStrings(t, "hashtags", hashtagNames(events[1].Doc), "rust")
+}
+
+func TestDeletedOwnerDoesNotCount(t *testing.T) {
+	capture := setupDB(t)
+
+	adaId := createTestUser(t, "Ada")
+	graceId := createTestUser(t, "Grace")
+	projectId := createTestProject(t, capture, "Compiler", []int{adaId, graceId}, nil)
+	err := DeleteUserTransaction(testInfo, adaId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	capture.take()
+
+	// The link of the deleted owner is kept but does not make the project owned
+	err = ProjectUpdateAndSyncTransaction(testInfo, &models.Project{ID: projectId, Name: "Compiler", UserIds: []int{graceId}})
+	expectError(t, err, ErrNoProjectOwner)
+	_, err = AddProjectUserTransaction(testInfo, projectId, createTestUser(t, "Linus"), models.RoleViewer)
+	expectError(t, err, ErrNoProjectOwner)
+	capture.expectNoEvents(t)
+
+	// A live owner can take over
+	err = ProjectUpdateAndSyncTransaction(testInfo, &models.Project{ID: projectId, Name: "Compiler", UserIds: []int{graceId}, UserRoles: map[int]string{graceId: models.RoleOwner}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	capture.takeEvents(t, models.EventProjectUpserted, projectId)
+}
+
+func TestGetProjectLoadsLinks(t *testing.T) {
+	capture := setupDB(t)
+
+	adaId := createTestUser(t, "Ada")
+	hashtagId := createTestHashtag(t, "go")
+	projectId := createTestProject(t, capture, "Fold", []int{adaId}, []int{hashtagId})
+
+	var byId, bySlug models.Project
+	err := GetProjectById(projectId, &byId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = GetProjectBySlug("fold", &bySlug)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, project := range []models.Project{byId, bySlug} {
+		if !reflect.DeepEqual(project.UserIds, []int{adaId}) || !reflect.DeepEqual(project.HashtagIds, []int{hashtagId}) || project.UserRoles[adaId] != models.RoleOwner {
+			t.Fatalf("project %d has users %v with roles %v and hashtags %v", project.ID, project.UserIds, project.UserRoles, project.HashtagIds)
+		}
+	}
+}
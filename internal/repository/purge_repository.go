@@ -0,0 +1,83 @@
+package repository
+
+import (
+	"fold/internal/database"
+	"time"
+)
+
+// PurgeResult counts the rows hard-deleted by a purge.
+type PurgeResult struct {
+	Users    int64
+	Hashtags int64
+	Projects int64
+}
+
+// PurgeDeletedTransaction hard-deletes users, hashtags and projects soft-deleted before cutoff,
+// together with their links, aliases and slug history. Their search documents are already
+// gone or hidden, so no sync events are sent.
+func PurgeDeletedTransaction(cutoff time.Time) (PurgeResult, error) {
+	var result PurgeResult
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return result, err
+	}
+
+	// Record the purged rows in the audit log.
+	for _, entity := range []struct{ entityType, table string }{
+		{AuditEntityProject, "projects"},
+		{AuditEntityUser, "users"},
+		{AuditEntityHashtag, "hashtags"},
+	} {
+		err = RecordPurgeAuditEvents(tx, entity.entityType, entity.table, cutoff)
+		if err != nil {
+			tx.Rollback()
+			return result, err
+		}
+	}
+
+	// Remove links, aliases and slug history of the purged rows first.
+	queries := []string{
+		`DELETE FROM user_projects WHERE
+			project_id IN (SELECT id FROM projects WHERE deleted_at < $1) OR
+			user_id IN (SELECT id FROM users WHERE deleted_at < $1)`,
+		`DELETE FROM project_hashtags WHERE
+			project_id IN (SELECT id FROM projects WHERE deleted_at < $1) OR
+			hashtag_id IN (SELECT id FROM hashtags WHERE deleted_at < $1)`,
+		`DELETE FROM project_slugs WHERE project_id IN (SELECT id FROM projects WHERE deleted_at < $1)`,
+		`DELETE FROM hashtag_aliases WHERE hashtag_id IN (SELECT id FROM hashtags WHERE deleted_at < $1)`,
+	}
+
+	for _, query := range queries {
+		_, err = tx.Exec(query, cutoff)
+		if err != nil {
+			tx.Rollback()
+			return result, err
+		}
+	}
+
+	// Delete the rows themselves.
+	counts := []struct {
+		query string
+		count *int64
+	}{
+		{"DELETE FROM projects WHERE deleted_at < $1", &result.Projects},
+		{"DELETE FROM users WHERE deleted_at < $1", &result.Users},
+		{"DELETE FROM hashtags WHERE deleted_at < $1", &result.Hashtags},
+	}
+
+	for _, count := range counts {
+		res, err := tx.Exec(count.query, cutoff)
+		if err != nil {
+			tx.Rollback()
+			return result, err
+		}
+		*count.count, err = res.RowsAffected()
+		if err != nil {
+			tx.Rollback()
+			return result, err
+		}
+	}
+
+	return result, tx.Commit()
+}
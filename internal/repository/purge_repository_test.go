@@ -0,0 +1,64 @@
+package repository
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPurgeDeletedTransaction(t *testing.T) {
+	capture := setupDB(t)
+
+	ownerId := createTestUser(t, "Ada")
+	userId := createTestUser(t, "Grace")
+	hashtagId := createTestHashtag(t, "go", "golang")
+	keptId := createTestProject(t, capture, "Compiler", []int{ownerId, userId}, []int{hashtagId})
+	projectId := createTestProject(t, capture, "Debugger", []int{ownerId}, []int{hashtagId})
+
+	err := DeleteUserTransaction(testInfo, userId)
+	if err == nil {
+		err = DeleteHashtagTransaction(testInfo, hashtagId)
+	}
+	if err == nil {
+		err = ProjectDeleteAndSyncTransaction(testInfo, projectId)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	capture.take()
+
+	// Rows deleted after the cutoff are kept
+	result, err := PurgeDeletedTransaction(time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != (PurgeResult{}) {
+		t.Fatalf("purged %+v before the retention ended", result)
+	}
+
+	// Rows deleted before the cutoff are removed with their links and aliases, without a sync
+	result, err = PurgeDeletedTransaction(time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != (PurgeResult{Users: 1, Hashtags: 1, Projects: 1}) {
+		t.Fatalf("purged %+v, want one row of each", result)
+	}
+	for table, id := range map[string]int{"users": userId, "hashtags": hashtagId, "projects": projectId} {
+		if rowExists(t, table, id) {
+			t.Fatalf("%s %d still exists", table, id)
+		}
+	}
+	if n := countRows(t, "SELECT count(*) FROM hashtag_aliases"); n != 0 {
+		t.Fatalf("%d aliases left after the purge", n)
+	}
+	if n := countRows(t, "SELECT count(*) FROM user_projects WHERE project_id = $1", keptId); n != 1 {
+		t.Fatalf("kept project has %d user links, want 1", n)
+	}
+	if n := countRows(t, "SELECT count(*) FROM project_hashtags WHERE project_id = $1", keptId); n != 0 {
+		t.Fatalf("kept project has %d hashtag links, want 0", n)
+	}
+	if n := countRows(t, "SELECT count(*) FROM audit_events WHERE action = 'purge' AND before IS NOT NULL"); n != 3 {
+		t.Fatalf("%d purge audit events, want 3", n)
+	}
+	capture.expectNoEvents(t)
+}
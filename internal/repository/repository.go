@@ -0,0 +1,66 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fold/internal/services"
+
+	"github.com/lib/pq"
+)
+
+var ErrLinkNotFound = errors.New("link between project and user or hashtag does not exist")
+
+var ErrNoProjectOwner = errors.New("project must have at least one owner")
+
+// sendSyncEvent and sendSyncEvents publish sync events to the queue. Tests replace them to
+// capture the events of a transaction.
+var (
+	sendSyncEvent  = services.SQS
+	sendSyncEvents = services.SQSBatch
+)
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// queryer is implemented by both *sql.DB and *sql.Tx.
+type queryer interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// requireRowsAffected turns a statement that matched no rows into sql.ErrNoRows.
+func requireRowsAffected(result sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// deletedFilter restricts list queries to rows that are not soft-deleted unless includeDeleted is set.
+func deletedFilter(column string, includeDeleted bool) string {
+	if includeDeleted {
+		return "TRUE"
+	}
+	return column + " IS NULL"
+}
+
+// isUniqueViolation reports whether err is a violation of the named unique index.
+func isUniqueViolation(err error, constraint string) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505" && pqErr.Constraint == constraint
+}
+
+// IsDeadlock reports whether err aborted a transaction to resolve a deadlock with a concurrent one.
+// The transaction was rolled back and can be retried.
+func IsDeadlock(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "40P01"
+}
@@ -0,0 +1,244 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fold/internal/database"
+	"fold/internal/models"
+	"os"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+)
+
+// testDatabaseEnv names the URL of a disposable Postgres database for the integration tests.
+// Every test empties all tables, so never point it at a database with data worth keeping.
+const testDatabaseEnv = "TEST_POSTGRES_URL"
+
+var (
+	testDBOnce sync.Once
+	testDBErr  error
+)
+
+var testInfo = models.AuditInfo{Actor: "tester", RequestID: "req-test"}
+
+// syncCapture records the sync events published by transactions instead of sending them.
+// With fail set, publishing returns that error without recording anything.
+type syncCapture struct {
+	events []*models.SyncEvent
+	fail   error
+}
+
+// setupDB connects to the test database, empties it and captures the sync events of the test.
+// The test is skipped when no test database is configured.
+func setupDB(t *testing.T) *syncCapture {
+	t.Helper()
+
+	url := os.Getenv(testDatabaseEnv)
+	if url == "" {
+		t.Skipf("%s is not set", testDatabaseEnv)
+	}
+
+	testDBOnce.Do(func() {
+		database.DB, testDBErr = sql.Open("postgres", url)
+		if testDBErr == nil {
+			testDBErr = database.Migrate(database.DB)
+		}
+	})
+	if testDBErr != nil {
+		t.Fatalf("test database: %v", testDBErr)
+	}
+
+	_, err := database.DB.Exec(`TRUNCATE users, hashtags, projects, user_projects, project_hashtags, hashtag_aliases,
+		project_slugs, audit_events, idempotency_keys RESTART IDENTITY CASCADE`)
+	if err != nil {
+		t.Fatalf("empty test database: %v", err)
+	}
+
+	// Test the default fan-out of user and hashtag changes
+	t.Setenv("SYNC_RENAME_EVENTS", "false")
+
+	capture := &syncCapture{}
+	sendEvent, sendEvents := sendSyncEvent, sendSyncEvents
+	sendSyncEvent = func(event *models.SyncEvent) error {
+		if capture.fail != nil {
+			return capture.fail
+		}
+		capture.events = append(capture.events, event)
+		return nil
+	}
+	sendSyncEvents = func(events []*models.SyncEvent) error {
+		if capture.fail != nil {
+			return capture.fail
+		}
+		capture.events = append(capture.events, events...)
+		return nil
+	}
+	t.Cleanup(func() {
+		sendSyncEvent, sendSyncEvents = sendEvent, sendEvents
+	})
+	return capture
+}
+
+// take returns the captured events and forgets them, so each step of a test checks its own events.
+func (c *syncCapture) take() []*models.SyncEvent {
+	events := c.events
+	c.events = nil
+	return events
+}
+
+// takeEvents returns the captured events after checking that there is one event of eventType
+// per expected project, in that order, each with the document of its project.
+func (c *syncCapture) takeEvents(t *testing.T, eventType string, projectIds ...int) []*models.SyncEvent {
+	t.Helper()
+
+	events := c.take()
+	if len(events) != len(projectIds) {
+		t.Fatalf("got %d sync events, want %d for projects %v", len(events), len(projectIds), projectIds)
+	}
+
+	for i, event := range events {
+		if event.EventType != eventType {
+			t.Fatalf("event %d has type %q, want %q", i, event.EventType, eventType)
+		}
+		if event.SchemaVersion != models.SyncSchemaVersion {
+			t.Errorf("event %d has schema version %d, want %d", i, event.SchemaVersion, models.SyncSchemaVersion)
+		}
+		if event.Doc == nil {
+			t.Fatalf("event %d has no document", i)
+		}
+		if event.Doc.ID != projectIds[i] {
+			t.Fatalf("event %d is for project %d, want %d", i, event.Doc.ID, projectIds[i])
+		}
+	}
+	return events
+}
+
+// sortByProject orders the captured events by project ID, for transactions that sync their
+// projects in no particular order.
+func (c *syncCapture) sortByProject() {
+	sort.SliceStable(c.events, func(i, j int) bool { return c.events[i].Doc.ID < c.events[j].Doc.ID })
+}
+
+// expectNoEvents fails when a transaction published sync events.
+func (c *syncCapture) expectNoEvents(t *testing.T) {
+	t.Helper()
+	if events := c.take(); len(events) > 0 {
+		t.Fatalf("got %d unexpected sync events, first %s", len(events), events[0].EventType)
+	}
+}
+
+func createTestUser(t *testing.T, name string) int {
+	t.Helper()
+	user := models.User{Name: name}
+	err := CreateUserTransaction(testInfo, &user)
+	if err != nil {
+		t.Fatalf("create user %q: %v", name, err)
+	}
+	return user.ID
+}
+
+func createTestHashtag(t *testing.T, name string, aliases ...string) int {
+	t.Helper()
+	hashtag := models.Hashtag{Name: name, Aliases: aliases}
+	err := CreateHashtagTransaction(testInfo, &hashtag)
+	if err != nil {
+		t.Fatalf("create hashtag %q: %v", name, err)
+	}
+	return hashtag.ID
+}
+
+func createTestProject(t *testing.T, capture *syncCapture, name string, userIds []int, hashtagIds []int) int {
+	t.Helper()
+	project := models.Project{Name: name, Description: name + " description", UserIds: userIds, HashtagIds: hashtagIds}
+	err := ProjectCreationAndSyncTransaction(testInfo, &project)
+	if err != nil {
+		t.Fatalf("create project %q: %v", name, err)
+	}
+	capture.take()
+	return project.ID
+}
+
+// isDeleted reports whether the row exists and is soft-deleted, failing when it does not exist.
+func isDeleted(t *testing.T, table string, id int) bool {
+	t.Helper()
+	var deleted bool
+	err := database.DB.QueryRow("SELECT deleted_at IS NOT NULL FROM "+table+" WHERE id = $1", id).Scan(&deleted)
+	if err != nil {
+		t.Fatalf("%s %d: %v", table, id, err)
+	}
+	return deleted
+}
+
+func rowExists(t *testing.T, table string, id int) bool {
+	t.Helper()
+	var exists bool
+	err := database.DB.QueryRow("SELECT EXISTS(SELECT 1 FROM "+table+" WHERE id = $1)", id).Scan(&exists)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return exists
+}
+
+func countRows(t *testing.T, query string, args ...interface{}) int {
+	t.Helper()
+	return intColumn(t, query, args...)
+}
+
+func intColumn(t *testing.T, query string, args ...interface{}) int {
+	t.Helper()
+	var value int
+	err := database.DB.QueryRow(query, args...).Scan(&value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return value
+}
+
+func stringColumn(t *testing.T, query string, args ...interface{}) string {
+	t.Helper()
+	var value string
+	err := database.DB.QueryRow(query, args...).Scan(&value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return value
+}
+
+// userNames lists the names of the users of a document as "name:role", sorted.
+func userNames(doc *models.DenormalizedProject) []string {
+	names := []string{}
+	for _, user := range doc.Users {
+		names = append(names, user.Name+":"+user.Role)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// hashtagNames lists the names of the hashtags of a document, sorted.
+func hashtagNames(doc *models.DenormalizedProject) []string {
+	names := []string{}
+	for _, hashtag := range doc.Hashtags {
+		names = append(names, hashtag.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func expectStrings(t *testing.T, what string, got []string, want ...string) {
+	t.Helper()
+	if want == nil {
+		want = []string{}
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("%s = %q, want %q", what, got, want)
+	}
+}
+
+func expectError(t *testing.T, err error, target error) {
+	t.Helper()
+	if !errors.Is(err, target) {
+		t.Fatalf("got error %v, want %v", err, target)
+	}
+}
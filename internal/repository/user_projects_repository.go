@@ -3,14 +3,82 @@ package repository
 import (
 	"database/sql"
 	"fold/internal/database"
+	"fold/internal/models"
 	"log"
 )
 
-func CreateProjectUsers(tx *sql.Tx, projectId int, userId int) error {
-	_, err := tx.Exec("INSERT INTO user_projects(project_id, user_id) VALUES($1, $2)", projectId, userId)
+func CreateProjectUsers(tx *sql.Tx, projectId int, userId int, role string) error {
+	_, err := tx.Exec("INSERT INTO user_projects(project_id, user_id, role) VALUES($1, $2, $3)", projectId, userId, role)
 	return err
 }
 
+// AddProjectUser links a user to a project with the given role, or with the default role when
+// role is empty. An existing link keeps its role unless another one is given. It reports whether
+// anything changed.
+func AddProjectUser(tx *sql.Tx, projectId int, userId int, role string) (bool, error) {
+	result, err := tx.Exec(
+		`INSERT INTO user_projects(project_id, user_id, role) VALUES($1, $2, COALESCE(NULLIF($3, ''), $4))
+		ON CONFLICT (project_id, user_id) DO UPDATE SET role = EXCLUDED.role
+		WHERE $3 <> '' AND user_projects.role <> EXCLUDED.role`,
+		projectId, userId, role, models.DefaultRole)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
+
+// RemoveProjectUser unlinks a user from a project, returning ErrLinkNotFound when it was not linked.
+func RemoveProjectUser(tx *sql.Tx, projectId int, userId int) error {
+	err := requireRowsAffected(tx.Exec("DELETE FROM user_projects WHERE project_id = $1 AND user_id = $2", projectId, userId))
+	if err == sql.ErrNoRows {
+		return ErrLinkNotFound
+	}
+	return err
+}
+
+// GetProjectUserRoles returns the role of every non-deleted user of a project.
+func GetProjectUserRoles(projectId int) (map[int]string, error) {
+	return getProjectUserRoles(database.DB, projectId, false)
+}
+
+// getProjectUserRoles returns the role of every user linked to a project, leaving out
+// soft-deleted users unless includeDeleted is set.
+func getProjectUserRoles(q queryer, projectId int, includeDeleted bool) (map[int]string, error) {
+	rows, err := q.Query("SELECT up.user_id, up.role FROM user_projects up JOIN users u ON u.id = up.user_id WHERE up.project_id = $1 AND "+deletedFilter("u.deleted_at", includeDeleted), projectId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := make(map[int]string)
+	for rows.Next() {
+		var userId int
+		var role string
+		err := rows.Scan(&userId, &role)
+		if err != nil {
+			return nil, err
+		}
+		roles[userId] = role
+	}
+
+	return roles, rows.Err()
+}
+
+// RequireProjectOwner returns ErrNoProjectOwner when no user of the project is an owner. Soft-deleted
+// users do not count, as they cannot act on the project.
+func RequireProjectOwner(tx *sql.Tx, projectId int) error {
+	var hasOwner bool
+	err := tx.QueryRow("SELECT EXISTS(SELECT 1 FROM user_projects up JOIN users u ON u.id = up.user_id WHERE up.project_id = $1 AND up.role = $2 AND u.deleted_at IS NULL)", projectId, models.RoleOwner).Scan(&hasOwner)
+	if err != nil {
+		return err
+	}
+	if !hasOwner {
+		return ErrNoProjectOwner
+	}
+	return nil
+}
+
 func ExistsUserProjects(projectId int, userId int) bool {
 	var exists bool
 	err := database.DB.QueryRow("SELECT EXISTS(SELECT 1 FROM user_projects WHERE project_id = $1 AND user_id = $2)", projectId, userId).Scan(&exists)
@@ -21,7 +89,9 @@ func ExistsUserProjects(projectId int, userId int) bool {
 	return exists
 }
 
+// DeleteProjectUsers removes the links of a project to users that are not soft-deleted. Links to
+// soft-deleted users stay so restoring the user brings them back.
 func DeleteProjectUsers(tx *sql.Tx, projectId int) error {
-	_, err := tx.Exec("DELETE FROM user_projects WHERE project_id = $1", projectId)
+	_, err := tx.Exec("DELETE FROM user_projects up USING users u WHERE u.id = up.user_id AND up.project_id = $1 AND u.deleted_at IS NULL", projectId)
 	return err
 }
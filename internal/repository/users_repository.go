@@ -4,19 +4,26 @@ import (
 	"database/sql"
 	"fmt"
 	"fold/internal/database"
+	"fold/internal/events"
 	"fold/internal/models"
-	"time"
 )
 
-func CreateUser(user *models.User) error {
-	_, err := database.DB.Exec("INSERT INTO users (name, created_at) VALUES ($1, $2)", user.Name, time.Now())
-	return err
+// userColumns selects a full user row from users aliased as u.
+const userColumns = "u.id, u.name, u.created_at, u.updated_at, u.deleted_at"
+
+func scanUser(row rowScanner, user *models.User) error {
+	return row.Scan(&user.ID, &user.Name, &user.CreatedAt, &user.UpdatedAt, &user.DeletedAt)
+}
+
+func CreateUser(tx *sql.Tx, user *models.User) (int, error) {
+	err := tx.QueryRow("INSERT INTO users (name) VALUES ($1) RETURNING id, created_at, updated_at", user.Name).Scan(&user.ID, &user.CreatedAt, &user.UpdatedAt)
+	return user.ID, err
 }
 
-func GetAllUsers() ([]models.User, error) {
+func GetAllUsers(includeDeleted bool) ([]models.User, error) {
 	var users []models.User
 
-	rows, err := database.DB.Query("SELECT id, name, created_at FROM users")
+	rows, err := database.DB.Query("SELECT " + userColumns + " FROM users u WHERE " + deletedFilter("u.deleted_at", includeDeleted) + " ORDER BY u.id")
 	if err != nil {
 		return nil, err
 	}
@@ -24,7 +31,7 @@ func GetAllUsers() ([]models.User, error) {
 
 	for rows.Next() {
 		var user models.User
-		err := rows.Scan(&user.ID, &user.Name, &user.CreatedAt)
+		err := scanUser(rows, &user)
 		if err != nil {
 			return nil, err
 		}
@@ -39,13 +46,11 @@ func GetAllUsers() ([]models.User, error) {
 }
 
 func GetUserById(userId int, user *models.User) error {
-	err := database.DB.QueryRow("SELECT * FROM users WHERE id = $1", userId).Scan(&user.ID, &user.Name, &user.CreatedAt)
-	return err
+	return scanUser(database.DB.QueryRow("SELECT "+userColumns+" FROM users u WHERE u.id = $1 AND u.deleted_at IS NULL", userId), user)
 }
 
 func UpdateUser(tx *sql.Tx, user *models.User) error {
-	_, err := database.DB.Exec("UPDATE users SET name = $1 WHERE id = $2", user.Name, user.ID)
-	return err
+	return requireRowsAffected(tx.Exec("UPDATE users SET name = $1, updated_at = now() WHERE id = $2 AND deleted_at IS NULL", user.Name, user.ID))
 }
 
 func DeleteUser(tx *sql.Tx, userId int) error {
@@ -53,9 +58,17 @@ func DeleteUser(tx *sql.Tx, userId int) error {
 	return err
 }
 
+func SoftDeleteUser(tx *sql.Tx, userId int) error {
+	return requireRowsAffected(tx.Exec("UPDATE users SET deleted_at = now(), updated_at = now() WHERE id = $1 AND deleted_at IS NULL", userId))
+}
+
+func RestoreUser(tx *sql.Tx, userId int) error {
+	return requireRowsAffected(tx.Exec("UPDATE users SET deleted_at = NULL, updated_at = now() WHERE id = $1 AND deleted_at IS NOT NULL", userId))
+}
+
 func UserExists(userId int) bool {
 	var exists bool
-	err := database.DB.QueryRow("SELECT EXISTS(SELECT 1 FROM users WHERE id = $1)", userId).Scan(&exists)
+	err := database.DB.QueryRow("SELECT EXISTS(SELECT 1 FROM users WHERE id = $1 AND deleted_at IS NULL)", userId).Scan(&exists)
 	if err != nil {
 		fmt.Println(err)
 		return false
@@ -64,7 +77,7 @@ func UserExists(userId int) bool {
 }
 
 func GetUserProjectIds(tx *sql.Tx, userId int, projectIds *[]int) error {
-	rows, err := tx.Query("SELECT project_id FROM user_projects WHERE user_id = $1", userId)
+	rows, err := tx.Query("SELECT DISTINCT up.project_id FROM user_projects up JOIN projects p ON p.id = up.project_id WHERE up.user_id = $1 AND p.deleted_at IS NULL", userId)
 	if err != nil {
 		return err
 	}
@@ -87,13 +100,48 @@ func DeleteUserProjectIds(tx *sql.Tx, userId int) error {
 	return err
 }
 
-func UpdateUserTransaction(user *models.User) error {
+func CreateUserTransaction(info models.AuditInfo, user *models.User) error {
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return err
+	}
+
+	// Insert user into the database
+	err = applyUserCreate(tx, info, user)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// applyUserCreate inserts a user and records it in the audit log.
+func applyUserCreate(tx *sql.Tx, info models.AuditInfo, user *models.User) error {
+	var err error
+	user.ID, err = CreateUser(tx, user)
+	if err != nil {
+		return err
+	}
+
+	// Record the change in the audit log.
+	return RecordAuditChange(tx, info, AuditEntityUser, user.ID, "create", nil)
+}
+
+func UpdateUserTransaction(info models.AuditInfo, user *models.User) error {
 
 	tx, err := database.DB.Begin()
 	if err != nil {
 		return err
 	}
 
+	// Snapshot the user before the change for the audit log.
+	before, err := GetAuditSnapshot(tx, AuditEntityUser, user.ID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
 	//Update user in database
 	err = UpdateUser(tx, user)
 	if err != nil {
@@ -101,6 +149,13 @@ func UpdateUserTransaction(user *models.User) error {
 		return err
 	}
 
+	// Record the change in the audit log.
+	err = RecordAuditChange(tx, info, AuditEntityUser, user.ID, "update", before)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
 	//Get list of projectIds that need to be changed.
 	var projectIds []int
 	err = GetUserProjectIds(tx, user.ID, &projectIds)
@@ -109,10 +164,22 @@ func UpdateUserTransaction(user *models.User) error {
 		return err
 	}
 
+	// Describe the rename once instead of resending every project when consumers support it.
+	if renameEventsEnabled() {
+		if len(projectIds) > 0 {
+			err = syncUserRename(tx, info, user.ID)
+			if err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+		return tx.Commit()
+	}
+
 	//Sync Elastic Search for every project edited.
+	cause := fanOutCause(info, AuditEntityUser, user.ID, "update", len(projectIds))
 	for _, projectId := range projectIds {
-		fmt.Println("seomthing")
-		err = SyncElasticsearch(tx, projectId, "POST")
+		err = SyncElasticsearch(tx, projectId, models.EventProjectUpserted, cause)
 		if err != nil {
 			tx.Rollback()
 			return err
@@ -122,13 +189,34 @@ func UpdateUserTransaction(user *models.User) error {
 	return tx.Commit()
 }
 
-func DeleteUserTransaction(userId int) error {
+func DeleteUserTransaction(info models.AuditInfo, userId int) error {
 
 	tx, err := database.DB.Begin()
 	if err != nil {
 		return err
 	}
 
+	// Snapshot the user before the change for the audit log.
+	before, err := GetAuditSnapshot(tx, AuditEntityUser, userId)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	//Soft delete user in database
+	err = SoftDeleteUser(tx, userId)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	// Record the change in the audit log.
+	err = RecordAuditChange(tx, info, AuditEntityUser, userId, "delete", before)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
 	//Get list of projectIds that need to be changed.
 	var projectIds []int
 	err = GetUserProjectIds(tx, userId, &projectIds)
@@ -137,28 +225,80 @@ func DeleteUserTransaction(userId int) error {
 		return err
 	}
 
-	//Delete rows from user_projects
-	err = DeleteUserProjectIds(tx, userId)
+	//Sync Elastic Search for every project the user is hidden from.
+	cause := fanOutCause(info, AuditEntityUser, userId, "delete", len(projectIds))
+	for _, projectId := range projectIds {
+		err = SyncElasticsearch(tx, projectId, models.EventProjectUpserted, cause)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func RestoreUserTransaction(info models.AuditInfo, userId int) error {
+
+	tx, err := database.DB.Begin()
+	if err != nil {
+		return err
+	}
+
+	// Snapshot the user before the change for the audit log.
+	before, err := GetAuditSnapshot(tx, AuditEntityUser, userId)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	//Restore user in database
+	err = RestoreUser(tx, userId)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	// Record the change in the audit log.
+	err = RecordAuditChange(tx, info, AuditEntityUser, userId, "restore", before)
 	if err != nil {
 		tx.Rollback()
 		return err
 	}
 
-	//Sync Elastic Search for every project deleted.
+	//Get list of projectIds that need to be changed.
+	var projectIds []int
+	err = GetUserProjectIds(tx, userId, &projectIds)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	//Sync Elastic Search for every project the user is back in.
+	cause := fanOutCause(info, AuditEntityUser, userId, "restore", len(projectIds))
 	for _, projectId := range projectIds {
-		err = SyncElasticsearch(tx, projectId, "POST")
+		err = SyncElasticsearch(tx, projectId, models.EventProjectUpserted, cause)
 		if err != nil {
 			tx.Rollback()
 			return err
 		}
 	}
 
-	//Update user in database
-	err = DeleteUser(tx, userId)
+	return tx.Commit()
+}
+
+// syncUserRename sends a single user.renamed event that consumers apply to every project of the user.
+func syncUserRename(tx *sql.Tx, info models.AuditInfo, userId int) error {
+	var user models.User
+	err := scanUser(tx.QueryRow("SELECT "+userColumns+" FROM users u WHERE u.id = $1", userId), &user)
 	if err != nil {
-		tx.Rollback()
 		return err
 	}
 
-	return tx.Commit()
+	revision, err := nextRevision(tx, "users", userId)
+	if err != nil {
+		return err
+	}
+
+	return sendSyncEvent(events.UserRenamed(user, revision, eventCause(info, AuditEntityUser, userId, "update")))
 }
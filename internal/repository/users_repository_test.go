@@ -0,0 +1,130 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fold/internal/models"
+	"testing"
+)
+
+func TestUserTransactions(t *testing.T) {
+	capture := setupDB(t)
+
+	userId := createTestUser(t, "Ada")
+	ownerId := createTestUser(t, "Grace")
+	projectId := createTestProject(t, capture, "Compiler", []int{ownerId, userId}, nil)
+	otherId := createTestProject(t, capture, "Debugger", []int{ownerId}, nil)
+
+	// Update syncs every project of the user with a shared fan-out cause
+	err := UpdateUserTransaction(testInfo, &models.User{ID: userId, Name: "Ada Lovelace"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name := stringColumn(t, "SELECT name FROM users WHERE id = $1", userId); name != "Ada Lovelace" {
+		t.Fatalf("stored name %q, want Ada Lovelace", name)
+	}
+	events := capture.takeEvents(t, models.EventProjectUpserted, projectId)
+	expectStrings(t, "users", userNames(events[0].Doc), "Ada Lovelace:contributor", "Grace:owner")
+	cause := events[0].Cause
+	if cause == nil || cause.EntityType != AuditEntityUser || cause.EntityID != userId || cause.Action != "update" ||
+		cause.FanOut != 1 || cause.CorrelationID == "" || cause.RequestID != testInfo.RequestID {
+		t.Fatalf("unexpected cause %+v", cause)
+	}
+
+	// A user in several projects syncs all of them
+	err = UpdateUserTransaction(testInfo, &models.User{ID: ownerId, Name: "Grace Hopper"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	capture.sortByProject()
+	events = capture.takeEvents(t, models.EventProjectUpserted, projectId, otherId)
+	for _, event := range events {
+		if event.Cause.FanOut != 2 || event.Cause.CorrelationID != events[0].Cause.CorrelationID {
+			t.Fatalf("fan-out events do not share their cause: %+v", event.Cause)
+		}
+	}
+	expectStrings(t, "users", userNames(events[1].Doc), "Grace Hopper:owner")
+
+	// Delete hides the user from its projects but keeps the links
+	err = DeleteUserTransaction(testInfo, userId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isDeleted(t, "users", userId) {
+		t.Fatal("user is not soft-deleted")
+	}
+	if n := countRows(t, "SELECT count(*) FROM user_projects WHERE user_id = $1", userId); n != 1 {
+		t.Fatalf("user has %d project links after delete, want 1", n)
+	}
+	events = capture.takeEvents(t, models.EventProjectUpserted, projectId)
+	expectStrings(t, "users", userNames(events[0].Doc), "Grace Hopper:owner")
+
+	// Deleted users can neither be updated nor deleted again
+	err = UpdateUserTransaction(testInfo, &models.User{ID: userId, Name: "Ada"})
+	expectError(t, err, sql.ErrNoRows)
+	err = DeleteUserTransaction(testInfo, userId)
+	expectError(t, err, sql.ErrNoRows)
+	capture.expectNoEvents(t)
+
+	// Restore brings the user back into its projects
+	err = RestoreUserTransaction(testInfo, userId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if isDeleted(t, "users", userId) {
+		t.Fatal("user is still soft-deleted")
+	}
+	events = capture.takeEvents(t, models.EventProjectUpserted, projectId)
+	expectStrings(t, "users", userNames(events[0].Doc), "Ada Lovelace:contributor", "Grace Hopper:owner")
+
+	err = RestoreUserTransaction(testInfo, userId)
+	expectError(t, err, sql.ErrNoRows)
+}
+
+func TestUpdateUserTransactionSyncsNewName(t *testing.T) {
+	capture := setupDB(t)
+
+	userId := createTestUser(t, "Ada")
+	projectId := createTestProject(t, capture, "Compiler", []int{userId}, nil)
+
+	// The published document is built inside the transaction and has the new name
+	err := UpdateUserTransaction(testInfo, &models.User{ID: userId, Name: "Ada Lovelace"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := capture.takeEvents(t, models.EventProjectUpserted, projectId)
+	expectStrings(t, "users", userNames(events[0].Doc), "Ada Lovelace:owner")
+
+	// A failing sync rolls the rename back
+	capture.fail = errors.New("queue unavailable")
+	err = UpdateUserTransaction(testInfo, &models.User{ID: userId, Name: "Countess"})
+	expectError(t, err, capture.fail)
+	if name := stringColumn(t, "SELECT name FROM users WHERE id = $1", userId); name != "Ada Lovelace" {
+		t.Fatalf("name %q was stored although the sync failed", name)
+	}
+}
+
+func TestUpdateUserTransactionSendsRenameEvent(t *testing.T) {
+	capture := setupDB(t)
+	t.Setenv("SYNC_RENAME_EVENTS", "true")
+
+	userId := createTestUser(t, "Ada")
+	createTestProject(t, capture, "Compiler", []int{userId}, nil)
+	createTestProject(t, capture, "Debugger", []int{userId}, nil)
+
+	// One rename event replaces the fan-out to both projects
+	err := UpdateUserTransaction(testInfo, &models.User{ID: userId, Name: "Ada Lovelace"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := capture.take()
+	if len(events) != 1 || events[0].EventType != models.EventUserRenamed {
+		t.Fatalf("got %d sync events, want one %s", len(events), models.EventUserRenamed)
+	}
+	if user := events[0].User; user == nil || user.ID != userId || user.Name != "Ada Lovelace" || events[0].Doc != nil {
+		t.Fatalf("unexpected rename event %+v", events[0])
+	}
+	if events[0].Cause.EntityType != AuditEntityUser || events[0].Cause.Action != "update" {
+		t.Fatalf("unexpected cause %+v", events[0].Cause)
+	}
+}
@@ -2,31 +2,99 @@ package routes
 
 import (
 	"fold/internal/handlers"
+	"fold/internal/openapi"
 	"net/http"
 
 	"github.com/gorilla/mux"
 )
 
 func SetRouter() {
+	http.Handle("/", NewRouter())
+}
+
+// NewRouter builds the router with every route and middleware of the API.
+func NewRouter() *mux.Router {
 	// Create a new mux router
 	r := mux.NewRouter()
 
-	// Define routes
-	r.HandleFunc("/users", handlers.CreateUser).Methods("POST")                     // Create user
-	r.HandleFunc("/users/{id}", handlers.GetUser).Methods("GET")                    // Get user
-	r.HandleFunc("/users", handlers.GetAllUsers).Methods("GET")                     // Get all users
-	r.HandleFunc("/users/update/{id}", handlers.UpdateUser).Methods("POST")         // Update user
-	r.HandleFunc("/users/delete/{id}", handlers.DeleteUser).Methods("DELETE")       // Delete user
-	r.HandleFunc("/hashtags", handlers.CreateHashtag).Methods("POST")               // Create hashtag
-	r.HandleFunc("/hashtags/{id}", handlers.GetHashtag).Methods("GET")              // Get hashtag
-	r.HandleFunc("/hashtags", handlers.GetAllHashtags).Methods("GET")               // Get all hashtags
-	r.HandleFunc("/hashtags/update/{id}", handlers.UpdateHashtag).Methods("POST")   // Update hashtag
-	r.HandleFunc("/hashtags/delete/{id}", handlers.DeleteHashtag).Methods("DELETE") // Delete hastag
-	r.HandleFunc("/projects", handlers.CreateProject).Methods("POST")               // Create project
-	r.HandleFunc("/projects/{id}", handlers.GetProject).Methods("GET")              // Get project
-	r.HandleFunc("/projects", handlers.GetAllProjects).Methods("GET")               // Get all projects
-	r.HandleFunc("/projects/update/{id}", handlers.UpdateProject).Methods("POST")   // Update project
-	r.HandleFunc("/projects/delete/{id}", handlers.DeleteProject).Methods("DELETE") // Delete project
-
-	http.Handle("/", r)
+	// Serve the OpenAPI specification of the routes below
+	r.HandleFunc(openapi.Path, handlers.GetOpenAPI).Methods("GET")
+
+	// Define versioned RESTful routes
+	v1 := r.PathPrefix("/v1").Subrouter()
+	v1.HandleFunc("/users", handlers.CreateUser).Methods("POST")                                          // Create user
+	v1.HandleFunc("/users", handlers.GetAllUsers).Methods("GET")                                          // Get all users
+	v1.HandleFunc("/users/{id}", handlers.GetUser).Methods("GET")                                         // Get user
+	v1.HandleFunc("/users/{id}", handlers.UpdateUser).Methods("PUT")                                      // Replace user
+	v1.HandleFunc("/users/{id}", handlers.PatchUser).Methods("PATCH")                                     // Merge patch user
+	v1.HandleFunc("/users/{id}", handlers.DeleteUser).Methods("DELETE")                                   // Delete user
+	v1.HandleFunc("/users/{id}/restore", handlers.RestoreUser).Methods("POST")                            // Restore deleted user
+	v1.HandleFunc("/users/{id}/projects", handlers.GetUserProjects).Methods("GET")                        // Get projects of user
+	v1.HandleFunc("/hashtags", handlers.CreateHashtag).Methods("POST")                                    // Create hashtag
+	v1.HandleFunc("/hashtags", handlers.GetAllHashtags).Methods("GET")                                    // Get all hashtags
+	v1.HandleFunc("/hashtags/{id}", handlers.GetHashtag).Methods("GET")                                   // Get hashtag
+	v1.HandleFunc("/hashtags/{id}", handlers.ReplaceHashtag).Methods("PUT")                               // Replace hashtag
+	v1.HandleFunc("/hashtags/{id}", handlers.PatchHashtag).Methods("PATCH")                               // Merge patch hashtag
+	v1.HandleFunc("/hashtags/{id}", handlers.DeleteHashtag).Methods("DELETE")                             // Delete hashtag
+	v1.HandleFunc("/hashtags/{id}/restore", handlers.RestoreHashtag).Methods("POST")                      // Restore deleted hashtag
+	v1.HandleFunc("/hashtags/{id}/merge", handlers.MergeHashtag).Methods("POST")                          // Merge hashtag into another
+	v1.HandleFunc("/hashtags/{id}/projects", handlers.GetHashtagProjects).Methods("GET")                  // Get projects tagged with hashtag
+	v1.HandleFunc("/projects", handlers.CreateProject).Methods("POST")                                    // Create project
+	v1.HandleFunc("/projects/bulk", handlers.BulkProjects).Methods("POST")                                // Bulk create, update and delete projects
+	v1.HandleFunc("/projects", handlers.GetAllProjects).Methods("GET")                                    // Get all projects
+	v1.HandleFunc("/projects/{id}", handlers.GetProject).Methods("GET")                                   // Get project
+	v1.HandleFunc("/projects/{id}", handlers.UpdateProject).Methods("PUT")                                // Replace project
+	v1.HandleFunc("/projects/{id}", handlers.PatchProject).Methods("PATCH")                               // Merge patch project
+	v1.HandleFunc("/projects/{id}", handlers.DeleteProject).Methods("DELETE")                             // Delete project
+	v1.HandleFunc("/projects/{id}/restore", handlers.RestoreProject).Methods("POST")                      // Restore deleted project
+	v1.HandleFunc("/projects/{id}/users/{userId}", handlers.AddProjectUser).Methods("PUT")                // Add user to project
+	v1.HandleFunc("/projects/{id}/users/{userId}", handlers.RemoveProjectUser).Methods("DELETE")          // Remove user from project
+	v1.HandleFunc("/projects/{id}/hashtags/{hashtagId}", handlers.AddProjectHashtag).Methods("PUT")       // Tag project with hashtag
+	v1.HandleFunc("/projects/{id}/hashtags/{hashtagId}", handlers.RemoveProjectHashtag).Methods("DELETE") // Remove hashtag from project
+	v1.HandleFunc("/projects/by-slug/{slug}", handlers.GetProjectBySlug).Methods("GET")                   // Get project by slug
+	v1.HandleFunc("/import", handlers.ImportData).Methods("POST")                                         // Import users, hashtags or projects
+	v1.HandleFunc("/export/{entity}", handlers.ExportData).Methods("GET")                                 // Export users, hashtags or projects
+	v1.HandleFunc("/audit", handlers.GetAuditEvents).Methods("GET")                                       // Get audit history
+
+	// Define legacy routes, kept working but marked as deprecated
+	legacy := r.NewRoute().Subrouter()
+	legacy.Use(handlers.DeprecatedRouteMiddleware)
+	legacy.HandleFunc("/users", handlers.CreateUser).Methods("POST")                                          // Create user
+	legacy.HandleFunc("/users/{id}", handlers.GetUser).Methods("GET")                                         // Get user
+	legacy.HandleFunc("/users", handlers.GetAllUsers).Methods("GET")                                          // Get all users
+	legacy.HandleFunc("/users/update/{id}", handlers.UpdateUser).Methods("POST")                              // Update user
+	legacy.HandleFunc("/users/delete/{id}", handlers.DeleteUser).Methods("DELETE")                            // Delete user
+	legacy.HandleFunc("/users/{id}/restore", handlers.RestoreUser).Methods("POST")                            // Restore deleted user
+	legacy.HandleFunc("/users/{id}/projects", handlers.GetUserProjects).Methods("GET")                        // Get projects of user
+	legacy.HandleFunc("/hashtags", handlers.CreateHashtag).Methods("POST")                                    // Create hashtag
+	legacy.HandleFunc("/hashtags/{id}", handlers.GetHashtag).Methods("GET")                                   // Get hashtag
+	legacy.HandleFunc("/hashtags", handlers.GetAllHashtags).Methods("GET")                                    // Get all hashtags
+	legacy.HandleFunc("/hashtags/update/{id}", handlers.UpdateHashtag).Methods("POST")                        // Update hashtag
+	legacy.HandleFunc("/hashtags/delete/{id}", handlers.DeleteHashtag).Methods("DELETE")                      // Delete hastag
+	legacy.HandleFunc("/hashtags/{id}/restore", handlers.RestoreHashtag).Methods("POST")                      // Restore deleted hashtag
+	legacy.HandleFunc("/hashtags/{id}/merge", handlers.MergeHashtag).Methods("POST")                          // Merge hashtag into another
+	legacy.HandleFunc("/hashtags/{id}/projects", handlers.GetHashtagProjects).Methods("GET")                  // Get projects tagged with hashtag
+	legacy.HandleFunc("/projects", handlers.CreateProject).Methods("POST")                                    // Create project
+	legacy.HandleFunc("/projects/bulk", handlers.BulkProjects).Methods("POST")                                // Bulk create, update and delete projects
+	legacy.HandleFunc("/projects/{id}", handlers.GetProject).Methods("GET")                                   // Get project
+	legacy.HandleFunc("/projects/by-slug/{slug}", handlers.GetProjectBySlug).Methods("GET")                   // Get project by slug
+	legacy.HandleFunc("/projects", handlers.GetAllProjects).Methods("GET")                                    // Get all projects
+	legacy.HandleFunc("/projects/update/{id}", handlers.UpdateProject).Methods("POST")                        // Update project
+	legacy.HandleFunc("/projects/delete/{id}", handlers.DeleteProject).Methods("DELETE")                      // Delete project
+	legacy.HandleFunc("/projects/{id}/restore", handlers.RestoreProject).Methods("POST")                      // Restore deleted project
+	legacy.HandleFunc("/projects/{id}/users/{userId}", handlers.AddProjectUser).Methods("PUT")                // Add user to project
+	legacy.HandleFunc("/projects/{id}/users/{userId}", handlers.RemoveProjectUser).Methods("DELETE")          // Remove user from project
+	legacy.HandleFunc("/projects/{id}/hashtags/{hashtagId}", handlers.AddProjectHashtag).Methods("PUT")       // Tag project with hashtag
+	legacy.HandleFunc("/projects/{id}/hashtags/{hashtagId}", handlers.RemoveProjectHashtag).Methods("DELETE") // Remove hashtag from project
+	legacy.HandleFunc("/import", handlers.ImportData).Methods("POST")                                         // Import users, hashtags or projects
+	legacy.HandleFunc("/export/{entity}", handlers.ExportData).Methods("GET")                                 // Export users, hashtags or projects
+	legacy.HandleFunc("/audit", handlers.GetAuditEvents).Methods("GET")                                       // Get audit history
+
+	// Tag every request with a request ID
+	r.Use(handlers.RequestIDMiddleware)
+
+	// Replay responses of write requests retried with the same Idempotency-Key
+	r.Use(handlers.IdempotencyMiddleware)
+
+	return r
 }
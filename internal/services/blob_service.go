@@ -0,0 +1,126 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// BlobStore keeps payloads too large for a queue message. Put returns a location URL that Get
+// accepts, s3://bucket/key or file:///dir/key.
+type BlobStore interface {
+	Put(ctx context.Context, key string, data []byte) (string, error)
+	Get(ctx context.Context, location string) ([]byte, error)
+}
+
+// NewBlobStore configures the blob store from SYNC_BLOB_BUCKET, an S3 bucket, or SYNC_BLOB_DIR,
+// a local directory standing in for it. It returns nil when neither is set.
+func NewBlobStore() BlobStore {
+	if bucket := os.Getenv("SYNC_BLOB_BUCKET"); bucket != "" {
+		return &S3BlobStore{Bucket: bucket}
+	}
+	if dir := os.Getenv("SYNC_BLOB_DIR"); dir != "" {
+		return &DirBlobStore{Dir: dir}
+	}
+	return nil
+}
+
+// blobStoreFor picks the store that can read a location, whatever store is configured for writing.
+func blobStoreFor(location string) (BlobStore, error) {
+	switch {
+	case strings.HasPrefix(location, "s3://"):
+		return &S3BlobStore{}, nil
+	case strings.HasPrefix(location, "file://"):
+		return &DirBlobStore{}, nil
+	default:
+		return nil, fmt.Errorf("unsupported payload location %q", location)
+	}
+}
+
+// S3BlobStore stores blobs in an S3 bucket.
+type S3BlobStore struct {
+	Bucket string
+}
+
+func newS3Client(ctx context.Context) (*s3.Client, error) {
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		fmt.Println("Error loading AWS config:", err)
+		return nil, err
+	}
+	return s3.NewFromConfig(cfg), nil
+}
+
+func (store *S3BlobStore) Put(ctx context.Context, key string, data []byte) (string, error) {
+	client, err := newS3Client(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(store.Bucket),
+		Key:    aws.String(key),
+		Body:   bytes.NewReader(data),
+	})
+	if err != nil {
+		return "", err
+	}
+	return "s3://" + store.Bucket + "/" + key, nil
+}
+
+func (store *S3BlobStore) Get(ctx context.Context, location string) ([]byte, error) {
+	bucket, key, ok := strings.Cut(strings.TrimPrefix(location, "s3://"), "/")
+	if !ok {
+		return nil, fmt.Errorf("invalid S3 location %q", location)
+	}
+
+	client, err := newS3Client(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	output, err := client.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)})
+	if err != nil {
+		return nil, err
+	}
+	defer output.Body.Close()
+	return io.ReadAll(output.Body)
+}
+
+// DirBlobStore stores blobs as files below a local directory.
+type DirBlobStore struct {
+	Dir string
+}
+
+func (store *DirBlobStore) Put(ctx context.Context, key string, data []byte) (string, error) {
+	path, err := filepath.Abs(filepath.Join(store.Dir, filepath.FromSlash(key)))
+	if err != nil {
+		return "", err
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return "", err
+	}
+	err = os.WriteFile(path, data, 0644)
+	if err != nil {
+		return "", err
+	}
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(path)}).String(), nil
+}
+
+func (store *DirBlobStore) Get(ctx context.Context, location string) ([]byte, error) {
+	parsed, err := url.Parse(location)
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadFile(filepath.FromSlash(parsed.Path))
+}
@@ -1,9 +1,63 @@
 import json
 import http.client
 import base64
+import gzip
 import ssl
 import os
 
+# Versioned sync events carry an event_type, unversioned payloads only the HTTP method.
+# Rename events update the user or hashtag in every project document containing it.
+EVENT_METHODS = {
+    "project.upserted": "POST",
+    "project.deleted": "DELETE",
+    "user.renamed": "RENAME",
+    "hashtag.renamed": "RENAME",
+}
+
+RENAME_SCRIPTS = {
+    "users": "for (def user : ctx._source.users) { if (user.id == params.id) { user.name = params.name; user.updated_at = params.updated_at; } }",
+    "hashtags": "for (def hashtag : ctx._source.hashtags) { if (hashtag.id == params.id) { hashtag.name = params.name; hashtag.aliases = params.aliases; hashtag.updated_at = params.updated_at; } }",
+}
+
+# The term matches users and hashtags stored as plain objects by the dynamic mapping, the nested
+# query those of a nested mapping. ignore_unmapped lets the query run on either mapping.
+def rename_query(message_body):
+    path = "users" if message_body['event_type'] == "user.renamed" else "hashtags"
+    params = message_body['user'] if path == "users" else message_body['hashtag']
+    term = {"term": {path + ".id": params['id']}}
+    return {
+        "query": {"bool": {
+            "should": [term, {"nested": {"path": path, "query": term, "ignore_unmapped": True}}],
+            "minimum_should_match": 1,
+        }},
+        "script": {"source": RENAME_SCRIPTS[path], "lang": "painless", "params": params},
+    }
+
+def event_method(message_body):
+    if 'schema_version' in message_body:
+        return EVENT_METHODS[message_body['event_type']]
+    return message_body['method']
+
+def record_attribute(record, name):
+    attribute = record.get('messageAttributes', {}).get(name)
+    return attribute['stringValue'] if attribute else None
+
+# Large events are gzipped, and the largest are kept in S3 with only their location on the queue.
+def decode_body(record):
+    data = record['body'].encode()
+    location = record_attribute(record, 'payload_location')
+    if location:
+        import boto3
+        bucket, key = location[len("s3://"):].split("/", 1)
+        data = boto3.client('s3').get_object(Bucket=bucket, Key=key)['Body'].read()
+
+    encoding = record_attribute(record, 'content_encoding')
+    if encoding == "gzip+base64":
+        data = gzip.decompress(base64.b64decode(data))
+    elif encoding == "gzip":
+        data = gzip.decompress(data)
+    return json.loads(data)
+
 def lambda_handler(event, context):
     response_body = "sync failed."
     url = "/projects/_doc"
@@ -14,10 +68,11 @@ def lambda_handler(event, context):
         for record in event['Records']:
             print(record)
             # Get the message body from the SQS record
-            message_body = json.loads(record['body']) # Extract the body field
-            doc_id = message_body['doc']['id'] # Extract project id
-            method = message_body['method'] # Extract method
-            url += ("/" + str(doc_id))
+            message_body = decode_body(record) # Extract the body field
+            method = event_method(message_body) # Extract method
+            if method != "RENAME":
+                doc_id = message_body['doc']['id'] # Extract project id
+                doc_url = url + "/" + str(doc_id)
             
             # Create an HTTP connection
             conn = http.client.HTTPSConnection("3.108.40.246", 9200, context=ssl._create_unverified_context())
@@ -32,15 +87,19 @@ def lambda_handler(event, context):
             
             # Send the request to index the data in Elasticsearch
             if(method == "POST"):
-                conn.request("POST", url, body= json.dumps(message_body['doc']), headers=headers)  # Convert message_body to JSON
+                conn.request("POST", doc_url, body= json.dumps(message_body['doc']), headers=headers)  # Convert message_body to JSON
+            elif(method == "RENAME"):
+                conn.request("POST", "/projects/_update_by_query", body= json.dumps(rename_query(message_body)), headers=headers)
             else:
-                conn.request("DELETE", url, headers=headers)
+                conn.request("DELETE", doc_url, headers=headers)
             
             response = conn.getresponse()
             
             if response.status == 200 or response.status == 201:
                 if(method == "POST") :
                     print("Data indexed successfully!")
+                elif(method == "RENAME") :
+                    print("Data renamed successfully!")
                 else : 
                     print ("Data deleted successfully")
                 response_body = "successfully synced the data on elasticsearch."
@@ -0,0 +1,150 @@
+package services
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+)
+
+// localVisibilityTimeout is the visibility timeout of in-process queues, the SQS default.
+const localVisibilityTimeout = 30 * time.Second
+
+// LocalQueuePrefix starts queue URLs served by an in-process queue instead of SQS, e.g.
+// local://sync-events. Such queues live in memory and are lost when the process exits.
+const LocalQueuePrefix = "local://"
+
+var (
+	localQueuesMu sync.Mutex
+	localQueues   = map[string]*localQueue{}
+)
+
+// localQueue is an in-process stand-in for an SQS FIFO queue with the same receive, visibility
+// timeout, message group and delete semantics. Messages are received in the order they were
+// sent, and no message of a group is received while another one of the group is in flight.
+type localQueue struct {
+	mu       sync.Mutex
+	messages []*localMessage
+	// sent is closed and replaced whenever a message is sent, waking up waiting receivers
+	sent chan struct{}
+}
+
+type localMessage struct {
+	Message
+	group     string
+	visibleAt time.Time
+}
+
+// localQueueFor returns the in-process queue of a local:// URL, creating it on first use, and
+// nil for any other URL.
+func localQueueFor(queueURL string) *localQueue {
+	if !strings.HasPrefix(queueURL, LocalQueuePrefix) {
+		return nil
+	}
+
+	localQueuesMu.Lock()
+	defer localQueuesMu.Unlock()
+	queue, ok := localQueues[queueURL]
+	if !ok {
+		queue = &localQueue{sent: make(chan struct{})}
+		localQueues[queueURL] = queue
+	}
+	return queue
+}
+
+// send appends a message to group, or to a group of its own when group is empty.
+func (q *localQueue) send(body string, attributes map[string]string, group string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if group == "" {
+		group = GenerateUniqueID()
+	}
+	q.messages = append(q.messages, &localMessage{
+		Message: Message{
+			ID:         GenerateUniqueID(),
+			Body:       body,
+			Attributes: attributes,
+		},
+		group: group,
+	})
+	close(q.sent)
+	q.sent = make(chan struct{})
+}
+
+// receive waits up to wait for visible messages and returns at most ten of them, hiding them
+// for visibilityTimeout. Groups with a hidden message are skipped until it is deleted or
+// becomes visible again.
+func (q *localQueue) receive(ctx context.Context, wait time.Duration, visibilityTimeout time.Duration) []Message {
+	if visibilityTimeout == 0 {
+		visibilityTimeout = localVisibilityTimeout
+	}
+	deadline := time.Now().Add(wait)
+
+	for {
+		q.mu.Lock()
+		now := time.Now()
+		var messages []Message
+		nextVisible := deadline
+		inFlight := map[string]bool{}
+		for _, message := range q.messages {
+			if message.visibleAt.After(now) {
+				inFlight[message.group] = true
+				if message.visibleAt.Before(nextVisible) {
+					nextVisible = message.visibleAt
+				}
+			}
+		}
+		for _, message := range q.messages {
+			if inFlight[message.group] {
+				continue
+			}
+			message.visibleAt = now.Add(visibilityTimeout)
+			message.ReceiveCount++
+			message.ReceiptHandle = GenerateUniqueID()
+			messages = append(messages, copyMessage(message.Message))
+			if len(messages) == maxSQSBatchSize {
+				break
+			}
+		}
+		sent := q.sent
+		q.mu.Unlock()
+
+		if len(messages) > 0 || !now.Before(deadline) {
+			return messages
+		}
+
+		// Wait for a new message, a hidden one becoming visible again or the end of the wait
+		timer := time.NewTimer(nextVisible.Sub(now))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil
+		case <-sent:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+// delete removes the message last received with the receipt handle.
+func (q *localQueue) delete(receiptHandle string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for i, message := range q.messages {
+		if message.ReceiptHandle == receiptHandle {
+			q.messages = append(q.messages[:i], q.messages[i+1:]...)
+			return
+		}
+	}
+}
+
+func copyMessage(message Message) Message {
+	attributes := make(map[string]string, len(message.Attributes))
+	for name, value := range message.Attributes {
+		attributes[name] = value
+	}
+	message.Attributes = attributes
+	return message
+}
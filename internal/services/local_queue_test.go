@@ -0,0 +1,138 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLocalQueueVisibility(t *testing.T) {
+	ctx := context.Background()
+	queue := &localQueue{sent: make(chan struct{})}
+	queue.send("first", map[string]string{"event_type": "project.upserted"}, "")
+	queue.send("second", nil, "")
+
+	// Both messages are received in order and hidden for the visibility timeout
+	messages := queue.receive(ctx, 0, 50*time.Millisecond)
+	if len(messages) != 2 || messages[0].Body != "first" || messages[1].Body != "second" {
+		t.Fatalf("received %+v, want first and second", messages)
+	}
+	for _, message := range messages {
+		if message.ReceiveCount != 1 || message.ReceiptHandle == "" {
+			t.Fatalf("message %q has receive count %d and receipt handle %q", message.Body, message.ReceiveCount, message.ReceiptHandle)
+		}
+	}
+	if hidden := queue.receive(ctx, 0, time.Second); len(hidden) != 0 {
+		t.Fatalf("received %d hidden messages", len(hidden))
+	}
+
+	// Received messages are copies, changing them does not change the queue
+	messages[0].Attributes["event_type"] = "changed"
+
+	// Deleting one keeps the other, which becomes visible again with a new receipt handle
+	firstHandle := messages[0].ReceiptHandle
+	queue.delete(messages[1].ReceiptHandle)
+	again := queue.receive(ctx, time.Second, time.Second)
+	if len(again) != 1 || again[0].Body != "first" {
+		t.Fatalf("received %+v after the timeout, want first", again)
+	}
+	if again[0].ReceiveCount != 2 || again[0].ReceiptHandle == firstHandle {
+		t.Fatalf("received again with count %d and handle %q", again[0].ReceiveCount, again[0].ReceiptHandle)
+	}
+	if again[0].Attributes["event_type"] != "project.upserted" {
+		t.Fatalf("attributes changed to %v", again[0].Attributes)
+	}
+
+	// An outdated receipt handle deletes nothing
+	queue.delete(firstHandle)
+	queue.delete(again[0].ReceiptHandle)
+	if len(queue.messages) != 0 {
+		t.Fatalf("queue has %d messages after the delete", len(queue.messages))
+	}
+}
+
+func TestLocalQueueMessageGroups(t *testing.T) {
+	ctx := context.Background()
+	queue := &localQueue{sent: make(chan struct{})}
+	queue.send("a1", nil, "a")
+	queue.send("b1", nil, "b")
+
+	// A group is skipped while one of its messages is in flight, other groups are not
+	first := queue.receive(ctx, 0, time.Minute)
+	if len(first) != 2 {
+		t.Fatalf("received %d messages, want a1 and b1", len(first))
+	}
+	queue.send("a2", nil, "a")
+	queue.send("c1", nil, "c")
+	queue.delete(first[1].ReceiptHandle)
+	queue.send("b2", nil, "b")
+	messages := queue.receive(ctx, 0, time.Minute)
+	if len(messages) != 2 || messages[0].Body != "c1" || messages[1].Body != "b2" {
+		t.Fatalf("received %+v while a1 is in flight, want c1 and b2", messages)
+	}
+
+	// Once the message in flight is deleted, the rest of its group is received in order
+	queue.delete(first[0].ReceiptHandle)
+	messages = queue.receive(ctx, 0, time.Minute)
+	if len(messages) != 1 || messages[0].Body != "a2" {
+		t.Fatalf("received %+v after deleting a1, want a2", messages)
+	}
+}
+
+func TestLocalQueueReceiveLimits(t *testing.T) {
+	tests := []struct {
+		name string
+		sent int
+		want []int
+	}{
+		{name: "empty queue", sent: 0, want: []int{0}},
+		{name: "fewer than a batch", sent: 3, want: []int{3, 0}},
+		{name: "more than a batch", sent: 15, want: []int{maxSQSBatchSize, 5, 0}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			queue := &localQueue{sent: make(chan struct{})}
+			for i := 0; i < test.sent; i++ {
+				queue.send("message", nil, "")
+			}
+			for i, want := range test.want {
+				if got := queue.receive(context.Background(), 0, time.Minute); len(got) != want {
+					t.Fatalf("receive %d got %d messages, want %d", i+1, len(got), want)
+				}
+			}
+		})
+	}
+}
+
+func TestLocalQueueWait(t *testing.T) {
+	queue := &localQueue{sent: make(chan struct{})}
+
+	// A waiting receive returns as soon as a message is sent
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		queue.send("late", nil, "")
+	}()
+	start := time.Now()
+	messages := queue.receive(context.Background(), 5*time.Second, time.Minute)
+	if len(messages) != 1 || time.Since(start) > 2*time.Second {
+		t.Fatalf("received %d messages after %v", len(messages), time.Since(start))
+	}
+
+	// A cancelled receive returns at once
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if messages := queue.receive(ctx, 5*time.Second, time.Minute); len(messages) != 0 {
+		t.Fatalf("cancelled receive got %d messages", len(messages))
+	}
+}
+
+func TestLocalQueueFor(t *testing.T) {
+	if localQueueFor("https://sqs.ap-south-1.amazonaws.com/1/queue.fifo") != nil {
+		t.Fatal("SQS URL was served by a local queue")
+	}
+	queue := localQueueFor(LocalQueuePrefix + "TestLocalQueueFor")
+	if queue == nil || localQueueFor(LocalQueuePrefix+"TestLocalQueueFor") != queue {
+		t.Fatal("local URL does not keep its queue")
+	}
+}
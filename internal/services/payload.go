@@ -0,0 +1,161 @@
+package services
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"fold/internal/config"
+	"fold/internal/models"
+	"io"
+
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+const (
+	// maxSQSMessageBytes is the SQS limit for the body and attributes of a message, and for a whole batch.
+	maxSQSMessageBytes = 256 * 1024
+	// messageAttributesReserve leaves room for the attributes of a message next to its body.
+	messageAttributesReserve = 2 * 1024
+	// defaultCompressThreshold is the body size above which events are compressed.
+	defaultCompressThreshold = 64 * 1024
+)
+
+// Message attributes describing how the event of a message is encoded.
+const (
+	ContentEncodingAttribute = "content_encoding"
+	PayloadLocationAttribute = "payload_location"
+
+	// EncodingGzipBase64 is a gzipped event in the message body, base64-encoded to stay valid text.
+	EncodingGzipBase64 = "gzip+base64"
+	// EncodingGzip is a gzipped event in the blob store.
+	EncodingGzip = "gzip"
+)
+
+// offloadedEvent is the body of a message whose event is kept in the blob store.
+type offloadedEvent struct {
+	SchemaVersion   int    `json:"schema_version"`
+	EventID         string `json:"event_id"`
+	EventType       string `json:"event_type"`
+	PayloadLocation string `json:"payload_location"`
+}
+
+// encodeEvent turns an event into a message body that fits the queue, with the attributes
+// describing it. Events over SYNC_COMPRESS_THRESHOLD bytes are gzipped and base64-encoded. When
+// that is still too large, the gzipped event goes to the blob store and the message only points to it.
+func encodeEvent(ctx context.Context, event *models.SyncEvent) (string, map[string]types.MessageAttributeValue, error) {
+	attributes := eventAttributes(event)
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		fmt.Println("Error marshaling JSON:", err)
+		return "", nil, err
+	}
+	if len(data) <= compressThreshold() {
+		return string(data), attributes, nil
+	}
+
+	compressed, err := gzipBytes(data)
+	if err != nil {
+		return "", nil, err
+	}
+	encoded := base64.StdEncoding.EncodeToString(compressed)
+	if len(encoded) <= maxSQSMessageBytes-messageAttributesReserve {
+		attributes[ContentEncodingAttribute] = stringAttribute(EncodingGzipBase64)
+		return encoded, attributes, nil
+	}
+
+	// Offload the event and send a pointer to it
+	store := NewBlobStore()
+	if store == nil {
+		return "", nil, fmt.Errorf("event %s is %d bytes compressed, too large for the queue; set SYNC_BLOB_BUCKET or SYNC_BLOB_DIR to offload it", event.EventID, len(encoded))
+	}
+	location, err := store.Put(ctx, "sync-events/"+event.EventID+".json.gz", compressed)
+	if err != nil {
+		return "", nil, err
+	}
+
+	pointer, err := json.Marshal(offloadedEvent{
+		SchemaVersion:   event.SchemaVersion,
+		EventID:         event.EventID,
+		EventType:       event.EventType,
+		PayloadLocation: location,
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	attributes[ContentEncodingAttribute] = stringAttribute(EncodingGzip)
+	attributes[PayloadLocationAttribute] = stringAttribute(location)
+	return string(pointer), attributes, nil
+}
+
+// compressThreshold is SYNC_COMPRESS_THRESHOLD, capped so that uncompressed events always fit
+// into a message next to their attributes.
+func compressThreshold() int {
+	return min(config.Int("SYNC_COMPRESS_THRESHOLD", defaultCompressThreshold), maxSQSMessageBytes-messageAttributesReserve)
+}
+
+// DecodeMessage returns the JSON event of a received message, loading it from the blob store and
+// decompressing it as the message attributes say.
+func DecodeMessage(ctx context.Context, message Message) ([]byte, error) {
+	data := []byte(message.Body)
+	if location := message.Attributes[PayloadLocationAttribute]; location != "" {
+		store, err := blobStoreFor(location)
+		if err != nil {
+			return nil, err
+		}
+		data, err = store.Get(ctx, location)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	switch encoding := message.Attributes[ContentEncodingAttribute]; encoding {
+	case "":
+		return data, nil
+	case EncodingGzipBase64:
+		compressed, err := base64.StdEncoding.DecodeString(string(data))
+		if err != nil {
+			return nil, err
+		}
+		return gunzipBytes(compressed)
+	case EncodingGzip:
+		return gunzipBytes(data)
+	default:
+		return nil, fmt.Errorf("unsupported content encoding %q", encoding)
+	}
+}
+
+// messageSize estimates the size SQS counts for a message: its body and its attributes.
+func messageSize(body string, attributes map[string]types.MessageAttributeValue) int {
+	size := len(body)
+	for name, value := range attributes {
+		size += len(name) + len(*value.DataType) + len(*value.StringValue)
+	}
+	return size
+}
+
+func gzipBytes(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	_, err := writer.Write(data)
+	if err != nil {
+		return nil, err
+	}
+	err = writer.Close()
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func gunzipBytes(data []byte) ([]byte, error) {
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
@@ -0,0 +1,122 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fold/internal/models"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// testEvent is an upsert event whose description has size bytes, random enough to barely
+// compress when random is set.
+func testEvent(t *testing.T, size int, random bool) *models.SyncEvent {
+	t.Helper()
+	description := strings.Repeat("a", size)
+	if random {
+		data := make([]byte, size*3/4)
+		_, err := rand.Read(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		description = base64.StdEncoding.EncodeToString(data)
+	}
+	return &models.SyncEvent{
+		SchemaVersion: models.SyncSchemaVersion,
+		EventID:       "00000000-0000-0000-0000-000000000001",
+		EventType:     models.EventProjectUpserted,
+		Doc:           &models.DenormalizedProject{ID: 42, Name: "Fold", Description: description},
+	}
+}
+
+func TestEncodeEventRoundTrip(t *testing.T) {
+	tests := []struct {
+		name      string
+		size      int
+		random    bool
+		threshold string
+		encoding  string
+		offloaded bool
+	}{
+		{name: "small event stays raw", size: 100},
+		{name: "event over the threshold is compressed", size: 100 * 1024, encoding: EncodingGzipBase64},
+		{name: "lower threshold", size: 2 * 1024, threshold: "1024", encoding: EncodingGzipBase64},
+		{name: "threshold above the message limit is capped", size: 300 * 1024, threshold: strconv.Itoa(1 << 30), encoding: EncodingGzipBase64},
+		{name: "incompressible event is offloaded", size: 300 * 1024, random: true, encoding: EncodingGzip, offloaded: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			t.Setenv("SYNC_BLOB_BUCKET", "")
+			t.Setenv("SYNC_BLOB_DIR", dir)
+			t.Setenv("SYNC_COMPRESS_THRESHOLD", test.threshold)
+
+			event := testEvent(t, test.size, test.random)
+			want, err := json.Marshal(event)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			body, attributes, err := encodeEvent(context.Background(), event)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if size := messageSize(body, attributes); size > maxSQSMessageBytes {
+				t.Fatalf("message is %d bytes, over the limit of %d", size, maxSQSMessageBytes)
+			}
+
+			message := Message{Body: body, Attributes: attributeStrings(attributes)}
+			if got := message.Attributes[ContentEncodingAttribute]; got != test.encoding {
+				t.Fatalf("content encoding %q, want %q", got, test.encoding)
+			}
+			location := message.Attributes[PayloadLocationAttribute]
+			if test.offloaded {
+				if !strings.HasPrefix(location, "file://") {
+					t.Fatalf("payload location %q, want a file in the blob directory", location)
+				}
+				if _, err := os.Stat(filepath.Join(dir, "sync-events", event.EventID+".json.gz")); err != nil {
+					t.Fatal(err)
+				}
+				var pointer offloadedEvent
+				err = json.Unmarshal([]byte(body), &pointer)
+				if err != nil || pointer.EventID != event.EventID || pointer.PayloadLocation != location {
+					t.Fatalf("offloaded message body %s", body)
+				}
+			} else if location != "" {
+				t.Fatalf("event was offloaded to %s", location)
+			}
+
+			got, err := DecodeMessage(context.Background(), message)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Fatalf("decoded event differs from the sent one")
+			}
+		})
+	}
+}
+
+func TestEncodeEventWithoutBlobStore(t *testing.T) {
+	t.Setenv("SYNC_BLOB_BUCKET", "")
+	t.Setenv("SYNC_BLOB_DIR", "")
+
+	_, _, err := encodeEvent(context.Background(), testEvent(t, 300*1024, true))
+	if err == nil || !strings.Contains(err.Error(), "SYNC_BLOB_DIR") {
+		t.Fatalf("got error %v, want a hint to configure a blob store", err)
+	}
+}
+
+func TestDecodeMessageRejectsUnknownEncoding(t *testing.T) {
+	_, err := DecodeMessage(context.Background(), Message{Body: "{}", Attributes: map[string]string{ContentEncodingAttribute: "br"}})
+	if err == nil {
+		t.Fatal("unknown content encoding was accepted")
+	}
+}
@@ -2,47 +2,68 @@ package services
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"fold/internal/models"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/google/uuid"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
 )
 
-func SQS(payload *models.Payload) error {
+// maxSQSBatchSize is the largest number of messages SQS accepts in one SendMessageBatch call.
+const maxSQSBatchSize = 10
+
+// SyncMessageGroup is the FIFO message group of all sync events, keeping them in order.
+const SyncMessageGroup = "sync-elastic"
+
+func newSQSClient() (*sqs.Client, error) {
 	// Load AWS configuration
 	cfg, err := config.LoadDefaultConfig(context.TODO())
 	if err != nil {
 		fmt.Println("Error loading AWS config:", err)
-		return err
+		return nil, err
 	}
 
 	// Create an SQS client
-	client := sqs.NewFromConfig(cfg)
+	return sqs.NewFromConfig(cfg), nil
+}
 
+func SQS(event *models.SyncEvent) error {
 	queueURL := os.Getenv("SQS_QUEUE_URL")
 
-	jsonBytes, err := json.Marshal(payload)
+	body, attributes, err := encodeEvent(context.TODO(), event)
+	if err != nil {
+		return err
+	}
+
+	if queue := localQueueFor(queueURL); queue != nil {
+		queue.send(body, attributeStrings(attributes), SyncMessageGroup)
+		return nil
+	}
+
+	client, err := newSQSClient()
 	if err != nil {
-		fmt.Println("Error marshaling JSON:", err)
 		return err
 	}
 
 	// Create a new message metadata
-	messageGroupId := "sync-elastic"
+	messageGroupId := SyncMessageGroup
 	messageDeduplicationId := GenerateUniqueID()
 
 	// Send message to the SQS FIFO queue
 	sendMessageInput := &sqs.SendMessageInput{
 		QueueUrl:               aws.String(queueURL),
-		MessageBody:            aws.String(string(jsonBytes)),
+		MessageBody:            aws.String(body),
 		MessageGroupId:         aws.String(messageGroupId),
 		MessageDeduplicationId: aws.String(messageDeduplicationId),
+		MessageAttributes:      attributes,
 	}
 	_, err = client.SendMessage(context.TODO(), sendMessageInput)
 	if err != nil {
@@ -54,6 +75,222 @@ func SQS(payload *models.Payload) error {
 	return nil
 }
 
+// SQSBatch sends events to the SQS FIFO queue in batches of up to ten messages, keeping their order.
+// A batch is sent early when another message would take it over the SQS size limit.
+func SQSBatch(events []*models.SyncEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	queueURL := os.Getenv("SQS_QUEUE_URL")
+	if queue := localQueueFor(queueURL); queue != nil {
+		for _, event := range events {
+			body, attributes, err := encodeEvent(context.TODO(), event)
+			if err != nil {
+				return err
+			}
+			queue.send(body, attributeStrings(attributes), SyncMessageGroup)
+		}
+		return nil
+	}
+
+	client, err := newSQSClient()
+	if err != nil {
+		return err
+	}
+
+	messageGroupId := SyncMessageGroup
+
+	var entries []types.SendMessageBatchRequestEntry
+	batchSize := 0
+	send := func() error {
+		// Send the batch to the SQS FIFO queue
+		output, err := client.SendMessageBatch(context.TODO(), &sqs.SendMessageBatchInput{
+			QueueUrl: aws.String(queueURL),
+			Entries:  entries,
+		})
+		if err != nil {
+			fmt.Println("Error sending message batch:", err)
+			return err
+		}
+		if len(output.Failed) > 0 {
+			failed := output.Failed[0]
+			return fmt.Errorf("sending %d of %d messages failed, first: %s %s", len(output.Failed), len(entries), aws.ToString(failed.Code), aws.ToString(failed.Message))
+		}
+		entries = nil
+		batchSize = 0
+		return nil
+	}
+
+	for i, event := range events {
+		body, attributes, err := encodeEvent(context.TODO(), event)
+		if err != nil {
+			return err
+		}
+
+		size := messageSize(body, attributes)
+		if len(entries) == maxSQSBatchSize || (len(entries) > 0 && batchSize+size > maxSQSMessageBytes) {
+			err = send()
+			if err != nil {
+				return err
+			}
+		}
+
+		entries = append(entries, types.SendMessageBatchRequestEntry{
+			Id:                     aws.String(strconv.Itoa(i)),
+			MessageBody:            aws.String(body),
+			MessageGroupId:         aws.String(messageGroupId),
+			MessageDeduplicationId: aws.String(GenerateUniqueID()),
+			MessageAttributes:      attributes,
+		})
+		batchSize += size
+	}
+
+	err = send()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%d messages sent to SQS successfully!\n", len(events))
+	return nil
+}
+
+// eventAttributes exposes the schema version, type and cause of an event as message attributes,
+// so consumers can route messages without parsing their body.
+func eventAttributes(event *models.SyncEvent) map[string]types.MessageAttributeValue {
+	attributes := map[string]types.MessageAttributeValue{
+		"schema_version": {DataType: aws.String("Number"), StringValue: aws.String(strconv.Itoa(event.SchemaVersion))},
+		"event_type":     stringAttribute(event.EventType),
+	}
+	if event.Cause != nil {
+		attributes["cause_entity_type"] = stringAttribute(event.Cause.EntityType)
+		if event.Cause.CorrelationID != "" {
+			attributes["correlation_id"] = stringAttribute(event.Cause.CorrelationID)
+		}
+	}
+	return attributes
+}
+
+func stringAttribute(value string) types.MessageAttributeValue {
+	return types.MessageAttributeValue{DataType: aws.String("String"), StringValue: aws.String(value)}
+}
+
+// attributeStrings returns the values of message attributes, as a received message has them.
+func attributeStrings(attributes map[string]types.MessageAttributeValue) map[string]string {
+	values := make(map[string]string, len(attributes))
+	for name, value := range attributes {
+		values[name] = aws.ToString(value.StringValue)
+	}
+	return values
+}
+
+// Message is a message received from the sync queue.
+type Message struct {
+	ID            string
+	Body          string
+	ReceiptHandle string
+	Attributes    map[string]string
+	// ReceiveCount is how often the message was received, including this time.
+	ReceiveCount int
+}
+
+// numberAttributes are the message attributes sent with the Number data type.
+var numberAttributes = map[string]bool{"schema_version": true, "receive_count": true}
+
+// ReceiveSQS waits up to waitSeconds for messages on the queue and returns at most ten of them.
+// They stay hidden from other receivers for visibilityTimeout seconds, or the queue's default when it is zero.
+func ReceiveSQS(ctx context.Context, queueURL string, waitSeconds int32, visibilityTimeout int32) ([]Message, error) {
+	if queue := localQueueFor(queueURL); queue != nil {
+		return queue.receive(ctx, time.Duration(waitSeconds)*time.Second, time.Duration(visibilityTimeout)*time.Second), nil
+	}
+
+	client, err := newSQSClient()
+	if err != nil {
+		return nil, err
+	}
+
+	output, err := client.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
+		QueueUrl:              aws.String(queueURL),
+		MaxNumberOfMessages:   maxSQSBatchSize,
+		WaitTimeSeconds:       waitSeconds,
+		VisibilityTimeout:     visibilityTimeout,
+		AttributeNames:        []types.QueueAttributeName{types.QueueAttributeName(types.MessageSystemAttributeNameApproximateReceiveCount)},
+		MessageAttributeNames: []string{"All"},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	messages := make([]Message, 0, len(output.Messages))
+	for _, received := range output.Messages {
+		message := Message{
+			ID:            aws.ToString(received.MessageId),
+			Body:          aws.ToString(received.Body),
+			ReceiptHandle: aws.ToString(received.ReceiptHandle),
+			Attributes:    attributeStrings(received.MessageAttributes),
+		}
+		message.ReceiveCount, _ = strconv.Atoi(received.Attributes[string(types.MessageSystemAttributeNameApproximateReceiveCount)])
+		messages = append(messages, message)
+	}
+	return messages, nil
+}
+
+// SendRawSQS sends a message body with its attributes as is. On FIFO queues the message goes to
+// groupId, and to a group of its own when groupId is empty.
+func SendRawSQS(ctx context.Context, queueURL string, body string, attributes map[string]string, groupId string) error {
+	if queue := localQueueFor(queueURL); queue != nil {
+		queue.send(body, copyMessage(Message{Attributes: attributes}).Attributes, groupId)
+		return nil
+	}
+
+	client, err := newSQSClient()
+	if err != nil {
+		return err
+	}
+
+	input := &sqs.SendMessageInput{
+		QueueUrl:          aws.String(queueURL),
+		MessageBody:       aws.String(body),
+		MessageAttributes: map[string]types.MessageAttributeValue{},
+	}
+	for name, value := range attributes {
+		attribute := stringAttribute(value)
+		if numberAttributes[name] {
+			attribute.DataType = aws.String("Number")
+		}
+		input.MessageAttributes[name] = attribute
+	}
+	if strings.HasSuffix(queueURL, ".fifo") {
+		if groupId == "" {
+			groupId = GenerateUniqueID()
+		}
+		input.MessageGroupId = aws.String(groupId)
+		input.MessageDeduplicationId = aws.String(GenerateUniqueID())
+	}
+
+	_, err = client.SendMessage(ctx, input)
+	return err
+}
+
+// DeleteSQS removes a received message from the queue once it was processed.
+func DeleteSQS(ctx context.Context, queueURL string, receiptHandle string) error {
+	if queue := localQueueFor(queueURL); queue != nil {
+		queue.delete(receiptHandle)
+		return nil
+	}
+
+	client, err := newSQSClient()
+	if err != nil {
+		return err
+	}
+
+	_, err = client.DeleteMessage(ctx, &sqs.DeleteMessageInput{
+		QueueUrl:      aws.String(queueURL),
+		ReceiptHandle: aws.String(receiptHandle),
+	})
+	return err
+}
+
 func GenerateUniqueID() string {
 	id := uuid.New()
 	return id.String()
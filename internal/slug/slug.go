@@ -0,0 +1,59 @@
+package slug
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// MaxLength leaves room for a numeric collision suffix within the 200 character slug limit.
+const MaxLength = 190
+
+// fallback is used when a name contains no characters that can be transliterated.
+const fallback = "project"
+
+// transliterations covers Latin letters that do not decompose into a base letter and a mark.
+var transliterations = map[rune]string{
+	'ß': "ss", 'æ': "ae", 'œ': "oe", 'ø': "o", 'đ': "d", 'ð': "d",
+	'ł': "l", 'þ': "th", 'ı': "i", 'ŋ': "n", '&': " and ",
+}
+
+// Make builds a URL-safe slug from a name: accents are stripped, letters are lowercased
+// and every run of other characters becomes a single hyphen.
+func Make(name string) string {
+	var builder strings.Builder
+	pendingHyphen := false
+
+	for _, r := range norm.NFKD.String(strings.ToLower(name)) {
+		if unicode.Is(unicode.Mn, r) {
+			continue
+		}
+
+		text := string(r)
+		if replacement, ok := transliterations[r]; ok {
+			text = replacement
+		}
+
+		for _, c := range text {
+			if c < unicode.MaxASCII && (unicode.IsLetter(c) || unicode.IsDigit(c)) {
+				if pendingHyphen && builder.Len() > 0 {
+					builder.WriteByte('-')
+				}
+				pendingHyphen = false
+				builder.WriteRune(c)
+			} else {
+				pendingHyphen = true
+			}
+		}
+	}
+
+	slug := builder.String()
+	if len(slug) > MaxLength {
+		slug = strings.TrimRight(slug[:MaxLength], "-")
+	}
+	if slug == "" {
+		return fallback
+	}
+	return slug
+}
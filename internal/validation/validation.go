@@ -0,0 +1,180 @@
+package validation
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+var (
+	slugPattern    = regexp.MustCompile(`^[a-z0-9]+(?:-[a-z0-9]+)*$`)
+	hashtagPattern = regexp.MustCompile(`^#?[\p{L}\p{N}_]+$`)
+)
+
+// FieldError describes a single rule violation on a request field.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Errors collects every violation found on a request so they can be reported together.
+type Errors []FieldError
+
+func (e Errors) Error() string {
+	messages := make([]string, 0, len(e))
+	for _, fieldErr := range e {
+		messages = append(messages, fieldErr.Field+": "+fieldErr.Message)
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Validate checks the `validate` struct tags of v, which must be a pointer to a struct.
+// Supported rules are required, min=N, max=N, slug, hashtag, oneof=A B ... and dedupe. Slices tagged
+// with dedupe have duplicate entries removed in place before the other rules run.
+func Validate(v interface{}) error {
+	value := reflect.ValueOf(v)
+	if value.Kind() != reflect.Ptr || value.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("validation: expected pointer to struct, got %T", v)
+	}
+
+	var errs Errors
+	value = value.Elem()
+	structType := value.Type()
+
+	for i := 0; i < structType.NumField(); i++ {
+		field := structType.Field(i)
+		tag := field.Tag.Get("validate")
+		if tag == "" {
+			continue
+		}
+
+		name := fieldName(field)
+		fieldValue := value.Field(i)
+
+		for _, rule := range strings.Split(tag, ",") {
+			ruleName, arg, _ := strings.Cut(rule, "=")
+			message := applyRule(ruleName, arg, fieldValue)
+			if message != "" {
+				errs = append(errs, FieldError{Field: name, Message: message})
+				break
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func applyRule(rule string, arg string, value reflect.Value) string {
+	switch rule {
+	case "required":
+		if isEmpty(value) {
+			return "is required"
+		}
+	case "min":
+		limit, _ := strconv.Atoi(arg)
+		if value.Kind() == reflect.String && !isEmpty(value) && utf8.RuneCountInString(value.String()) < limit {
+			return fmt.Sprintf("must be at least %d characters", limit)
+		}
+		if value.Kind() == reflect.Slice && value.Len() < limit {
+			return fmt.Sprintf("must contain at least %d items", limit)
+		}
+	case "max":
+		limit, _ := strconv.Atoi(arg)
+		if value.Kind() == reflect.String && utf8.RuneCountInString(value.String()) > limit {
+			return fmt.Sprintf("must be at most %d characters", limit)
+		}
+		if value.Kind() == reflect.Slice && value.Len() > limit {
+			return fmt.Sprintf("must contain at most %d items", limit)
+		}
+	case "slug":
+		if value.String() != "" && !slugPattern.MatchString(value.String()) {
+			return "must contain only lowercase letters, digits and single hyphens"
+		}
+	case "hashtag":
+		if value.Kind() == reflect.Slice {
+			for i := 0; i < value.Len(); i++ {
+				if !validHashtag(value.Index(i).String()) {
+					return fmt.Sprintf("item %d must contain only letters, digits and underscores, optionally prefixed with #", i)
+				}
+			}
+		} else if value.String() != "" && !validHashtag(value.String()) {
+			return "must contain only letters, digits and underscores, optionally prefixed with #"
+		}
+	case "oneof":
+		allowed := strings.Fields(arg)
+		if value.Kind() == reflect.Map {
+			iter := value.MapRange()
+			for iter.Next() {
+				if !contains(allowed, iter.Value().String()) {
+					return fmt.Sprintf("value of %v must be one of %s", iter.Key(), strings.Join(allowed, ", "))
+				}
+			}
+		} else if value.String() != "" && !contains(allowed, value.String()) {
+			return "must be one of " + strings.Join(allowed, ", ")
+		}
+	case "dedupe":
+		dedupe(value)
+	}
+	return ""
+}
+
+func isEmpty(value reflect.Value) bool {
+	switch value.Kind() {
+	case reflect.String:
+		return strings.TrimSpace(value.String()) == ""
+	case reflect.Slice, reflect.Map:
+		return value.Len() == 0
+	default:
+		return value.IsZero()
+	}
+}
+
+// dedupe removes repeated entries from a slice while keeping the first occurrence order.
+func dedupe(value reflect.Value) {
+	if value.Kind() != reflect.Slice || value.IsNil() || !value.CanSet() {
+		return
+	}
+
+	seen := make(map[interface{}]bool, value.Len())
+	unique := reflect.MakeSlice(value.Type(), 0, value.Len())
+	for i := 0; i < value.Len(); i++ {
+		item := value.Index(i)
+		if seen[item.Interface()] {
+			continue
+		}
+		seen[item.Interface()] = true
+		unique = reflect.Append(unique, item)
+	}
+	value.Set(unique)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func fieldName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "" || name == "-" {
+		return field.Name
+	}
+	return name
+}
+
+// validHashtag checks the charset of a hashtag after NFKC normalization, the form it is stored
+// in, so decomposed input like e followed by a combining accent is accepted as é.
+func validHashtag(name string) bool {
+	return hashtagPattern.MatchString(norm.NFKC.String(name))
+}
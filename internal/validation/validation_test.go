@@ -0,0 +1,174 @@
+package validation
+
+import (
+	"reflect"
+	"testing"
+)
+
+type testUser struct {
+	Name string `json:"name" validate:"required,min=2,max=5"`
+}
+
+type testProject struct {
+	Slug    string   `json:"slug" validate:"required,slug"`
+	Hashtag string   `json:"hashtag" validate:"hashtag"`
+	UserIds []int    `json:"user_ids" validate:"dedupe,min=1,max=3"`
+	Tags    []string `validate:"max=2"`
+}
+
+type testMember struct {
+	Role  string         `json:"role" validate:"oneof=owner viewer"`
+	Roles map[int]string `json:"roles" validate:"oneof=owner viewer"`
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		v    interface{}
+		want Errors
+	}{
+		{
+			name: "valid user",
+			v:    &testUser{Name: "Ada"},
+		},
+		{
+			name: "required string",
+			v:    &testUser{},
+			want: Errors{{Field: "name", Message: "is required"}},
+		},
+		{
+			name: "blank string is missing",
+			v:    &testUser{Name: "   "},
+			want: Errors{{Field: "name", Message: "is required"}},
+		},
+		{
+			name: "string too short",
+			v:    &testUser{Name: "A"},
+			want: Errors{{Field: "name", Message: "must be at least 2 characters"}},
+		},
+		{
+			name: "string too long",
+			v:    &testUser{Name: "Grace Hopper"},
+			want: Errors{{Field: "name", Message: "must be at most 5 characters"}},
+		},
+		{
+			name: "length counts characters, not bytes",
+			v:    &testUser{Name: "Jörgé"},
+		},
+		{
+			name: "valid project",
+			v:    &testProject{Slug: "fold-search-2", Hashtag: "#go_lang", UserIds: []int{1, 2}},
+		},
+		{
+			name: "slug pattern",
+			v:    &testProject{Slug: "Fold Search", UserIds: []int{1}},
+			want: Errors{{Field: "slug", Message: "must contain only lowercase letters, digits and single hyphens"}},
+		},
+		{
+			name: "slug with double hyphen",
+			v:    &testProject{Slug: "fold--search", UserIds: []int{1}},
+			want: Errors{{Field: "slug", Message: "must contain only lowercase letters, digits and single hyphens"}},
+		},
+		{
+			name: "hashtag charset",
+			v:    &testProject{Slug: "fold", Hashtag: "go-lang", UserIds: []int{1}},
+			want: Errors{{Field: "hashtag", Message: "must contain only letters, digits and underscores, optionally prefixed with #"}},
+		},
+		{
+			name: "too few items",
+			v:    &testProject{Slug: "fold"},
+			want: Errors{{Field: "user_ids", Message: "must contain at least 1 items"}},
+		},
+		{
+			name: "duplicates do not count towards the limit",
+			v:    &testProject{Slug: "fold", UserIds: []int{1, 2, 1, 3, 2}},
+		},
+		{
+			name: "too many items",
+			v:    &testProject{Slug: "fold", UserIds: []int{1, 2, 3, 4}},
+			want: Errors{{Field: "user_ids", Message: "must contain at most 3 items"}},
+		},
+		{
+			name: "field without json tag uses the Go name",
+			v:    &testProject{Slug: "fold", UserIds: []int{1}, Tags: []string{"a", "b", "c"}},
+			want: Errors{{Field: "Tags", Message: "must contain at most 2 items"}},
+		},
+		{
+			name: "enum value",
+			v:    &testMember{Role: "viewer", Roles: map[int]string{1: "owner", 2: "viewer"}},
+		},
+		{
+			name: "empty enum value is allowed",
+			v:    &testMember{},
+		},
+		{
+			name: "enum value not allowed",
+			v:    &testMember{Role: "admin"},
+			want: Errors{{Field: "role", Message: "must be one of owner, viewer"}},
+		},
+		{
+			name: "enum map value not allowed",
+			v:    &testMember{Roles: map[int]string{7: "admin"}},
+			want: Errors{{Field: "roles", Message: "value of 7 must be one of owner, viewer"}},
+		},
+		{
+			name: "all violations are reported together",
+			v:    &testProject{Slug: "Fold", Hashtag: "#", UserIds: []int{1, 2, 3, 4}},
+			want: Errors{
+				{Field: "slug", Message: "must contain only lowercase letters, digits and single hyphens"},
+				{Field: "hashtag", Message: "must contain only letters, digits and underscores, optionally prefixed with #"},
+				{Field: "user_ids", Message: "must contain at most 3 items"},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := Validate(test.v)
+			if test.want == nil {
+				if err != nil {
+					t.Fatalf("unexpected error %v", err)
+				}
+				return
+			}
+			errs, ok := err.(Errors)
+			if !ok {
+				t.Fatalf("got error %v, want Errors", err)
+			}
+			if !reflect.DeepEqual(errs, test.want) {
+				t.Fatalf("got %v, want %v", errs, test.want)
+			}
+		})
+	}
+}
+
+func TestValidateDedupesInPlace(t *testing.T) {
+	project := testProject{Slug: "fold", UserIds: []int{3, 1, 3, 2, 1}}
+	err := Validate(&project)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int{3, 1, 2}; !reflect.DeepEqual(project.UserIds, want) {
+		t.Fatalf("user_ids %v, want %v", project.UserIds, want)
+	}
+}
+
+func TestValidateRequiresStructPointer(t *testing.T) {
+	for _, v := range []interface{}{testUser{Name: "Ada"}, "Ada", nil} {
+		err := Validate(v)
+		if err == nil {
+			t.Fatalf("Validate(%#v) succeeded", v)
+		}
+		if _, ok := err.(Errors); ok {
+			t.Fatalf("Validate(%#v) returned validation errors %v", v, err)
+		}
+	}
+}
+
+func TestErrorsError(t *testing.T) {
+	errs := Errors{{Field: "name", Message: "is required"}, {Field: "slug", Message: "must be at most 200 characters"}}
+	want := "name: is required; slug: must be at most 200 characters"
+	if got := errs.Error(); got != want {
+		t.Fatalf("Error() = %q, want %q", got, want)
+	}
+}